@@ -178,6 +178,67 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "train",
+			Usage: "import training sentences from a JSONL file",
+			Action: func(c *cli.Context) error {
+				l := log.New("")
+				l.SetFlags(0)
+				args := c.Args()
+				if len(args) != 1 {
+					l.Fatal(errors.New(`usage: abot train {file.jsonl}`))
+				}
+				count, err := importTrainingSentences(args.First())
+				if err != nil {
+					l.Fatalf("could not import training sentences\n%s", err)
+				}
+				l.Infof("Success. Imported %d training sentences.", count)
+				return nil
+			},
+		},
+		{
+			Name:  "nlp",
+			Usage: "evaluate natural language processing",
+			Subcommands: []cli.Command{
+				{
+					Name:  "eval",
+					Usage: "score NLP against labeled sentences in a JSONL file",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "baseline",
+							Usage: "report to compare against, failing on regressions",
+						},
+						cli.BoolFlag{
+							Name:  "save",
+							Usage: "save the report as the new baseline",
+						},
+						cli.Float64Flag{
+							Name:  "tolerance",
+							Usage: "largest fall in F1 score which isn't a regression",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						l := log.New("")
+						l.SetFlags(0)
+						args := c.Args()
+						if len(args) != 1 {
+							l.Fatal(errors.New(`usage: abot nlp eval [--baseline {report.json} [--save]] {file.jsonl}`))
+						}
+						regs, err := evalNLP(args.First(),
+							c.String("baseline"), c.Bool("save"),
+							c.Float64("tolerance"))
+						if err != nil {
+							l.Fatalf("could not evaluate NLP\n%s", err)
+						}
+						if len(regs) > 0 {
+							l.Fatalf("Regressions found:\n%s",
+								strings.Join(regs, "\n"))
+						}
+						return nil
+					},
+				},
+			},
+		},
 		{
 			Name:    "generate",
 			Aliases: []string{"g"},
@@ -241,6 +302,98 @@ func main() {
 	}
 }
 
+// importTrainingSentences adds the training sentences in a JSONL file to the
+// local training data of the abot in the current directory, then retrains its
+// intent model. Either every sentence is imported or none are.
+func importTrainingSentences(pth string) (int, error) {
+	fi, err := os.Open(pth)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err = fi.Close(); err != nil {
+			log.Info("failed to close training file.", err)
+		}
+	}()
+	db, err := core.ConnectDB("")
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	count, err := core.ImportTrainingSentences(tx, fi)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	// Running servers switch to the new intent model within a minute.
+	if err = core.TrainIntentModel(db); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// evalNLP scores Abot's NLP against the labeled sentences in a JSONL file,
+// printing the report. If a baseline report is given, the regressions found
+// against it are returned. If save is true, the report then replaces the
+// baseline.
+func evalNLP(pth, baseline string, save bool, tolerance float64) ([]string,
+	error) {
+
+	fi, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err = fi.Close(); err != nil {
+			log.Info("failed to close labeled sentences.", err)
+		}
+	}()
+	cs, err := core.ReadEvalCases(fi)
+	if err != nil {
+		return nil, err
+	}
+	if err = core.LoadNLP(); err != nil {
+		return nil, err
+	}
+	rep := core.Evaluate(cs)
+	if err = rep.Write(os.Stdout); err != nil {
+		return nil, err
+	}
+	if baseline == "" {
+		return nil, nil
+	}
+	var regs []string
+	byt, err := ioutil.ReadFile(baseline)
+	switch {
+	case os.IsNotExist(err) && save:
+		// There's nothing to compare against yet.
+	case err != nil:
+		return nil, err
+	default:
+		base := &core.EvalReport{}
+		if err = json.Unmarshal(byt, base); err != nil {
+			return nil, err
+		}
+		regs = rep.Regressions(base, tolerance)
+	}
+	if save {
+		byt, err = json.MarshalIndent(rep, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		if err = ioutil.WriteFile(baseline, byt, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return regs, nil
+}
+
 func searchPlugins(query string) error {
 	byt, err := searchItsAbot(query)
 	if err != nil {
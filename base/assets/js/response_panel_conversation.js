@@ -159,6 +159,14 @@ abot.ResponsePanelConversation.view = function(ctrl) {
 												m(".message-body-container", [
 													m(".message-body .embed-body", [
 														m("p", msg.Sentence),
+														function() {
+															if (!msg.Corrections || msg.Corrections.length === 0) {
+																return
+															}
+															return m("small", "Assumed " + msg.Corrections.map(function(c) {
+																return "\"" + c.Original + "\" meant \"" + c.Corrected + "\""
+															}).join(", "))
+														}(),
 														m(".comment-caret"),	
 													]),
 												]),
@@ -0,0 +1,2 @@
+ALTER TABLE cities DROP COLUMN lon;
+ALTER TABLE cities DROP COLUMN lat;
@@ -0,0 +1 @@
+ALTER TABLE scheduledevents DROP COLUMN recurrence;
@@ -0,0 +1,13 @@
+CREATE TABLE usersessions (
+	id SERIAL,
+	userid INTEGER NOT NULL DEFAULT 0,
+	flexid VARCHAR(255) NOT NULL DEFAULT '',
+	flexidtype INTEGER NOT NULL DEFAULT 0,
+	lastmessageat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	endedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX usersessions_open_idx ON usersessions (lastmessageat)
+	WHERE endedat IS NULL;
+ALTER TABLE messages ADD COLUMN sessionid INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+CREATE TABLE trainingsentences (
+	id SERIAL,
+	pluginname VARCHAR(255) NOT NULL,
+	intent VARCHAR(255) NOT NULL,
+	sentence TEXT NOT NULL,
+	source VARCHAR(255) NOT NULL,
+	deletedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updatedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (pluginname, intent, sentence)
+);
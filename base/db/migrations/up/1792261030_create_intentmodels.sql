@@ -0,0 +1,7 @@
+CREATE TABLE intentmodels (
+	id SERIAL,
+	data BYTEA NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+ALTER TABLE messages ADD COLUMN intentmodelversion INTEGER NOT NULL DEFAULT 0;
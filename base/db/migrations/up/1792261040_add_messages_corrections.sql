@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN corrections JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,2 @@
+ALTER TABLE cities ADD COLUMN lat DOUBLE PRECISION;
+ALTER TABLE cities ADD COLUMN lon DOUBLE PRECISION;
@@ -0,0 +1 @@
+ALTER TABLE scheduledevents ADD COLUMN recurrence VARCHAR(255);
@@ -0,0 +1,118 @@
+package core
+
+// bkTree is a Burkhard-Keller tree, an index of words by their edit distance
+// from one another. Searching it for words within a small distance of a
+// misspelled word visits only a fraction of the words indexed, since the
+// triangle inequality rules out whole subtrees.
+type bkTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	word     string
+	children map[int]*bkNode
+}
+
+// bkMatch is a word found by a search of a bkTree with its distance from the
+// word searched for.
+type bkMatch struct {
+	Word     string
+	Distance int
+}
+
+// add indexes a word. Adding a word already indexed has no effect.
+func (t *bkTree) add(word string) {
+	if t.root == nil {
+		t.root = &bkNode{word: word}
+		t.size++
+		return
+	}
+	n := t.root
+	for {
+		d := editDistance(n.word, word)
+		if d == 0 {
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = map[int]*bkNode{}
+			}
+			n.children[d] = &bkNode{word: word}
+			t.size++
+			return
+		}
+		n = child
+	}
+}
+
+// search returns the indexed words within maxDist edits of a word.
+func (t *bkTree) search(word string, maxDist int) []bkMatch {
+	var matches []bkMatch
+	if t.root == nil {
+		return matches
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := editDistance(n.word, word)
+		if d <= maxDist {
+			matches = append(matches, bkMatch{Word: n.word, Distance: d})
+		}
+		for cd, child := range n.children {
+			if cd >= d-maxDist && cd <= d+maxDist {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return matches
+}
+
+// has reports whether a word is indexed.
+func (t *bkTree) has(word string) bool {
+	n := t.root
+	for n != nil {
+		d := editDistance(n.word, word)
+		if d == 0 {
+			return true
+		}
+		n = n.children[d]
+	}
+	return false
+}
+
+// editDistance returns the Levenshtein distance between two words: the number
+// of single-letter insertions, deletions and substitutions needed to turn one
+// into the other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
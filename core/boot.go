@@ -19,14 +19,14 @@ import (
 	"github.com/itsabot/abot/shared/datatypes"
 	"github.com/itsabot/abot/shared/interface/email"
 	"github.com/itsabot/abot/shared/interface/sms"
-	"github.com/jbrukh/bayesian"
 	"github.com/jmoiron/sqlx"
 	"github.com/julienschmidt/httprouter"
 	_ "github.com/lib/pq" // Postgres driver
 )
 
 var db *sqlx.DB
-var ner classifier
+var ners map[string]classifier
+var tagger *posTagger
 var offensive map[string]struct{}
 var smsConn *sms.Conn
 var emailConn *email.Conn
@@ -34,21 +34,18 @@ var conf = &PluginJSON{Dependencies: map[string]string{}}
 var PluginsGo = []dt.PluginConfig{}
 var envLoaded bool
 
-// bClassifiers holds the trained bayesian classifiers for our plugins. The key
-// is the plugin ID to which the trained classifier belongs.
-var bClassifiers = map[uint64]*bayesian.Classifier{}
-
-// pluginIntents holds the intents for which each plugin has been trained. The
-// outer map divides the intents for each plugin by plugin ID.
-var pluginIntents = map[uint64][]bayesian.Class{}
-
-// tSentence is a training sentence retrieved from a remote source (defaults to
-// itsabot.org). To change the default source, set the ITSABOT_URL environment
-// variable.
+// tSentence is a sentence labeled with the intent it expresses, which is used
+// to train a plugin's intent classifier. Training sentences are stored locally
+// (see trainClassifiers).
 type tSentence struct {
-	ID       uint64
-	Sentence string
-	Intent   string
+	ID         uint64
+	PluginName string
+	Intent     string
+	Sentence   string
+	Source     string
+
+	// PluginID is the remote ID of the plugin, which is set only for
+	// sentences retrieved from the remote source.
 	PluginID uint64
 }
 
@@ -92,10 +89,7 @@ func NewServer() (r *httprouter.Router, err error) {
 		log.Info("failed loading plugins.go", err)
 		return nil, err
 	}
-	ner, err = buildClassifier()
-	if err != nil {
-		log.Debug("could not build classifier", err)
-	}
+	buildNLP()
 	go func() {
 		if os.Getenv("ABOT_ENV") != "test" {
 			log.Info("training classifiers")
@@ -104,6 +98,7 @@ func NewServer() (r *httprouter.Router, err error) {
 			log.Info("could not train classifiers", err)
 		}
 	}()
+	go reloadIntentModels(time.Minute)
 	offensive, err = buildOffensiveMap()
 	if err != nil {
 		log.Debug("could not build offensive map", err)
@@ -147,11 +142,18 @@ func NewServer() (r *httprouter.Router, err error) {
 		log.Debug("no email drivers imported")
 	}
 
+	// Enable plugins to push messages to users
+	dt.SetPushHandler(pushResponse)
+
 	// Send any scheduled events on boot and every minute
 	evtChan := make(chan *dt.ScheduledEvent)
+	go receiveEvents(evtChan)
 	go sendEventsTick(evtChan, time.Now())
 	go sendEvents(evtChan, 1*time.Minute)
 
+	// End idle conversation sessions every minute
+	go expireSessions(time.Minute)
+
 	// Update cached analytics data on boot and every 15 minutes
 	go updateAnalyticsTick(time.Now())
 	go updateAnalytics(15 * time.Minute)
@@ -159,6 +161,46 @@ func NewServer() (r *httprouter.Router, err error) {
 	return r, nil
 }
 
+// LoadNLP connects to the database and prepares Abot's NLP without starting a
+// server, for commands such as "abot eval" which analyze sentences offline.
+// Unlike NewServer, the intent model is trained or loaded before returning,
+// and nothing runs in the background, so no scheduled events are sent to
+// users.
+func LoadNLP() error {
+	if err := LoadEnvVars(); err != nil {
+		return err
+	}
+	if db == nil {
+		var err error
+		db, err = ConnectDB("")
+		if err != nil {
+			return fmt.Errorf("could not connect to database: %s", err.Error())
+		}
+	}
+	if err := LoadPluginsGo(); err != nil {
+		return err
+	}
+	buildNLP()
+	return trainClassifiers()
+}
+
+// buildNLP builds the entity classifiers, spelling dictionaries and
+// part-of-speech tagger of each language.
+func buildNLP() {
+	var err error
+	ners, err = buildClassifiers()
+	if err != nil {
+		log.Debug("could not build classifier", err)
+	}
+	for lang, ner := range ners {
+		spelling.setDictionary(lang, ner)
+	}
+	tagger, err = buildTagger()
+	if err != nil {
+		log.Debug("could not build POS tagger", err)
+	}
+}
+
 // compileAssets compresses and merges assets from Abot core and all plugins on
 // boot. In development, this step is repeated on each server HTTP request prior
 // to serving any assets.
@@ -349,56 +391,39 @@ func LoadPluginsGo() error {
 	return json.Unmarshal(val, &PluginsGo)
 }
 
-// trainClassifiers trains classifiers for each plugin.
+// trainClassifiers puts an intent model in use, with a classifier for each
+// plugin trained from Abot's local training data. The training data is first
+// seeded from each plugin's plugin.json and, unless disabled, from the remote
+// source (see fetchTrainingSentences). The saved intent model is loaded rather
+// than retrained if the training data hasn't changed since it was saved.
 func trainClassifiers() error {
-	for _, pconf := range PluginsGo {
-		ss, err := fetchTrainingSentences(pconf.ID, pconf.Name)
-		if err != nil {
-			return err
-		}
-
-		// Assemble list of Bayesian classes from all trained intents
-		// for this plugin. m is used to keep track of the classes
-		// already taught to each classifier.
-		m := map[string]struct{}{}
-		for _, s := range ss {
-			_, ok := m[s.Intent]
-			if ok {
-				continue
+	if err := seedTrainingSentences(db); err != nil {
+		return err
+	}
+	if remoteTrainingEnabled() {
+		for _, pconf := range PluginsGo {
+			err := cacheRemoteTrainingSentences(db, pconf)
+			if err != nil {
+				log.Info("failed to fetch remote training sentences for",
+					pconf.Name, err)
 			}
-			log.Debug("learning intent", s.Intent)
-			m[s.Intent] = struct{}{}
-			pluginIntents[s.PluginID] = append(pluginIntents[s.PluginID],
-				bayesian.Class(s.Intent))
 		}
-
-		// Build classifier from complete sets of intents
-		for _, s := range ss {
-			intents := pluginIntents[s.PluginID]
-			// Calling bayesian.NewClassifier() with 0 or 1
-			// classes causes a panic.
-			if len(intents) == 0 {
-				break
-			}
-			if len(intents) == 1 {
-				intents = append(intents, bayesian.Class("__no_intent"))
-			}
-			c := bayesian.NewClassifier(intents...)
-			bClassifiers[s.PluginID] = c
+	}
+	m, savedAt, err := loadIntentModel(db)
+	if err != nil {
+		log.Info("failed to load intent model", err)
+	} else if m != nil {
+		stale, err := intentModelStale(db, savedAt)
+		if err != nil {
+			return err
 		}
-
-		// With classifiers initialized, train each of them on a
-		// sentence's stems.
-		for _, s := range ss {
-			tokens := TokenizeSentence(s.Sentence)
-			stems := StemTokens(tokens)
-			c, exists := bClassifiers[s.PluginID]
-			if exists {
-				c.Learn(stems, bayesian.Class(s.Intent))
-			}
+		if !stale {
+			intentModels.set(m)
+			log.Debug("loaded intent model", m.Version)
+			return nil
 		}
 	}
-	return nil
+	return TrainIntentModel(db)
 }
 
 // fetchTrainingSentences retrieves training sentences from a remote source
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// errMissingSMSDriver is returned when sending an SMS without an SMS driver
+// installed.
+var errMissingSMSDriver = errors.New("No SMS driver installed.")
+
+// errMissingEmailDriver is returned when sending an email without an email
+// driver installed.
+var errMissingEmailDriver = errors.New("No email driver installed.")
+
+// errUnsupportedChannel is returned when sending a response to an unknown
+// channel.
+var errUnsupportedChannel = errors.New("unsupported channel")
+
+// sendResponse delivers a response to a user over the channel identified by
+// their flexid, rendering it for that medium. SMS receives plain text with
+// card images attached as media, email receives HTML, and web sessions receive
+// the full response as JSON over their websocket.
+func sendResponse(flexID string, flexIDType dt.FlexIDType, subj string,
+	resp *dt.Response) error {
+
+	switch flexIDType {
+	case dt.FIDTPhone:
+		if smsConn == nil {
+			return errMissingSMSDriver
+		}
+		return smsConn.SendMedia(flexID, resp.Text(), resp.Images())
+	case dt.FIDTEmail:
+		if emailConn == nil {
+			return errMissingEmailDriver
+		}
+		html, err := resp.HTML()
+		if err != nil {
+			return err
+		}
+		from := os.Getenv("ABOT_EMAIL")
+		return emailConn.SendHTML([]string{flexID}, from, subj, html)
+	case dt.FIDTSession:
+		return sessions.Send(flexID, resp)
+	}
+	return errUnsupportedChannel
+}
+
+// pushResponse delivers a message pushed by a plugin to the user over their
+// current channel, then saves it like any other response from Abot. It's set
+// as dt's push handler on boot.
+func pushResponse(p *dt.Plugin, in *dt.Msg, resp *dt.Response) error {
+	u := in.User
+	if len(u.FlexID) == 0 {
+		// The user's message didn't arrive over a specific channel, so
+		// use the one from which they most recently messaged Abot.
+		q := `SELECT flexid, flexidtype FROM messages
+		      WHERE userid=$1 AND abotsent IS FALSE AND flexid<>''
+		      ORDER BY createdat DESC
+		      LIMIT 1`
+		var ch struct {
+			FlexID     string
+			FlexIDType dt.FlexIDType
+		}
+		if err := db.Get(&ch, q, u.ID); err != nil {
+			return err
+		}
+		// Copy the user, so the message passed to the plugin isn't
+		// modified outside of the request.
+		uc := *u
+		uc.FlexID, uc.FlexIDType = ch.FlexID, ch.FlexIDType
+		u = &uc
+	}
+	// The request in which the plugin received in has likely ended, so
+	// don't inherit its canceled context.
+	sendPreResponseEvent(in.WithContext(context.Background()),
+		&resp.Sentence)
+	m := &dt.Msg{
+		User:      u,
+		Sentence:  resp.Sentence,
+		Plugin:    p,
+		Route:     in.Route,
+		AbotSent:  true,
+		SessionID: in.SessionID,
+	}
+	subj := "New message"
+	if p != nil && len(p.Config.Name) > 0 {
+		subj = "New message from " + p.Config.Name
+	}
+	err := sendResponse(u.FlexID, u.FlexIDType, subj, resp)
+	if err != nil {
+		return err
+	}
+	return m.Save(db)
+}
@@ -3,218 +3,651 @@ package core
 import (
 	"database/sql"
 	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/itsabot/abot/shared/datatypes"
-	"github.com/jmoiron/sqlx"
+	"github.com/itsabot/abot/shared/helpers/timeparse"
 )
 
 const (
-	keyContextTime   = "__contextTime"
-	keyContextPeople = "__contextPeople"
-
-	// TODO - but first need a more accurate measure of what's an object.
-	// False positives matter for this.
-	// keyContextObjects = "__contextObjects"
+	keyContextTime    = "__contextTime"
+	keyContextPeople  = "__contextPeople"
+	keyContextObjects = "__contextObjects"
 )
 
+// maxContextReferents is the most objects, places and selected items kept in
+// context. Older referents are forgotten as new ones are mentioned.
+const maxContextReferents = 10
+
+// referringPhrase is a phrase by which users refer to something mentioned
+// earlier, with the kinds of referent to which it may refer in order of
+// preference.
+type referringPhrase struct {
+	words []string
+	kinds []dt.ReferentKind
+}
+
+// referringPhrases are tried in order, so longer phrases must come before the
+// phrases they contain, e.g. "that one" before "that".
+var referringPhrases = map[string][]referringPhrase{
+	dt.LangEnglish: {
+		{[]string{"the", "same", "place"}, []dt.ReferentKind{
+			dt.ReferentPlace}},
+		{[]string{"that", "place"}, []dt.ReferentKind{
+			dt.ReferentPlace}},
+		{[]string{"that", "one"}, []dt.ReferentKind{
+			dt.ReferentSelection, dt.ReferentObject}},
+		{[]string{"this", "one"}, []dt.ReferentKind{
+			dt.ReferentSelection, dt.ReferentObject}},
+		{[]string{"it"}, []dt.ReferentKind{dt.ReferentSelection,
+			dt.ReferentObject}},
+		{[]string{"that"}, []dt.ReferentKind{dt.ReferentSelection,
+			dt.ReferentObject}},
+	},
+	dt.LangSpanish: {
+		{[]string{"el", "mismo", "lugar"}, []dt.ReferentKind{
+			dt.ReferentPlace}},
+		{[]string{"ese", "lugar"}, []dt.ReferentKind{
+			dt.ReferentPlace}},
+		{[]string{"ese"}, []dt.ReferentKind{dt.ReferentSelection,
+			dt.ReferentObject}},
+		{[]string{"esa"}, []dt.ReferentKind{dt.ReferentSelection,
+			dt.ReferentObject}},
+		{[]string{"eso"}, []dt.ReferentKind{dt.ReferentSelection,
+			dt.ReferentObject}},
+	},
+}
+
+// dummyVerbs follow or precede "it" when it refers to nothing, as in "it's
+// raining" or "is it sunny?".
+var dummyVerbs = wordSet("is", "was", "will", "does", "did", "seems",
+	"looks", "rains", "snows")
+
+// clauseEnds end a clause. "That" refers to something only at the end of a
+// clause, as in "order that", and not in "I think that we should go".
+var clauseEnds = wordSet(".", ",", ";", "!", "?", "and", "or", "but",
+	"please", "too", "again", "instead")
+
+// timeUnits are the units in which a time may be given relative to another,
+// e.g. "hour" in "an hour later".
+var timeUnits = wordSet("min", "mins", "minute", "minutes", "hour", "hours",
+	"day", "days", "week", "weeks", "month", "months", "year", "years")
+
+// timesOfDay may follow "that" to refer to part of a day discussed earlier,
+// e.g. "that morning".
+var timesOfDay = wordSet("morning", "afternoon", "evening", "night", "day")
+
+// timeWords are the other words which may describe a time, passed to timeparse
+// along with times of day, units of time and numbers.
+var timeWords = wordSet("a", "an", "the", "next", "last", "same", "time",
+	"later", "earlier", "after", "before", "that", "this", "in", "ago",
+	"few", "couple", "today", "tomorrow", "yesterday", "noon", "am", "pm",
+	"monday", "tuesday", "wednesday", "thursday", "friday", "saturday",
+	"sunday", "january", "february", "march", "april", "may", "june",
+	"july", "august", "september", "october", "november", "december")
+
+// rangeWords are the words which may describe a range of time or a duration,
+// e.g. "from 3 to 5pm" or "for two hours", passed to timeparse along with the
+// words which may describe a time.
+var rangeWords = wordSet("from", "to", "until", "till", "through", "thru",
+	"between", "and", "for", "half", "hr", "hrs", "weekend", "tonight",
+	"one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+	"ten", "eleven", "twelve")
+
 // saveContext records context in the database across multiple categories.
-func saveContext(db *sqlx.DB, in *dt.Msg) error {
+func saveContext(db dt.DBConn, in *dt.Msg) error {
 	if err := saveTimeContext(db, in); err != nil {
 		return err
 	}
 	if err := savePeopleContext(db, in); err != nil {
 		return err
 	}
+	if err := saveObjectContext(db, in); err != nil {
+		return err
+	}
 	return nil
 }
 
 // saveTimeContext records contextual information about the time being
 // discussed, enabling Abot to replace things like "then" with the time it
 // should represent.
-func saveTimeContext(db *sqlx.DB, in *dt.Msg) error {
+func saveTimeContext(db dt.DBConn, in *dt.Msg) error {
 	if len(in.StructuredInput.Times) == 0 {
 		return nil
 	}
-	byt, err := json.Marshal(in.StructuredInput.Times)
-	if err != nil {
-		return err
-	}
-	if in.User.ID > 0 {
-		q := `INSERT INTO states (key, value, userid, pluginname)
-		      VALUES ($1, $2, $3, '')
-		      ON CONFLICT (userid, pluginname, key)
-		      DO UPDATE SET value=$2`
-		_, err = db.Exec(q, keyContextTime, byt, in.User.ID)
-	} else {
-		q := `INSERT INTO states
-		      (key, value, flexid, flexidtype, pluginname)
-		      VALUES ($1, $2, $3, $4, '')
-		      ON CONFLICT (flexid, flexidtype, pluginname, key)
-		      DO UPDATE SET value=$2`
-		_, err = db.Exec(q, keyContextTime, byt, in.User.FlexID,
-			in.User.FlexIDType)
-	}
-	if err != nil {
-		return err
-	}
-	return nil
+	return saveState(db, in, keyContextTime, in.StructuredInput.Times)
 }
 
 // savePeopleContext records contextual information about people being
 // discussed, enabling Abot to replace things like "him", "her", or "they" with
-// the names the pronouns represent.
-func savePeopleContext(db *sqlx.DB, in *dt.Msg) error {
-	if len(in.StructuredInput.People) == 0 {
+// the names the pronouns represent. People are kept in a history across
+// turns, weighted by how recently they were mentioned and the part they
+// played in the sentence (see updatePeopleHistory). People referred to by
+// pronouns are recorded again too, keeping them salient.
+func savePeopleContext(db dt.DBConn, in *dt.Msg) error {
+	si := in.StructuredInput
+	if len(si.People) == 0 {
 		return nil
 	}
-	byt, err := json.Marshal(in.StructuredInput.People)
+	hist, err := getPeopleHistory(db, in)
 	if err != nil {
 		return err
 	}
-	if in.User.ID > 0 {
-		q := `INSERT INTO states (key, value, userid, pluginname)
-		      VALUES ($1, $2, $3, '')
-		      ON CONFLICT (userid, pluginname, key)
-		      DO UPDATE SET value=$2`
-		_, err = db.Exec(q, keyContextPeople, byt, in.User.ID)
-	} else {
-		q := `INSERT INTO states
-		      (key, value, flexid, flexidtype, pluginname)
-		      VALUES ($1, $2, $3, $4, '')
-		      ON CONFLICT (flexid, flexidtype, pluginname, key)
-		      DO UPDATE SET value=$2`
-		_, err = db.Exec(q, keyContextPeople, byt, in.User.FlexID,
-			in.User.FlexIDType)
+	hist = updatePeopleHistory(hist, si.People, mentionRoles(in.Tokens, si),
+		time.Now())
+	return saveState(db, in, keyContextPeople, hist)
+}
+
+// getPeopleHistory retrieves the history of people discussed with a user.
+// Histories saved before people were weighted hold no time of mention, so
+// they're treated as expired.
+func getPeopleHistory(db dt.DBConn, in *dt.Msg) ([]personMention, error) {
+	var hist []personMention
+	err := getState(db, in, keyContextPeople, &hist)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	if err != nil {
+	return hist, err
+}
+
+// saveObjectContext records the objects and places mentioned in a message,
+// enabling Abot to replace things like "it" or "the same place" with what they
+// represent. Words resolved by the part-of-speech tagger as commands, and
+// negated objects, aren't recorded.
+func saveObjectContext(db dt.DBConn, in *dt.Msg) error {
+	si := in.StructuredInput
+	var refs []dt.Referent
+	for _, o := range si.Objects {
+		if si.IsNegated(o) || si.IsFromContext(o) ||
+			si.Resolved[o] == dt.SITCommand || referringWord(o) {
+			continue
+		}
+		refs = append(refs, dt.Referent{
+			Kind: dt.ReferentObject,
+			Name: o,
+		})
+	}
+	for i := range si.Places {
+		if si.IsFromContext(si.Places[i].Name) {
+			continue
+		}
+		refs = append(refs, dt.Referent{
+			Kind:  dt.ReferentPlace,
+			Name:  si.Places[i].Name,
+			Place: &si.Places[i],
+		})
+	}
+	return rememberReferents(db, in, refs)
+}
+
+// saveSelectionContext records the items selected in Abot's response to a
+// message (see dt.Response.Select), so the user may refer to them as "that
+// one".
+func saveSelectionContext(db dt.DBConn, in *dt.Msg) error {
+	var refs []dt.Referent
+	for _, item := range in.Response.Selected {
+		refs = append(refs, dt.Referent{
+			Kind: dt.ReferentSelection,
+			Name: item,
+		})
+	}
+	return rememberReferents(db, in, refs)
+}
+
+// rememberReferents adds referents to those in context, most recent first. A
+// referent mentioned again moves to the front.
+func rememberReferents(db dt.DBConn, in *dt.Msg, refs []dt.Referent) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	var prev []dt.Referent
+	err := getState(db, in, keyContextObjects, &prev)
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
-	return nil
+	return saveState(db, in, keyContextObjects, mergeReferents(refs, prev))
+}
+
+// mergeReferents puts the referents mentioned in a message ahead of those
+// mentioned earlier, dropping duplicates and the oldest referents beyond
+// maxContextReferents.
+func mergeReferents(refs, prev []dt.Referent) []dt.Referent {
+	var merged []dt.Referent
+	seen := map[string]struct{}{}
+	for _, r := range append(refs, prev...) {
+		k := string(r.Kind) + ":" + strings.ToLower(r.Name)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		r.Phrase = ""
+		merged = append(merged, r)
+		if len(merged) == maxContextReferents {
+			break
+		}
+	}
+	return merged
 }
 
 // addContext to a Msg, filling in pronouns with the terms to which they refer.
 // The sentence/stems/tokens are left unmodified; addContext simply appends the
-// contextual terms to the StructuredInput when it's otherwise empty.
-func addContext(db *sqlx.DB, in *dt.Msg) error {
-	if len(in.StructuredInput.Times) == 0 {
-		if err := addTimeContext(db, in); err != nil {
-			return err
-		}
+// contextual terms to the StructuredInput when it's otherwise empty. Times
+// relative to those discussed earlier, e.g. "an hour later", replace the
+// message's Times.
+func addContext(db dt.DBConn, in *dt.Msg) error {
+	if err := addTimeContext(db, in); err != nil {
+		return err
 	}
 	if len(in.StructuredInput.People) == 0 {
 		if err := addPeopleContext(db, in); err != nil {
 			return err
 		}
 	}
+	if err := addObjectContext(db, in); err != nil {
+		return err
+	}
 	return nil
 }
 
-// addTimeContext adds a time context to a Message if the word "then" is found.
-func addTimeContext(db *sqlx.DB, in *dt.Msg) error {
-	var addContext bool
-	for _, stem := range in.Stems {
-		if stem == "then" {
-			addContext = true
-			break
+// addTimeContext adds a time context to a Message if the word "then" is found
+// and the message holds no times of its own. Follow-ups relative to the time
+// discussed earlier, such as "an hour later", "the day after", "same time next
+// week" or "earlier that morning", are parsed again from that time, which is
+// recorded as the message's TimeAnchor.
+func addTimeContext(db dt.DBConn, in *dt.Msg) error {
+	si := in.StructuredInput
+	phrase := anchoredExpression(in.Tokens)
+	if len(phrase) == 0 {
+		var then bool
+		for _, stem := range in.Stems {
+			if stem == "then" {
+				then = true
+				break
+			}
+		}
+		if !then || len(si.Times) > 0 {
+			return nil
 		}
 	}
-	if !addContext {
+	var times []time.Time
+	err := getState(db, in, keyContextTime, &times)
+	if err == sql.ErrNoRows {
 		return nil
 	}
-	var byt []byte
-	var err error
-	if in.User.ID > 0 {
-		q := `SELECT value FROM states WHERE userid=$1 AND key=$2`
-		err = db.Get(&byt, q, in.User.ID, keyContextTime)
-	} else {
-		q := `SELECT value FROM states
-		      WHERE flexid=$1 AND flexidtype=$2 AND key=$3`
-		err = db.Get(&byt, q, in.User.FlexID, in.User.FlexIDType,
-			keyContextTime)
+	if err != nil {
+		return err
 	}
-	if err == sql.ErrNoRows {
+	if len(phrase) == 0 {
+		si.Times = times
+		return nil
+	}
+	if len(times) == 0 {
+		return nil
+	}
+	anchor := times[0]
+	ts := timeparse.ParseFromTime(anchor, phrase)
+	if len(ts) == 0 {
+		return nil
+	}
+	si.Times = ts
+	si.TimeAnchor = &anchor
+	return nil
+}
+
+// anchoredExpression returns the words of a tokenized sentence which describe
+// a time relative to one discussed earlier, e.g. "an hour later" in "Remind me
+// an hour later", or "" if there are none. They're the run of words which may
+// describe a time around the word which anchors them, e.g. "later". A sentence
+// which holds a time of its own, as in "Remind me tomorrow at 5 or later",
+// isn't anchored to an earlier time.
+func anchoredExpression(tokens []string) string {
+	i := anchorIndex(tokens)
+	if i < 0 {
+		return ""
+	}
+	start, end := i, i+1
+	for start > 0 && isTimeToken(tokens, start-1) {
+		start--
+	}
+	for end < len(tokens) && isTimeToken(tokens, end) {
+		end++
+	}
+	rest := append(append([]string{}, tokens[:start]...), tokens[end:]...)
+	if len(timeparse.Parse(timeExpression(rest))) > 0 {
+		return ""
+	}
+	return timeExpression(tokens[start:end])
+}
+
+// anchorIndex returns the position of the word in a tokenized sentence which
+// makes a time relative to one discussed earlier, e.g. "later" in "an hour
+// later", or -1 if there's none. "After" and "before" count only following a
+// unit of time, as in "the day after", and not in "after lunch".
+func anchorIndex(tokens []string) int {
+	lower := func(i int) string {
+		if i < 0 || i >= len(tokens) {
+			return ""
+		}
+		return strings.ToLower(tokens[i])
+	}
+	for i := range tokens {
+		switch lower(i) {
+		case "later", "earlier":
+			return i
+		case "after", "before":
+			if _, ok := timeUnits[lower(i-1)]; ok {
+				return i
+			}
+		case "same":
+			if lower(i+1) == "time" || lower(i+1) == "day" {
+				return i
+			}
+		case "that":
+			if _, ok := timesOfDay[lower(i+1)]; ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// isTimeToken reports whether the token at i may be part of a time, including
+// a colon between numbers, as in "5:30".
+func isTimeToken(tokens []string, i int) bool {
+	t := tokens[i]
+	if t == ":" {
+		return i > 0 && i+1 < len(tokens) &&
+			strings.ContainsAny(tokens[i-1], "0123456789") &&
+			strings.ContainsAny(tokens[i+1], "0123456789")
+	}
+	lower := strings.ToLower(t)
+	_, isTimeWord := timeWords[lower]
+	_, isUnit := timeUnits[lower]
+	_, isTimeOfDay := timesOfDay[lower]
+	return isTimeWord || isUnit || isTimeOfDay ||
+		strings.ContainsAny(t, "0123456789")
+}
+
+// timeExpression returns the words of a tokenized sentence which may describe
+// a time, so other words don't prevent it being parsed, e.g. "an hour later"
+// in "Remind me an hour later". A colon between numbers is kept with them, as
+// in "5:30".
+func timeExpression(tokens []string) string {
+	return timePhrase(tokens, nil)
+}
+
+// timeRangeExpression returns the words of a tokenized sentence which may
+// describe a range of time or a duration, e.g. "from 3 to 5pm" in "Block off
+// my calendar from 3 to 5pm".
+func timeRangeExpression(tokens []string) string {
+	return timePhrase(tokens, rangeWords)
+}
+
+// timePhrase returns the words of a tokenized sentence which may describe a
+// time, along with any words in extra.
+func timePhrase(tokens []string, extra map[string]struct{}) string {
+	var words []string
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		lower := strings.ToLower(t)
+		_, isExtra := extra[lower]
+		_, isTimeWord := timeWords[lower]
+		_, isUnit := timeUnits[lower]
+		_, isTimeOfDay := timesOfDay[lower]
+		switch {
+		case lower == ":" && i+1 < len(tokens) && len(words) > 0:
+			words[len(words)-1] += ":" + tokens[i+1]
+			i++
+		case isExtra || isTimeWord || isUnit || isTimeOfDay ||
+			strings.ContainsAny(t, "0123456789"):
+			words = append(words, t)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// addPeopleContext adds people based on context to the sentence when
+// appropriate pronouns are found, like "us", "him", "her", or "them". A
+// singular pronoun refers to the most salient person of the right sex in the
+// user's history, or else to one of their contacts. People are never added
+// from a history which has expired (see contextExpiry).
+func addPeopleContext(db dt.DBConn, in *dt.Msg) error {
+	ref, ok := findPronouns(in.Tokens)
+	if !ok {
 		return nil
 	}
+	hist, err := getPeopleHistory(db, in)
 	if err != nil {
 		return err
 	}
-	var times []time.Time
-	if err = json.Unmarshal(byt, &times); err != nil {
+	now := time.Now()
+	people := resolvePeople(hist, ref, now)
+	if len(people) == 0 {
+		p, err := contactForPronoun(db, in, ref)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return nil
+		}
+		people = []dt.Person{*p}
+	}
+	si := in.StructuredInput
+	si.People = people
+	if si.FromContext == nil {
+		si.FromContext = map[string]bool{}
+	}
+	for _, p := range people {
+		si.FromContext[strings.ToLower(p.Name)] = true
+	}
+	return nil
+}
+
+// addObjectContext resolves phrases such as "it", "that one" or "the same
+// place" to the objects, places and selected items most recently mentioned,
+// adding them to the message's Referents. Resolved objects and places are also
+// added to its Objects and Places, marked as coming from context.
+func addObjectContext(db dt.DBConn, in *dt.Msg) error {
+	if !hasReferringPhrase(in.Tokens, in.Language) {
+		return nil
+	}
+	var refs []dt.Referent
+	err := getState(db, in, keyContextObjects, &refs)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	in.StructuredInput.Times = times
+	si := in.StructuredInput
+	for _, r := range resolveReferents(in.Tokens, in.Language, refs) {
+		si.Referents = append(si.Referents, r)
+		name := strings.ToLower(r.Name)
+		switch r.Kind {
+		case dt.ReferentObject:
+			si.Objects = append(si.Objects, name)
+		case dt.ReferentPlace:
+			si.Places = append(si.Places, *r.Place)
+		default:
+			continue
+		}
+		if si.FromContext == nil {
+			si.FromContext = map[string]bool{}
+		}
+		si.FromContext[name] = true
+	}
 	return nil
 }
 
-// addPeopleContext adds people based on context to the sentence when
-// appropriate pronouns are found, like "us", "him", "her", or "them".
-func addPeopleContext(db *sqlx.DB, in *dt.Msg) error {
-	var addContext, singular bool
-	var sex dt.Sex
-	for _, stem := range in.Stems {
-		switch stem {
-		case "us":
-			addContext = true
-		case "him", "he":
-			addContext, singular = true, true
-			if sex == dt.SexFemale {
-				sex = dt.SexEither
-			} else if sex != dt.SexEither {
-				sex = dt.SexMale
+// hasReferringPhrase reports whether a tokenized sentence may refer to
+// something mentioned earlier, so context is only retrieved when needed.
+func hasReferringPhrase(tokens []string, lang string) bool {
+	for i := range tokens {
+		if _, ok := referringPhraseAt(tokens, i, lang); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveReferents matches each referring phrase in a tokenized sentence to
+// the most recent referent in context of a kind to which it may refer. Refs
+// must be ordered most recent first.
+func resolveReferents(tokens []string, lang string,
+	refs []dt.Referent) []dt.Referent {
+
+	var resolved []dt.Referent
+	seen := map[int]struct{}{}
+	for i := 0; i < len(tokens); i++ {
+		p, ok := referringPhraseAt(tokens, i, lang)
+		if !ok {
+			continue
+		}
+		i += len(p.words) - 1
+	Kinds:
+		for _, k := range p.kinds {
+			for j, r := range refs {
+				if r.Kind != k {
+					continue
+				}
+				if _, ok := seen[j]; !ok {
+					seen[j] = struct{}{}
+					r.Phrase = strings.Join(p.words, " ")
+					resolved = append(resolved, r)
+				}
+				break Kinds
+			}
+		}
+	}
+	return resolved
+}
+
+// referringPhraseAt returns the referring phrase beginning at a token, if any.
+// "It" and "that" are skipped where they refer to nothing, as in "it's
+// raining" or "I think that we should go".
+func referringPhraseAt(tokens []string, i int, lang string) (referringPhrase,
+	bool) {
+
+	phrases, ok := referringPhrases[lang]
+	if !ok {
+		phrases = referringPhrases[dt.LangEnglish]
+	}
+	lower := func(j int) string {
+		if j < 0 || j >= len(tokens) {
+			return ""
+		}
+		return strings.ToLower(tokens[j])
+	}
+	for _, p := range phrases {
+		match := true
+		for j, w := range p.words {
+			if lower(i+j) != w {
+				match = false
+				break
 			}
-		case "her", "she":
-			addContext, singular = true, true
-			if sex == dt.SexMale {
-				sex = dt.SexEither
-			} else if sex != dt.SexEither {
-				sex = dt.SexFemale
+		}
+		if !match {
+			continue
+		}
+		end := i + len(p.words)
+		switch strings.Join(p.words, " ") {
+		case "it":
+			// Skip the apostrophe of a contraction, e.g. "it's".
+			next := end
+			if lower(next) == "'" {
+				next++
+			}
+			_, before := dummyVerbs[lower(i-1)]
+			_, after := dummyVerbs[lower(next)]
+			if before || after {
+				return referringPhrase{}, false
+			}
+		case "that":
+			if _, ok := clauseEnds[lower(end)]; end < len(tokens) &&
+				!ok {
+				return referringPhrase{}, false
 			}
-		case "them":
-			addContext = true
-			sex = dt.SexEither
 		}
+		return p, true
 	}
-	if !addContext {
-		return nil
+	return referringPhrase{}, false
+}
+
+// referringWord reports whether a word may refer to something mentioned
+// earlier, e.g. "it", so it isn't itself remembered as an object.
+func referringWord(w string) bool {
+	w = strings.ToLower(w)
+	for _, phrases := range referringPhrases {
+		for _, p := range phrases {
+			for _, pw := range p.words {
+				if pw == w {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// saveState records a value in the states table for a user, shared across all
+// plugins (with an empty pluginname). It's used by Abot core to remember
+// information between a user's messages, such as context.
+func saveState(db dt.DBConn, in *dt.Msg, key string, v interface{}) error {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if in.User.ID > 0 {
+		q := `INSERT INTO states (key, value, userid, pluginname)
+		      VALUES ($1, $2, $3, '')
+		      ON CONFLICT (userid, pluginname, key)
+		      DO UPDATE SET value=$2`
+		_, err = db.Exec(q, key, byt, in.User.ID)
+	} else {
+		q := `INSERT INTO states
+		      (key, value, flexid, flexidtype, pluginname)
+		      VALUES ($1, $2, $3, $4, '')
+		      ON CONFLICT (flexid, flexidtype, pluginname, key)
+		      DO UPDATE SET value=$2`
+		_, err = db.Exec(q, key, byt, in.User.FlexID,
+			in.User.FlexIDType)
 	}
+	return err
+}
+
+// getState retrieves a value saved with saveState, unmarshaling it into v. If
+// no value has been saved, sql.ErrNoRows is returned.
+func getState(db dt.DBConn, in *dt.Msg, key string, v interface{}) error {
 	var byt []byte
 	var err error
 	if in.User.ID > 0 {
 		q := `SELECT value FROM states WHERE userid=$1 AND key=$2`
-		err = db.Get(&byt, q, in.User.ID, keyContextPeople)
+		err = db.Get(&byt, q, in.User.ID, key)
 	} else {
 		q := `SELECT value FROM states
 		      WHERE flexid=$1 AND flexidtype=$2 AND key=$3`
-		err = db.Get(&byt, q, in.User.FlexID, in.User.FlexIDType,
-			keyContextPeople)
-	}
-	if err == sql.ErrNoRows {
-		return nil
+		err = db.Get(&byt, q, in.User.FlexID, in.User.FlexIDType, key)
 	}
 	if err != nil {
 		return err
 	}
-	var people []dt.Person
-	if err = json.Unmarshal(byt, &people); err != nil {
-		return err
-	}
+	return json.Unmarshal(byt, v)
+}
 
-	// Filter our people in context by criteria, like sex.
-	if !singular {
-		in.StructuredInput.People = people
-		return nil
-	}
-	if sex == dt.SexEither {
-		// To reach this point, we have at least one person in context.
-		in.StructuredInput.People = []dt.Person{people[0]}
-		return nil
-	}
-	for _, person := range people {
-		if person.Sex == sex {
-			in.StructuredInput.People = []dt.Person{person}
-			break
-		}
+// deleteState removes a value saved with saveState. It is not an error to
+// delete a key that does not exist.
+func deleteState(db dt.DBConn, in *dt.Msg, key string) error {
+	var err error
+	if in.User.ID > 0 {
+		q := `DELETE FROM states
+		      WHERE userid=$1 AND pluginname='' AND key=$2`
+		_, err = db.Exec(q, in.User.ID, key)
+	} else {
+		q := `DELETE FROM states
+		      WHERE flexid=$1 AND flexidtype=$2 AND pluginname=''
+		      AND key=$3`
+		_, err = db.Exec(q, in.User.FlexID, in.User.FlexIDType, key)
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestResolveReferents(t *testing.T) {
+	place := &dt.Place{Name: "Los Angeles"}
+	refs := []dt.Referent{
+		{Kind: dt.ReferentSelection, Name: "Joe's Pizza"},
+		{Kind: dt.ReferentObject, Name: "pizza"},
+		{Kind: dt.ReferentPlace, Name: "Los Angeles", Place: place},
+		{Kind: dt.ReferentObject, Name: "sushi"},
+	}
+	tests := []struct {
+		sent  string
+		names []string
+	}{
+		{"Book that one", []string{"Joe's Pizza"}},
+		{"Order it", []string{"Joe's Pizza"}},
+		{"Take me to the same place", []string{"Los Angeles"}},
+		{"Order that and deliver it to that place",
+			[]string{"Joe's Pizza", "Los Angeles"}},
+		{"It's raining", nil},
+		{"Is it sunny?", nil},
+		{"I think that we should go", nil},
+	}
+	for _, test := range tests {
+		tokens := TokenizeSentence(test.sent)
+		got := resolveReferents(tokens, dt.LangEnglish, refs)
+		if len(got) != len(test.names) {
+			t.Errorf("%q: expected %v, got %+v", test.sent,
+				test.names, got)
+			continue
+		}
+		for i, r := range got {
+			if r.Name != test.names[i] {
+				t.Errorf("%q: expected %q, got %q", test.sent,
+					test.names[i], r.Name)
+			}
+		}
+	}
+	got := resolveReferents(TokenizeSentence("Order it"), dt.LangEnglish,
+		refs[1:])
+	if len(got) != 1 || got[0].Name != "pizza" || got[0].Phrase != "it" {
+		t.Fatalf("expected it to refer to pizza, got %+v", got)
+	}
+}
+
+func TestMergeReferents(t *testing.T) {
+	prev := []dt.Referent{
+		{Kind: dt.ReferentObject, Name: "pizza"},
+		{Kind: dt.ReferentObject, Name: "sushi"},
+	}
+	refs := []dt.Referent{
+		{Kind: dt.ReferentObject, Name: "Sushi", Phrase: "it"},
+	}
+	merged := mergeReferents(refs, prev)
+	if len(merged) != 2 || merged[0].Name != "Sushi" ||
+		merged[1].Name != "pizza" {
+		t.Fatalf("expected sushi to move ahead of pizza, got %+v", merged)
+	}
+	if merged[0].Phrase != "" {
+		t.Fatal("expected phrase to be cleared")
+	}
+	for i := 0; i < maxContextReferents; i++ {
+		refs = append(refs, dt.Referent{
+			Kind: dt.ReferentObject,
+			Name: string(rune('a' + i)),
+		})
+	}
+	if merged = mergeReferents(refs, prev); len(merged) !=
+		maxContextReferents {
+		t.Fatalf("expected %d referents, got %d", maxContextReferents,
+			len(merged))
+	}
+}
+
+func TestAnchoredTime(t *testing.T) {
+	tests := map[string]bool{
+		"An hour later":              true,
+		"What about the day after?":  true,
+		"Same time next week":        true,
+		"Earlier that morning":       true,
+		"Remind me after lunch":      false,
+		"Book a table for 7pm":       false,
+		"Tell her that we are ready": false,
+	}
+	for sent, exp := range tests {
+		got := anchorIndex(TokenizeSentence(sent)) >= 0
+		if got != exp {
+			t.Errorf("%q: expected %t, got %t", sent, exp, got)
+		}
+	}
+}
+
+func TestAnchoredExpression(t *testing.T) {
+	tests := map[string]string{
+		"Remind me an hour later":            "an hour later",
+		"Move it to 5:30 the day after":      "5:30 the day after",
+		"Remind me tomorrow at 5 or later":   "",
+		"Book it for Friday, an hour later":  "",
+		"Earlier that morning":               "Earlier that morning",
+		"Can we do the same time next week?": "the same time next week",
+	}
+	for sent, exp := range tests {
+		got := anchoredExpression(TokenizeSentence(sent))
+		if got != exp {
+			t.Errorf("%q: expected %q, got %q", sent, exp, got)
+		}
+	}
+}
+
+func TestTimeExpression(t *testing.T) {
+	tests := map[string]string{
+		"Remind me an hour later":            "an hour later",
+		"Move it to 5:30 the day after":      "5:30 the day after",
+		"Can we do the same time next week?": "the same time next week",
+	}
+	for sent, exp := range tests {
+		tokens := TokenizeSentence(sent)
+		if got := timeExpression(tokens); got != exp {
+			t.Errorf("%q: expected %q, got %q", sent, exp, got)
+		}
+	}
+}
+
+func TestTimeRangeExpression(t *testing.T) {
+	tests := map[string]string{
+		"Block off my calendar from 3 to 5pm":      "from 3 to 5pm",
+		"Find a slot between Monday and Wednesday": "a between monday and wednesday",
+		"Book a table for two hours":               "a for two hours",
+	}
+	for sent, exp := range tests {
+		tokens := TokenizeSentence(sent)
+		got := strings.ToLower(timeRangeExpression(tokens))
+		if got != exp {
+			t.Errorf("%q: expected %q, got %q", sent, exp, got)
+		}
+	}
+}
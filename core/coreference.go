@@ -0,0 +1,284 @@
+package core
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// Salience of a person by the grammatical role they play in the message
+// mentioning them. The subject of a sentence, e.g. "Jim" in "Jim wants
+// pizza", is the likeliest to be referred to later, followed by the object of
+// a command, e.g. "Jim" in "Call Jim", and then by people mentioned in
+// passing, e.g. "Jim" in "Book a table for Jim".
+const (
+	salienceSubject = 1.0
+	salienceObject  = 0.8
+	salienceOblique = 0.5
+)
+
+const (
+	// contextHalfLife is the time after which a person mentioned is half
+	// as salient.
+	contextHalfLife = 10 * time.Minute
+
+	// contextExpiry is the time after which a person mentioned is
+	// forgotten, so stale context is never applied.
+	contextExpiry = time.Hour
+
+	// maxPeopleHistory is the most people kept in a user's history.
+	maxPeopleHistory = 10
+
+	// maxContactsChecked is the most of a user's contacts, most recently
+	// updated first, checked for one to whom a pronoun may refer.
+	maxContactsChecked = 25
+)
+
+// personMention is a person in a user's history of the people discussed, with
+// their salience when last mentioned.
+type personMention struct {
+	dt.Person
+	Salience float64
+	LastSeen time.Time
+}
+
+// score returns the salience of a mention decayed by the time since it was
+// last mentioned, or 0 once it has expired.
+func (m personMention) score(now time.Time) float64 {
+	age := now.Sub(m.LastSeen)
+	if m.LastSeen.IsZero() || age > contextExpiry {
+		return 0
+	}
+	if age < 0 {
+		age = 0
+	}
+	return m.Salience * math.Pow(0.5, float64(age)/float64(contextHalfLife))
+}
+
+// pronounRef describes the people to whom the pronouns in a message refer,
+// e.g. a single woman for "her", or several people for "them".
+type pronounRef struct {
+	plural bool
+	sex    dt.Sex
+}
+
+// pronounRefs maps pronouns to the people to which they refer.
+var pronounRefs = map[string]pronounRef{
+	"he":       {sex: dt.SexMale},
+	"him":      {sex: dt.SexMale},
+	"his":      {sex: dt.SexMale},
+	"himself":  {sex: dt.SexMale},
+	"she":      {sex: dt.SexFemale},
+	"her":      {sex: dt.SexFemale},
+	"hers":     {sex: dt.SexFemale},
+	"herself":  {sex: dt.SexFemale},
+	"us":       {plural: true, sex: dt.SexEither},
+	"they":     {plural: true, sex: dt.SexEither},
+	"them":     {plural: true, sex: dt.SexEither},
+	"their":    {plural: true, sex: dt.SexEither},
+	"él":       {sex: dt.SexMale},
+	"ella":     {sex: dt.SexFemale},
+	"ellos":    {plural: true, sex: dt.SexEither},
+	"ellas":    {plural: true, sex: dt.SexEither},
+	"nosotros": {plural: true, sex: dt.SexEither},
+}
+
+// findPronouns returns the people to which the pronouns in a message's tokens
+// refer, reporting false if there are no pronouns. A message referring to both
+// a man and a woman singularly, e.g. "Tell him to call her", refers to
+// whoever is most salient.
+func findPronouns(tokens []string) (pronounRef, bool) {
+	var ref pronounRef
+	var found bool
+	for _, t := range tokens {
+		r, ok := pronounRefs[strings.ToLower(t)]
+		if !ok {
+			continue
+		}
+		switch {
+		case !found:
+			ref = r
+		case r.plural:
+			ref.plural, ref.sex = true, dt.SexEither
+		case ref.sex != r.sex:
+			ref.sex = dt.SexEither
+		}
+		found = true
+	}
+	return ref, found
+}
+
+// mentionRoles returns the salience of each person mentioned in a message by
+// their grammatical role, keyed by their lowercase name. People named before
+// the first command are subjects, and those named just after a command are its
+// objects.
+func mentionRoles(tokens []string, si *dt.StructuredInput) map[string]float64 {
+	commands := map[int]struct{}{}
+	first := -1
+	for i, t := range tokens {
+		t = strings.ToLower(t)
+		for _, c := range si.Commands {
+			if t == c && !si.IsNegated(c) {
+				commands[i] = struct{}{}
+				if first < 0 {
+					first = i
+				}
+			}
+		}
+	}
+	roles := map[string]float64{}
+	for _, p := range si.People {
+		name := strings.ToLower(p.Name)
+		pos := -1
+		for i, t := range tokens {
+			if strings.ToLower(t) == name {
+				pos = i
+				break
+			}
+		}
+		_, afterCommand := commands[pos-1]
+		switch {
+		case pos < 0:
+			roles[name] = salienceOblique
+		case first >= 0 && pos < first:
+			roles[name] = salienceSubject
+		case afterCommand:
+			roles[name] = salienceObject
+		default:
+			roles[name] = salienceOblique
+		}
+	}
+	return roles
+}
+
+// updatePeopleHistory adds the people mentioned in a message to a user's
+// history. The salience of a person mentioned again builds on what remains of
+// their earlier salience. Expired mentions are dropped, and the rest are
+// ordered from most to least salient.
+func updatePeopleHistory(hist []personMention, people []dt.Person,
+	roles map[string]float64, now time.Time) []personMention {
+
+	var updated []personMention
+	byName := map[string]int{}
+	for _, m := range hist {
+		if m.score(now) == 0 {
+			continue
+		}
+		byName[strings.ToLower(m.Name)] = len(updated)
+		updated = append(updated, m)
+	}
+	for _, p := range people {
+		name := strings.ToLower(p.Name)
+		role, ok := roles[name]
+		if !ok {
+			role = salienceOblique
+		}
+		if i, ok := byName[name]; ok {
+			updated[i].Salience = updated[i].score(now) + role
+			updated[i].LastSeen = now
+			continue
+		}
+		byName[name] = len(updated)
+		updated = append(updated, personMention{
+			Person:   p,
+			Salience: role,
+			LastSeen: now,
+		})
+	}
+	sort.Stable(bySalience{updated, now})
+	if len(updated) > maxPeopleHistory {
+		updated = updated[:maxPeopleHistory]
+	}
+	return updated
+}
+
+// resolvePeople returns the people in a user's history to whom pronouns refer,
+// most salient first. Plural pronouns refer to everyone in the history. People
+// whose names are used by either sex are considered for "he" or "she", but
+// are half as salient as those whose sex matches.
+func resolvePeople(hist []personMention, ref pronounRef,
+	now time.Time) []dt.Person {
+
+	var people []dt.Person
+	if ref.plural {
+		for _, m := range hist {
+			if m.score(now) > 0 {
+				people = append(people, m.Person)
+			}
+		}
+		return people
+	}
+	var best *personMention
+	var bestScore float64
+	for i := range hist {
+		m := &hist[i]
+		s := m.score(now)
+		switch {
+		case ref.sex == dt.SexEither || m.Sex == ref.sex:
+		case m.Sex == dt.SexEither || m.Sex == dt.SexInvalid:
+			s /= 2
+		default:
+			continue
+		}
+		if s > bestScore {
+			best, bestScore = m, s
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return []dt.Person{best.Person}
+}
+
+// contactForPronoun returns the user's most recently updated contact to whom
+// a singular pronoun may refer, judging their sex by their first name. It's
+// used when no one in the user's history matches. Plural pronouns aren't
+// resolved to contacts, nor are the pronouns of users without an account.
+func contactForPronoun(db dt.DBConn, in *dt.Msg, ref pronounRef) (*dt.Person,
+	error) {
+
+	if ref.plural || in.User.ID == 0 {
+		return nil, nil
+	}
+	var names []string
+	q := `SELECT name FROM contacts WHERE userid=$1
+	      ORDER BY updatedat DESC LIMIT $2`
+	err := db.Select(&names, q, in.User.ID, maxContactsChecked)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ner := classifierFor(in.Language)
+	for _, name := range names {
+		sex := ner.sex(name)
+		if sex == dt.SexInvalid {
+			continue
+		}
+		if ref.sex == dt.SexEither || sex == ref.sex ||
+			sex == dt.SexEither {
+			return &dt.Person{Name: name, Sex: sex}, nil
+		}
+	}
+	return nil, nil
+}
+
+// bySalience implements sort.Interface to order people from most to least
+// salient at a given time.
+type bySalience struct {
+	mentions []personMention
+	now      time.Time
+}
+
+func (s bySalience) Len() int { return len(s.mentions) }
+func (s bySalience) Swap(i, j int) {
+	s.mentions[i], s.mentions[j] = s.mentions[j], s.mentions[i]
+}
+func (s bySalience) Less(i, j int) bool {
+	return s.mentions[i].score(s.now) > s.mentions[j].score(s.now)
+}
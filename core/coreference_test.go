@@ -0,0 +1,108 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestFindPronouns(t *testing.T) {
+	tests := []struct {
+		sent  string
+		found bool
+		ref   pronounRef
+	}{
+		{"Call her", true, pronounRef{sex: dt.SexFemale}},
+		{"Tell him to call her", true, pronounRef{sex: dt.SexEither}},
+		{"Invite them", true, pronounRef{plural: true,
+			sex: dt.SexEither}},
+		{"Order pizza", false, pronounRef{}},
+		{"Llama a él", true, pronounRef{sex: dt.SexMale}},
+		{"Reserva una mesa para nosotros", true, pronounRef{plural: true,
+			sex: dt.SexEither}},
+	}
+	for _, test := range tests {
+		ref, found := findPronouns(TokenizeSentence(test.sent))
+		if found != test.found || ref != test.ref {
+			t.Errorf("%q: expected %+v, got %+v", test.sent,
+				test.ref, ref)
+		}
+	}
+}
+
+func TestMentionRoles(t *testing.T) {
+	si := &dt.StructuredInput{
+		Commands: []string{"wants", "call", "book"},
+		People: []dt.Person{
+			{Name: "Jim"}, {Name: "Pam"}, {Name: "Dwight"},
+		},
+	}
+	tokens := TokenizeSentence(
+		"Jim wants me to call Pam and book a table for Dwight")
+	roles := mentionRoles(tokens, si)
+	exp := map[string]float64{
+		"jim":    salienceSubject,
+		"pam":    salienceObject,
+		"dwight": salienceOblique,
+	}
+	for name, role := range exp {
+		if roles[name] != role {
+			t.Errorf("expected %s to have salience %.1f, got %.1f",
+				name, role, roles[name])
+		}
+	}
+}
+
+func TestResolvePeople(t *testing.T) {
+	now := time.Date(2016, 3, 12, 9, 0, 0, 0, time.UTC)
+	pam := dt.Person{Name: "Pam", Sex: dt.SexFemale}
+	angela := dt.Person{Name: "Angela", Sex: dt.SexFemale}
+	jim := dt.Person{Name: "Jim", Sex: dt.SexMale}
+
+	// Angela was the subject of an earlier message, but Pam was mentioned
+	// more recently.
+	hist := updatePeopleHistory(nil, []dt.Person{angela},
+		map[string]float64{"angela": salienceSubject},
+		now.Add(-30*time.Minute))
+	hist = updatePeopleHistory(hist, []dt.Person{pam, jim},
+		map[string]float64{"pam": salienceObject}, now.Add(-time.Minute))
+	people := resolvePeople(hist, pronounRef{sex: dt.SexFemale}, now)
+	if len(people) != 1 || people[0].Name != "Pam" {
+		t.Fatalf("expected her to refer to Pam, got %+v", people)
+	}
+	people = resolvePeople(hist, pronounRef{sex: dt.SexMale}, now)
+	if len(people) != 1 || people[0].Name != "Jim" {
+		t.Fatalf("expected him to refer to Jim, got %+v", people)
+	}
+	people = resolvePeople(hist, pronounRef{plural: true,
+		sex: dt.SexEither}, now)
+	if len(people) != 3 {
+		t.Fatalf("expected them to refer to 3 people, got %+v", people)
+	}
+
+	// Stale context is never applied.
+	later := now.Add(contextExpiry + time.Minute)
+	people = resolvePeople(hist, pronounRef{sex: dt.SexFemale}, later)
+	if len(people) > 0 {
+		t.Fatalf("expected expired history to be ignored, got %+v",
+			people)
+	}
+	if hist = updatePeopleHistory(hist, nil, nil, later); len(hist) > 0 {
+		t.Fatalf("expected expired mentions to be dropped, got %+v",
+			hist)
+	}
+}
+
+func TestClassifierSex(t *testing.T) {
+	ner, err := buildClassifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sex := ner.sex("Jim Halpert"); sex != dt.SexMale {
+		t.Fatalf("expected Jim to be male, got %d", sex)
+	}
+	if sex := ner.sex("Xq"); sex != dt.SexInvalid {
+		t.Fatalf("expected unknown name, got %d", sex)
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// defaultPluginTimeout is the time a plugin may take to respond to a message
+// when its plugin.json doesn't specify a Timeout.
+const defaultPluginTimeout = 15 * time.Second
+
+// errPluginTimeout is returned when a plugin doesn't respond to a message
+// before its deadline.
+var errPluginTimeout = errors.New("plugin timed out")
+
+// timeoutReply is sent to the user when a plugin doesn't respond in time.
+const timeoutReply = "Sorry, that's taking longer than expected. Please try again in a moment."
+
+// pluginTimeout returns the time a plugin may take to respond to a message, as
+// defined by the Timeout in its plugin.json.
+func pluginTimeout(p *dt.Plugin) time.Duration {
+	if len(p.Config.Timeout) == 0 {
+		return defaultPluginTimeout
+	}
+	d, err := time.ParseDuration(p.Config.Timeout)
+	if err != nil || d <= 0 {
+		log.Info("invalid timeout for plugin", p.Config.Name,
+			p.Config.Timeout)
+		return defaultPluginTimeout
+	}
+	return d
+}
+
+// withPluginDeadline derives a context from ctx which is canceled when the
+// plugin's deadline passes.
+func withPluginDeadline(ctx context.Context, p *dt.Plugin) (context.Context,
+	context.CancelFunc) {
+
+	return context.WithTimeout(ctx, pluginTimeout(p))
+}
+
+// callPlugin calls a plugin with its deadline, returning errPluginTimeout if
+// the plugin doesn't respond in time, or the context's error if the user's
+// request ends first. Either way, the plugin's context is canceled and the
+// turn is rolled back. A plugin which keeps running shares the turn with the
+// message, so once the turn ends its queries through Plugin.Conn run against
+// the database rather than the turn's transaction.
+func callPlugin(p *dt.Plugin, in *dt.Msg, followup bool) (string, bool,
+	error) {
+
+	if p == nil {
+		return "", false, nil
+	}
+	ctx, cancel := withPluginDeadline(in.Context(), p)
+	defer cancel()
+	type result struct {
+		resp     string
+		smAnswer bool
+		err      error
+	}
+	ch := make(chan result, 1)
+	go func(in *dt.Msg) {
+		var r result
+		r.resp, r.smAnswer, r.err = dt.CallPlugin(p, in, followup)
+		ch <- r
+	}(in.WithContext(ctx))
+	select {
+	case r := <-ch:
+		return r.resp, r.smAnswer, r.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Info("plugin timed out", p.Config.Name)
+			return "", false, errPluginTimeout
+		}
+		return "", false, ctx.Err()
+	}
+}
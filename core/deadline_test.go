@@ -0,0 +1,60 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestCallPluginDeadline(t *testing.T) {
+	canceled := make(chan struct{})
+	p := &dt.Plugin{
+		Config: dt.PluginConfig{Name: "slow", Timeout: "10ms"},
+		Keywords: &dt.Keywords{DictCtx: map[string]dt.KeywordFnCtx{
+			"I_fast": func(ctx context.Context, in *dt.Msg) string {
+				return "done"
+			},
+			"I_slow": func(ctx context.Context, in *dt.Msg) string {
+				<-ctx.Done()
+				close(canceled)
+				return "too late"
+			},
+		}},
+	}
+	in := &dt.Msg{StructuredInput: &dt.StructuredInput{
+		Intents: []string{"fast"},
+	}}
+	resp, _, err := callPlugin(p, in, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != "done" {
+		t.Fatalf("expected %q, got %q", "done", resp)
+	}
+	in.StructuredInput.Intents = []string{"slow"}
+	if _, _, err = callPlugin(p, in, true); err != errPluginTimeout {
+		t.Fatal("expected errPluginTimeout, got", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected plugin's context to be canceled")
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	p := &dt.Plugin{}
+	if d := pluginTimeout(p); d != defaultPluginTimeout {
+		t.Fatal("expected default timeout, got", d)
+	}
+	p.Config.Timeout = "3s"
+	if d := pluginTimeout(p); d != 3*time.Second {
+		t.Fatal("expected 3s, got", d)
+	}
+	p.Config.Timeout = "soon"
+	if d := pluginTimeout(p); d != defaultPluginTimeout {
+		t.Fatal("expected default timeout, got", d)
+	}
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// keyDisambiguation is the memory key under which Abot remembers the plugins
+// it asked a user to choose between.
+const keyDisambiguation = "__disambiguation"
+
+// disambiguation is a question Abot asked the user to choose between multiple
+// matching plugins. It's saved between messages, so the user's answer can be
+// routed to the chosen plugin along with the commands, objects and intents of
+// the original message.
+type disambiguation struct {
+	Choices  []disambiguationChoice
+	Commands []string
+	Objects  []string
+	Intents  []string
+}
+
+// disambiguationChoice is a plugin offered to the user as a choice.
+type disambiguationChoice struct {
+	PluginName string
+	Route      string
+}
+
+// ordinals maps the stems of words users commonly use to select from a list of
+// choices to the index of the choice.
+var ordinals = map[string]int{
+	"1": 0, "first": 0, "former": 0,
+	"2": 1, "second": 1, "latter": 1,
+	"3": 2, "third": 2,
+}
+
+// disambiguationStopWords are ignored when matching a user's answer to the
+// name or usage of a plugin, since they're too common to identify a plugin.
+var disambiguationStopWords = map[string]struct{}{
+	"the": struct{}{}, "and": struct{}{}, "for": struct{}{},
+	"you": struct{}{}, "can": struct{}{}, "what": struct{}{},
+}
+
+// question builds a user-presentable question asking which of the ambiguous
+// plugins the user meant.
+func (e *errAmbiguousPlugin) question() string {
+	var choices []string
+	for _, c := range e.Candidates {
+		choices = append(choices, fmt.Sprintf("%q", describePlugin(c.Plugin)))
+	}
+	switch len(choices) {
+	case 0:
+		return ConfusedLang()
+	case 1:
+		return fmt.Sprintf("Did you mean %s?", choices[0])
+	case 2:
+		return fmt.Sprintf("Did you mean %s or %s?", choices[0],
+			choices[1])
+	}
+	l := len(choices) - 1
+	return fmt.Sprintf("Did you mean %s, or %s?",
+		strings.Join(choices[:l], ", "), choices[l])
+}
+
+// describePlugin returns a short, user-presentable description of what a
+// plugin does, preferring its first example usage.
+func describePlugin(p *dt.Plugin) string {
+	if len(p.Config.Usage) > 0 {
+		return p.Config.Usage[0]
+	}
+	return p.Config.Name
+}
+
+// saveDisambiguation remembers the plugins a user was asked to choose between.
+func saveDisambiguation(db dt.DBConn, in *dt.Msg, e *errAmbiguousPlugin) error {
+	d := disambiguation{
+		Commands: in.StructuredInput.Commands,
+		Objects:  in.StructuredInput.Objects,
+		Intents:  in.StructuredInput.Intents,
+	}
+	for _, c := range e.Candidates {
+		d.Choices = append(d.Choices, disambiguationChoice{
+			PluginName: c.Plugin.Config.Name,
+			Route:      c.Route,
+		})
+	}
+	return saveState(db, in, keyDisambiguation, d)
+}
+
+// resolveDisambiguation checks whether the user was asked to choose between
+// plugins, and if so, whether this message answers the question. The pending
+// question is cleared either way, so it's only ever asked once. If the user
+// chose a plugin, the commands, objects and intents of their original message
+// are added to this message, enabling the plugin's keywords to respond to the
+// original request.
+func resolveDisambiguation(db dt.DBConn, in *dt.Msg) (*pluginCandidate, error) {
+	var d disambiguation
+	err := getState(db, in, keyDisambiguation, &d)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = deleteState(db, in, keyDisambiguation); err != nil {
+		return nil, err
+	}
+	choice := matchDisambiguation(in, d.Choices)
+	if choice == nil {
+		log.Debug("user did not answer disambiguation")
+		return nil, nil
+	}
+	p := RegPlugins.getByName(choice.Route, choice.PluginName)
+	if p == nil {
+		return nil, nil
+	}
+	log.Debug("user chose plugin", p.Config.Name)
+	si := in.StructuredInput
+	si.Commands = append(si.Commands, d.Commands...)
+	si.Objects = append(si.Objects, d.Objects...)
+	si.Intents = append(si.Intents, d.Intents...)
+	return &pluginCandidate{Plugin: p, Route: choice.Route}, nil
+}
+
+// matchDisambiguation returns the choice selected by the user's message, either
+// by position ("the first one") or by naming it ("the restaurant"). If the
+// message doesn't clearly select one choice, nil is returned.
+func matchDisambiguation(in *dt.Msg,
+	choices []disambiguationChoice) *disambiguationChoice {
+
+	for _, stem := range in.Stems {
+		if i, ok := ordinals[stem]; ok && i < len(choices) {
+			return &choices[i]
+		}
+	}
+	stems := map[string]struct{}{}
+	for _, stem := range in.Stems {
+		stems[stem] = struct{}{}
+	}
+	var best *disambiguationChoice
+	var bestCount, tied int
+	for i := range choices {
+		var words []string
+		words = append(words, choices[i].PluginName)
+		if p := RegPlugins.getByName(choices[i].Route,
+			choices[i].PluginName); p != nil {
+			words = append(words, p.Config.Usage...)
+		}
+		route := strings.TrimPrefix(choices[i].Route, "CO_")
+		route = strings.TrimPrefix(route, "I_")
+		words = append(words, strings.Split(route, "_")...)
+		var count int
+		seen := map[string]struct{}{}
+		for _, stem := range StemTokens(TokenizeSentence(
+			strings.Join(words, " "))) {
+
+			if len(stem) < 3 {
+				continue
+			}
+			if _, ok := disambiguationStopWords[stem]; ok {
+				continue
+			}
+			if _, ok := seen[stem]; ok {
+				continue
+			}
+			seen[stem] = struct{}{}
+			if _, ok := stems[stem]; ok {
+				count++
+			}
+		}
+		switch {
+		case count > bestCount:
+			best, bestCount, tied = &choices[i], count, 0
+		case count == bestCount && count > 0:
+			tied++
+		}
+	}
+	if tied > 0 {
+		return nil
+	}
+	return best
+}
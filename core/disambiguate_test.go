@@ -0,0 +1,49 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestMatchDisambiguation(t *testing.T) {
+	choices := []disambiguationChoice{
+		{PluginName: "restaurant", Route: "CO_find_restaurant"},
+		{PluginName: "weather", Route: "CO_check_weather"},
+	}
+	tests := map[string]string{
+		"the second one":           "weather",
+		"the first":                "restaurant",
+		"the restaurant one":       "restaurant",
+		"I meant weather":          "weather",
+		"neither of those, thanks": "",
+	}
+	for sentence, exp := range tests {
+		in := &dt.Msg{Sentence: sentence}
+		in.Stems = StemTokens(TokenizeSentence(sentence))
+		c := matchDisambiguation(in, choices)
+		var got string
+		if c != nil {
+			got = c.PluginName
+		}
+		if got != exp {
+			t.Errorf("%q: expected %q, got %q", sentence, exp, got)
+		}
+	}
+}
+
+func TestRankPluginCandidates(t *testing.T) {
+	cands := []*pluginCandidate{
+		{Route: "a", Score: 1},
+		{Route: "b", Score: 3},
+		{Route: "c", Score: 1},
+	}
+	cands = rankPluginCandidates(cands)
+	exp := []string{"b", "a", "c"}
+	for i := range exp {
+		if cands[i].Route != exp[i] {
+			t.Fatalf("expected %s at %d, got %s", exp[i], i,
+				cands[i].Route)
+		}
+	}
+}
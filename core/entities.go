@@ -0,0 +1,240 @@
+package core
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+var regexEntityEmail = regexp.MustCompile(
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+var regexEntityURL = regexp.MustCompile(
+	`(?i)\b(?:https?://|www\.)[^\s<>"]+|` +
+		`\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|org|net|io|edu|gov|co)\b(?:/[^\s<>"]*)?`)
+
+var regexEntityPhone = regexp.MustCompile(
+	`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{3}\)\s?|\b\d{3}[\s.-]?)\d{3}[\s.-]?\d{4}\b`)
+
+var regexEntityMoney = regexp.MustCompile(
+	`(?i)([$€£¥])\s?(\d[\d,]*(?:\.\d+)?)|` +
+		`\b(\d[\d,]*(?:\.\d+)?)\s?(dollars?|bucks|usd|euros?|eur|pounds?|gbp|pesos?|mxn|cents?)\b`)
+
+var regexEntityPercentage = regexp.MustCompile(
+	`(?i)\b(\d+(?:\.\d+)?)\s?(?:%|percent\b|por\s+ciento\b)`)
+
+var regexEntityNumber = regexp.MustCompile(`\b\d[\d,]*(?:\.\d+)?\b`)
+
+// currencies maps currency symbols and names to their ISO 4217 codes.
+var currencies = map[string]string{
+	"$": "USD", "dollar": "USD", "dollars": "USD", "bucks": "USD",
+	"usd": "USD", "cent": "USD", "cents": "USD",
+	"€": "EUR", "euro": "EUR", "euros": "EUR", "eur": "EUR",
+	"£": "GBP", "pound": "GBP", "pounds": "GBP", "gbp": "GBP",
+	"¥": "JPY", "peso": "MXN", "pesos": "MXN", "mxn": "MXN",
+}
+
+// numberWords are quantities written as words. "One" and "a" are left out,
+// since they more often serve as pronouns and articles, as in "that one", as
+// is the Spanish "once", which is also an English word.
+var numberWords = map[string]float64{
+	"two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7,
+	"eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+	"dozen": 12, "dos": 2, "tres": 3, "cuatro": 4, "cinco": 5, "seis": 6,
+	"siete": 7, "ocho": 8, "nueve": 9, "diez": 10, "doce": 12,
+	"docena": 12,
+}
+
+// entityMatch is an entity found in a sentence along with its position in
+// bytes.
+type entityMatch struct {
+	dt.Entity
+	start, end int
+}
+
+// extractEntities finds the typed entities in a sentence, such as amounts of
+// money and email addresses. Each entity's span refers to the tokens of the
+// sentence, which are found by splitting it just as tokenize does. Where
+// entities overlap, the first type found wins, in the order: email, URL,
+// phone, money, percentage, quantity.
+func extractEntities(sent string) []dt.Entity {
+	var matches []entityMatch
+	claim := func(start, end int, e dt.Entity) {
+		for _, m := range matches {
+			if start < m.end && end > m.start {
+				return
+			}
+		}
+		e.Text = sent[start:end]
+		matches = append(matches, entityMatch{e, start, end})
+	}
+	for _, loc := range regexEntityEmail.FindAllStringIndex(sent, -1) {
+		claim(loc[0], loc[1], dt.Entity{
+			Type:  dt.EntityEmail,
+			Value: strings.ToLower(sent[loc[0]:loc[1]]),
+		})
+	}
+	for _, loc := range regexEntityURL.FindAllStringIndex(sent, -1) {
+		end := loc[0] + len(strings.TrimRight(sent[loc[0]:loc[1]],
+			".,;:!?)'\""))
+		claim(loc[0], end, dt.Entity{
+			Type:  dt.EntityURL,
+			Value: normalizeURL(sent[loc[0]:end]),
+		})
+	}
+	for _, loc := range regexEntityPhone.FindAllStringIndex(sent, -1) {
+		claim(loc[0], loc[1], dt.Entity{
+			Type:  dt.EntityPhone,
+			Value: normalizePhone(sent[loc[0]:loc[1]]),
+		})
+	}
+	for _, loc := range regexEntityMoney.FindAllStringSubmatchIndex(sent,
+		-1) {
+		var num, unit string
+		if loc[2] >= 0 {
+			unit, num = sent[loc[2]:loc[3]], sent[loc[4]:loc[5]]
+		} else {
+			num, unit = sent[loc[6]:loc[7]], sent[loc[8]:loc[9]]
+		}
+		unit = strings.ToLower(unit)
+		n, err := parseNumber(num)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(unit, "cent") {
+			n /= 100
+		}
+		claim(loc[0], loc[1], dt.Entity{
+			Type:     dt.EntityMoney,
+			Number:   n,
+			Currency: currencies[unit],
+		})
+	}
+	for _, loc := range regexEntityPercentage.FindAllStringSubmatchIndex(
+		sent, -1) {
+		n, err := parseNumber(sent[loc[2]:loc[3]])
+		if err != nil {
+			continue
+		}
+		claim(loc[0], loc[1], dt.Entity{
+			Type:   dt.EntityPercentage,
+			Number: n,
+		})
+	}
+	for _, loc := range regexEntityNumber.FindAllStringIndex(sent, -1) {
+		// Skip the parts of times and dates, e.g. "5:30" and "12/25".
+		if loc[0] > 0 && strings.ContainsAny(sent[loc[0]-1:loc[0]],
+			":/") {
+			continue
+		}
+		if loc[1] < len(sent) && strings.ContainsAny(
+			sent[loc[1]:loc[1]+1], ":/") {
+			continue
+		}
+		n, err := parseNumber(sent[loc[0]:loc[1]])
+		if err != nil {
+			continue
+		}
+		claim(loc[0], loc[1], dt.Entity{
+			Type:   dt.EntityQuantity,
+			Number: n,
+		})
+	}
+	raw := splitTokens(sent)
+	offsets := tokenOffsets(sent, raw)
+	for i, t := range raw {
+		n, ok := numberWords[strings.ToLower(t)]
+		if !ok {
+			continue
+		}
+		claim(offsets[i], offsets[i]+len(t), dt.Entity{
+			Type:   dt.EntityQuantity,
+			Number: n,
+		})
+	}
+	sort.Sort(byPosition(matches))
+	var entities []dt.Entity
+	for _, m := range matches {
+		m.Start, m.End = tokenSpan(offsets, raw, m.start, m.end)
+		entities = append(entities, m.Entity)
+	}
+	return entities
+}
+
+// tokenOffsets returns the position in bytes of each token within the
+// sentence. The tokens must be those returned by splitTokens, before any
+// contractions were expanded.
+func tokenOffsets(sent string, tokens []string) []int {
+	offsets := make([]int, len(tokens))
+	var pos int
+	for i, t := range tokens {
+		if j := strings.Index(sent[pos:], t); j >= 0 {
+			pos += j
+		}
+		offsets[i] = pos
+		pos += len(t)
+	}
+	return offsets
+}
+
+// tokenSpan returns the span of tokens overlapping the bytes from start to end.
+func tokenSpan(offsets []int, tokens []string, start, end int) (int, int) {
+	first, last := -1, -1
+	for i, t := range tokens {
+		if len(t) == 0 {
+			continue
+		}
+		if offsets[i] < end && offsets[i]+len(t) > start {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return 0, 0
+	}
+	return first, last + 1
+}
+
+// parseNumber parses a number which may contain commas, e.g. "1,000.50".
+func parseNumber(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(s, ",", "", -1), 64)
+}
+
+// normalizePhone reduces a phone number to its digits, prefixed by its country
+// code. Ten-digit numbers are assumed to be in the US.
+func normalizePhone(s string) string {
+	var digits []rune
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if !strings.HasPrefix(strings.TrimSpace(s), "+") && len(digits) == 10 {
+		return "+1" + string(digits)
+	}
+	return "+" + string(digits)
+}
+
+// normalizeURL adds the scheme to URLs written without one, e.g.
+// "www.example.com".
+func normalizeURL(s string) string {
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "http://") ||
+		strings.HasPrefix(lower, "https://") {
+		return s
+	}
+	return "http://" + s
+}
+
+// byPosition implements sort.Interface to order entity matches by their
+// position in the sentence.
+type byPosition []entityMatch
+
+func (m byPosition) Len() int           { return len(m) }
+func (m byPosition) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byPosition) Less(i, j int) bool { return m[i].start < m[j].start }
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestExtractEntities(t *testing.T) {
+	tests := []struct {
+		sent     string
+		typ      dt.EntityType
+		text     string
+		number   float64
+		currency string
+		value    string
+	}{
+		{"It costs $5.99", dt.EntityMoney, "$5.99", 5.99, "USD", ""},
+		{"Send me 20 euros", dt.EntityMoney, "20 euros", 20, "EUR", ""},
+		{"Tip 12.5% please", dt.EntityPercentage, "12.5%", 12.5, "", ""},
+		{"Email jim@example.com", dt.EntityEmail, "jim@example.com", 0, "",
+			"jim@example.com"},
+		{"Call (415) 555-0100", dt.EntityPhone, "(415) 555-0100", 0, "",
+			"+14155550100"},
+		{"Go to www.example.com.", dt.EntityURL, "www.example.com", 0, "",
+			"http://www.example.com"},
+		{"Order two pizzas", dt.EntityQuantity, "two", 2, "", ""},
+		{"Order 3 pizzas", dt.EntityQuantity, "3", 3, "", ""},
+	}
+	for _, test := range tests {
+		es := extractEntities(test.sent)
+		if len(es) != 1 {
+			t.Errorf("%q: expected 1 entity, got %+v", test.sent, es)
+			continue
+		}
+		e := es[0]
+		if e.Type != test.typ || e.Text != test.text ||
+			e.Number != test.number || e.Currency != test.currency ||
+			e.Value != test.value {
+			t.Errorf("%q: unexpected entity %+v", test.sent, e)
+		}
+	}
+}
+
+func TestExtractEntitiesSpan(t *testing.T) {
+	sent := "Order 3 pizzas for $20"
+	tokens := TokenizeSentence(sent)
+	es := extractEntities(sent)
+	if len(es) != 2 {
+		t.Fatalf("expected 2 entities, got %+v", es)
+	}
+	if es[0].Type != dt.EntityQuantity || es[1].Type != dt.EntityMoney {
+		t.Fatalf("unexpected entities %+v", es)
+	}
+	if got := tokens[es[0].Start:es[0].End]; len(got) != 1 ||
+		got[0] != "3" {
+		t.Errorf("expected span of quantity to be [3], got %v", got)
+	}
+	if got := tokens[es[1].Start:es[1].End]; len(got) != 1 ||
+		got[0] != "$20" {
+		t.Errorf("expected span of money to be [$20], got %v", got)
+	}
+	if es := extractEntities("Meet at 5:30 on 12/25"); len(es) > 0 {
+		t.Errorf("expected no entities in times and dates, got %+v", es)
+	}
+}
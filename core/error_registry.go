@@ -0,0 +1,206 @@
+package core
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// errorAlertWindow is the period over which the error rate is measured. Admins
+// are alerted at most once per window.
+const errorAlertWindow = 5 * time.Minute
+
+// defaultErrorAlertThreshold is the number of errors within errorAlertWindow
+// which triggers an alert, unless ABOT_ERROR_ALERT_THRESHOLD is set.
+const defaultErrorAlertThreshold = 10
+
+// maxErrorRecords is the number of distinct errors kept in the registry. Once
+// it's reached, the least recently seen error is dropped to make room for a
+// new one, so errors which include unique details, e.g. IDs, can't grow the
+// registry without bound.
+const maxErrorRecords = 1000
+
+// errorRecord describes an error encountered while processing user messages.
+// Errors are grouped by their route, plugin and message, and the ID of the
+// most recent message to fail is kept for debugging.
+type errorRecord struct {
+	Error     string
+	Route     string
+	Plugin    string
+	MessageID uint64
+	Count     uint64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// errorRegistry is a thread-safe registry counting the errors encountered
+// while processing user messages.
+type errorRegistry struct {
+	records   map[string]*errorRecord
+	recent    []time.Time
+	alertedAt time.Time
+	mutex     *sync.Mutex
+}
+
+// errReg holds the errors encountered since Abot booted. It's available to
+// admins through the admin API.
+var errReg = errorRegistry{
+	records: map[string]*errorRecord{},
+	mutex:   &sync.Mutex{},
+}
+
+// add records an error, returning a copy of its record and whether admins
+// should be alerted, i.e. the error rate crossed the threshold and admins
+// haven't been alerted within errorAlertWindow.
+func (er *errorRegistry) add(in *dt.Msg, err error, now time.Time) (
+	errorRecord, bool) {
+
+	rec := errorRecord{Error: err.Error()}
+	if in != nil {
+		rec.Route = in.Route
+		rec.MessageID = in.ID
+		if in.Plugin != nil {
+			rec.Plugin = in.Plugin.Config.Name
+		}
+	}
+	key := rec.Route + "\x00" + rec.Plugin + "\x00" + rec.Error
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+	r, ok := er.records[key]
+	if !ok {
+		if len(er.records) >= maxErrorRecords {
+			er.evictOldest()
+		}
+		r = &rec
+		r.FirstSeen = now
+		er.records[key] = r
+	}
+	r.Count++
+	r.LastSeen = now
+	r.MessageID = rec.MessageID
+
+	// Drop errors which fell out of the window
+	cutoff := now.Add(-errorAlertWindow)
+	i := 0
+	for i < len(er.recent) && er.recent[i].Before(cutoff) {
+		i++
+	}
+	er.recent = append(er.recent[i:], now)
+	alert := len(er.recent) >= errorAlertThreshold() &&
+		now.Sub(er.alertedAt) >= errorAlertWindow
+	if alert {
+		er.alertedAt = now
+	}
+	return *r, alert
+}
+
+// evictOldest removes the least recently seen error record. The caller must
+// hold the lock.
+func (er *errorRegistry) evictOldest() {
+	var oldest string
+	var seen time.Time
+	for key, r := range er.records {
+		if len(oldest) == 0 || r.LastSeen.Before(seen) {
+			oldest = key
+			seen = r.LastSeen
+		}
+	}
+	delete(er.records, oldest)
+}
+
+// all returns a copy of every error record, most recent first.
+func (er *errorRegistry) all() []errorRecord {
+	er.mutex.Lock()
+	recs := make([]errorRecord, 0, len(er.records))
+	for _, r := range er.records {
+		recs = append(recs, *r)
+	}
+	er.mutex.Unlock()
+	sort.Sort(byLastSeen(recs))
+	return recs
+}
+
+// recentCount returns the number of errors within errorAlertWindow.
+func (er *errorRegistry) recentCount() int {
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+	return len(er.recent)
+}
+
+// byLastSeen implements sort.Interface to order error records from most to
+// least recently seen.
+type byLastSeen []errorRecord
+
+func (s byLastSeen) Len() int           { return len(s) }
+func (s byLastSeen) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLastSeen) Less(i, j int) bool { return s[i].LastSeen.After(s[j].LastSeen) }
+
+// errorAlertThreshold returns the number of errors within errorAlertWindow
+// which triggers an alert. It's configured through the
+// ABOT_ERROR_ALERT_THRESHOLD env var.
+func errorAlertThreshold() int {
+	t := os.Getenv("ABOT_ERROR_ALERT_THRESHOLD")
+	if len(t) == 0 {
+		return defaultErrorAlertThreshold
+	}
+	n, err := strconv.Atoi(t)
+	if err != nil || n <= 0 {
+		log.Info("invalid ABOT_ERROR_ALERT_THRESHOLD", t)
+		return defaultErrorAlertThreshold
+	}
+	return n
+}
+
+// reportError records an error encountered while processing a user's message,
+// notifies plugins listening for errors, and alerts admins if errors are
+// occurring too frequently. The message is nil if the error occurred before
+// the message was built.
+func reportError(in *dt.Msg, err error) {
+	rec, alert := errReg.add(in, err, time.Now())
+	for _, p := range AllPlugins {
+		p.Events.OnError(in, err)
+	}
+	if alert {
+		go func() {
+			if err := alertAdmins(rec, errReg.recentCount()); err != nil {
+				log.Info("failed to alert admins of errors", err)
+			}
+		}()
+	}
+}
+
+// alertAdmins emails every admin through the configured email driver that the
+// error rate crossed the threshold, including the details of the error which
+// triggered the alert.
+func alertAdmins(rec errorRecord, count int) error {
+	if emailConn == nil {
+		return errMissingEmailDriver
+	}
+	var admins []string
+	q := `SELECT email FROM users WHERE admin=TRUE`
+	if err := db.Select(&admins, q); err != nil {
+		return err
+	}
+	if len(admins) == 0 {
+		return nil
+	}
+	subj := "Abot is encountering errors"
+	body := fmt.Sprintf("<html><body>"+
+		"<p>Abot encountered %d errors in the last %s.</p>"+
+		"<p>Most recent error: %s<br>Route: %s<br>Plugin: %s<br>"+
+		"Message ID: %d<br>Occurrences: %d</p>"+
+		"<p>See %s/api/admin/errors.json for all errors.</p>"+
+		"</body></html>",
+		count, errorAlertWindow, html.EscapeString(rec.Error),
+		html.EscapeString(rec.Route), html.EscapeString(rec.Plugin),
+		rec.MessageID, rec.Count,
+		html.EscapeString(os.Getenv("ABOT_URL")))
+	return emailConn.SendHTML(admins, os.Getenv("ABOT_EMAIL"), subj, body)
+}
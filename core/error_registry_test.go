@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestErrorRegistry(t *testing.T) {
+	old := os.Getenv("ABOT_ERROR_ALERT_THRESHOLD")
+	defer os.Setenv("ABOT_ERROR_ALERT_THRESHOLD", old)
+	os.Setenv("ABOT_ERROR_ALERT_THRESHOLD", "3")
+
+	er := errorRegistry{
+		records: map[string]*errorRecord{},
+		mutex:   &sync.Mutex{},
+	}
+	p := &dt.Plugin{Config: dt.PluginConfig{Name: "restaurant"}}
+	in := &dt.Msg{ID: 1, Route: "CO_find_restaurant", Plugin: p}
+	errTimeout := errors.New("timeout")
+	now := time.Now()
+	if _, alert := er.add(in, errTimeout, now); alert {
+		t.Fatal("expected no alert below threshold")
+	}
+	in.ID = 2
+	rec, alert := er.add(in, errTimeout, now.Add(time.Second))
+	if alert {
+		t.Fatal("expected no alert below threshold")
+	}
+	if rec.Count != 2 || rec.MessageID != 2 || rec.Plugin != "restaurant" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if _, alert = er.add(nil, errTimeout, now.Add(2*time.Second)); !alert {
+		t.Fatal("expected alert at threshold")
+	}
+	if _, alert = er.add(nil, errTimeout, now.Add(3*time.Second)); alert {
+		t.Fatal("expected one alert per window")
+	}
+
+	// Errors outside of the window no longer count toward the rate.
+	later := now.Add(2 * errorAlertWindow)
+	if _, alert = er.add(nil, errTimeout, later); alert {
+		t.Fatal("expected old errors to expire from the window")
+	}
+	recs := er.all()
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+	if recs[0].Count != 3 || !recs[0].LastSeen.Equal(later) {
+		t.Fatalf("expected most recent record first, got %+v", recs[0])
+	}
+}
+
+func TestErrorRegistryLimit(t *testing.T) {
+	er := errorRegistry{
+		records: map[string]*errorRecord{},
+		mutex:   &sync.Mutex{},
+	}
+	now := time.Now()
+	for i := 0; i <= maxErrorRecords; i++ {
+		err := fmt.Errorf("no user %d", i)
+		er.add(nil, err, now.Add(time.Duration(i)*time.Second))
+	}
+	recs := er.all()
+	if len(recs) != maxErrorRecords {
+		t.Fatalf("expected %d records, got %d", maxErrorRecords,
+			len(recs))
+	}
+	if recs[len(recs)-1].Error != "no user 1" {
+		t.Fatal("expected the least recently seen error to be dropped")
+	}
+}
@@ -0,0 +1,467 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// Types of labels scored by an evaluation.
+const (
+	evalCommands = "commands"
+	evalObjects  = "objects"
+	evalIntents  = "intents"
+	evalPeople   = "people"
+	evalTimes    = "times"
+	evalRoutes   = "routes"
+)
+
+// evalNone is the label in confusion tables for a word or message which was
+// expected but not found, or found but not expected.
+const evalNone = "(none)"
+
+// evalAmbiguous is the route found for a message when plugins score too
+// closely for one to be chosen (see GetPlugin).
+const evalAmbiguous = "(ambiguous)"
+
+// errInvalidEvalCase is returned when a labeled sentence has no sentence.
+var errInvalidEvalCase = errors.New("labeled sentences require a sentence")
+
+// EvalCase is a sentence labeled with what Abot is expected to find in it. It's
+// read from a JSONL file, where each line is a JSON object such as:
+//
+//	{"Sentence": "Order pizza for Jim tomorrow at 5pm",
+//	 "Commands": ["order"], "Objects": ["pizza"], "People": ["Jim"],
+//	 "Times": ["+1d 17:00"], "Plugin": "restaurant", "Route": "CO_order_pizza"}
+//
+// Labels which are omitted aren't scored. To expect that none are found, pass
+// an empty list, e.g. "People": []. An example is found in
+// data/nlp_eval.jsonl.
+type EvalCase struct {
+	Sentence string
+
+	// Language is the language of the sentence, e.g. "es". If it's empty,
+	// the language is detected.
+	Language string
+
+	Commands []string
+	Objects  []string
+	Intents  []string
+	People   []string
+
+	// Times are written relative to the day on which the evaluation is
+	// run, as the number of days from it and the time of day, e.g.
+	// "+1d 17:00" for tomorrow at 5pm.
+	Times []string
+
+	// Plugin is the name of the plugin which should respond to the
+	// sentence, or "" if none should. Route optionally gives the route by
+	// which it should be chosen, e.g. "I_order" or "CO_order_pizza".
+	Plugin *string
+	Route  string
+}
+
+// EvalScore counts the labels found correctly (TP), found but not expected
+// (FP), and expected but not found (FN).
+type EvalScore struct {
+	TP int
+	FP int
+	FN int
+}
+
+// Precision is the share of labels found which were expected.
+func (s *EvalScore) Precision() float64 {
+	if s.TP+s.FP == 0 {
+		return 1
+	}
+	return float64(s.TP) / float64(s.TP+s.FP)
+}
+
+// Recall is the share of labels expected which were found.
+func (s *EvalScore) Recall() float64 {
+	if s.TP+s.FN == 0 {
+		return 1
+	}
+	return float64(s.TP) / float64(s.TP+s.FN)
+}
+
+// F1 is the harmonic mean of the precision and recall.
+func (s *EvalScore) F1() float64 {
+	p, r := s.Precision(), s.Recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+// EvalReport holds the results of evaluating Abot against labeled sentences.
+// Reports are saved as JSON to serve as the baseline of later evaluations.
+type EvalReport struct {
+	Cases int
+
+	// Types holds the scores of each type of label, e.g. "commands".
+	Types map[string]*EvalScore
+
+	// Routes holds the scores of each plugin, or of each plugin route
+	// where the labeled sentences give one, e.g. "restaurant:I_order".
+	Routes map[string]*EvalScore
+
+	// Confusion holds tables counting how often each expected label
+	// (first key) was found as each label (second key). There's one table
+	// for words, comparing commands, objects and people, one for intents,
+	// and one for routes.
+	Confusion map[string]map[string]map[string]int
+}
+
+func newEvalReport() *EvalReport {
+	return &EvalReport{
+		Types:     map[string]*EvalScore{},
+		Routes:    map[string]*EvalScore{},
+		Confusion: map[string]map[string]map[string]int{},
+	}
+}
+
+// ReadEvalCases parses labeled sentences from JSONL, skipping blank lines.
+// Errors include the line on which they occurred.
+func ReadEvalCases(r io.Reader) ([]EvalCase, error) {
+	var cs []EvalCase
+	scn := bufio.NewScanner(r)
+	var line int
+	for scn.Scan() {
+		line++
+		b := scn.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var c EvalCase
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if len(strings.TrimSpace(c.Sentence)) == 0 {
+			return nil, fmt.Errorf("line %d: %s", line,
+				errInvalidEvalCase)
+		}
+		cs = append(cs, c)
+	}
+	if err := scn.Err(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// Evaluate analyzes each labeled sentence as it would a user's message and
+// scores what's found against the labels. Sentences are routed to the plugins
+// registered with Abot as if from a new user, so the server must be booted
+// first (see NewServer). Nothing is saved to the database.
+func Evaluate(cs []EvalCase) *EvalReport {
+	rep := newEvalReport()
+	now := time.Now()
+	for _, c := range cs {
+		rep.Cases++
+		m := &dt.Msg{
+			User:     &dt.User{},
+			Sentence: c.Sentence,
+			Response: &dt.Response{},
+		}
+		m.Language = c.Language
+		if m.Language == "" {
+			lang, ok := detectLanguage(splitTokens(c.Sentence))
+			if !ok {
+				lang = defaultLanguage()
+			}
+			m.Language = lang
+		}
+		analyzeMsg(m)
+		si := m.StructuredInput
+		var people []string
+		for _, p := range si.People {
+			people = append(people, p.Name)
+		}
+		var times []string
+		for _, t := range si.Times {
+			times = append(times, relativeTime(now, t))
+		}
+		if c.Commands != nil {
+			rep.score(evalCommands, c.Commands, si.Commands)
+		}
+		if c.Objects != nil {
+			rep.score(evalObjects, c.Objects, si.Objects)
+		}
+		if c.Intents != nil {
+			rep.score(evalIntents, c.Intents, si.Intents)
+			rep.confuse(evalIntents, lowerAll(c.Intents),
+				lowerAll(si.Intents))
+		}
+		if c.People != nil {
+			rep.score(evalPeople, c.People, people)
+		}
+		if c.Times != nil {
+			rep.score(evalTimes, c.Times, times)
+		}
+		rep.confuseWords(c, si.Commands, si.Objects, people)
+		if c.Plugin != nil {
+			rep.scoreRoute(c, evalRoute(c, m))
+		}
+	}
+	return rep
+}
+
+// evalRoute returns the label of the plugin, or plugin route, to which a
+// message is routed, in the form given by the labeled sentence.
+func evalRoute(c EvalCase, m *dt.Msg) string {
+	cands := scorePlugins(m, "")
+	if len(cands) == 0 {
+		return evalNone
+	}
+	if len(cands) > 1 && cands[0].Score-cands[1].Score < scoreMargin {
+		return evalAmbiguous
+	}
+	if c.Route == "" {
+		return cands[0].Plugin.Config.Name
+	}
+	return cands[0].Plugin.Config.Name + ":" + cands[0].Route
+}
+
+// score counts the labels of a type which were found as expected. Labels are
+// compared without regard to case.
+func (r *EvalReport) score(typ string, exp, got []string) {
+	s, ok := r.Types[typ]
+	if !ok {
+		s = &EvalScore{}
+		r.Types[typ] = s
+	}
+	expected := map[string]int{}
+	for _, l := range exp {
+		expected[strings.ToLower(l)]++
+	}
+	for _, l := range got {
+		l = strings.ToLower(l)
+		if expected[l] > 0 {
+			expected[l]--
+			s.TP++
+		} else {
+			s.FP++
+		}
+	}
+	for _, n := range expected {
+		s.FN += n
+	}
+}
+
+// scoreRoute scores the plugin route found for a labeled sentence.
+func (r *EvalReport) scoreRoute(c EvalCase, got string) {
+	exp := evalNone
+	if *c.Plugin != "" {
+		exp = *c.Plugin
+		if c.Route != "" {
+			exp += ":" + c.Route
+		}
+	}
+	r.score(evalRoutes, []string{exp}, []string{got})
+	for _, l := range []string{exp, got} {
+		if _, ok := r.Routes[l]; !ok {
+			r.Routes[l] = &EvalScore{}
+		}
+	}
+	if exp == got {
+		r.Routes[exp].TP++
+	} else {
+		r.Routes[exp].FN++
+		r.Routes[got].FP++
+	}
+	r.confuse(evalRoutes, []string{exp}, []string{got})
+}
+
+// confuseWords records in the words confusion table the type as which each
+// labeled word was found, e.g. a command found as an object.
+func (r *EvalReport) confuseWords(c EvalCase, commands, objects,
+	people []string) {
+
+	exp := map[string][]string{}
+	got := map[string][]string{}
+	add := func(m map[string][]string, typ string, words []string) {
+		for _, w := range words {
+			w = strings.ToLower(w)
+			m[w] = append(m[w], typ)
+		}
+	}
+	add(exp, evalCommands, c.Commands)
+	add(exp, evalObjects, c.Objects)
+	add(exp, evalPeople, c.People)
+	add(got, evalCommands, commands)
+	add(got, evalObjects, objects)
+	add(got, evalPeople, people)
+	for w, types := range exp {
+		r.confuse("words", types, got[w])
+	}
+	for w, types := range got {
+		if _, ok := exp[w]; !ok {
+			r.confuse("words", nil, types)
+		}
+	}
+}
+
+// confuse counts in a confusion table the labels found for the expected
+// labels. Labels found as expected count once, on the table's diagonal.
+// Otherwise each expected label is counted as each label found instead, or
+// as evalNone if none was.
+func (r *EvalReport) confuse(table string, exp, got []string) {
+	t, ok := r.Confusion[table]
+	if !ok {
+		t = map[string]map[string]int{}
+		r.Confusion[table] = t
+	}
+	count := func(e, g string) {
+		if _, ok := t[e]; !ok {
+			t[e] = map[string]int{}
+		}
+		t[e][g]++
+	}
+	found := wordSet(got...)
+	expected := wordSet(exp...)
+	var extra []string
+	for _, g := range got {
+		if _, ok := expected[g]; !ok {
+			extra = append(extra, g)
+		}
+	}
+	for _, e := range exp {
+		if _, ok := found[e]; ok {
+			count(e, e)
+			continue
+		}
+		if len(extra) == 0 {
+			count(e, evalNone)
+		}
+		for _, g := range extra {
+			count(e, g)
+		}
+	}
+	if len(exp) == 0 {
+		for _, g := range extra {
+			count(evalNone, g)
+		}
+	}
+}
+
+// Regressions compares the report to a baseline, returning a description of
+// each type of label and route whose F1 score fell by more than the
+// tolerance.
+func (r *EvalReport) Regressions(baseline *EvalReport,
+	tolerance float64) []string {
+
+	var regs []string
+	compare := func(kind string, cur, base map[string]*EvalScore) {
+		for _, l := range sortedScoreKeys(base) {
+			b := base[l].F1()
+			var c float64
+			if s, ok := cur[l]; ok {
+				c = s.F1()
+			}
+			if b-c > tolerance {
+				regs = append(regs, fmt.Sprintf(
+					"%s %s: F1 fell from %.3f to %.3f",
+					kind, l, b, c))
+			}
+		}
+	}
+	compare("type", r.Types, baseline.Types)
+	compare("route", r.Routes, baseline.Routes)
+	return regs
+}
+
+// Write prints the report as tables of the precision, recall and F1 of each
+// type of label and route, followed by the confusion tables.
+func (r *EvalReport) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%d sentences\n\n", r.Cases)
+	writeScores := func(title string, ss map[string]*EvalScore) {
+		fmt.Fprintf(tw, "%s\tPrecision\tRecall\tF1\tTP\tFP\tFN\n", title)
+		for _, l := range sortedScoreKeys(ss) {
+			s := ss[l]
+			fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%.3f\t%d\t%d\t%d\n", l,
+				s.Precision(), s.Recall(), s.F1(), s.TP, s.FP,
+				s.FN)
+		}
+		fmt.Fprintln(tw)
+	}
+	writeScores("Type", r.Types)
+	writeScores("Route", r.Routes)
+	var tables []string
+	for name := range r.Confusion {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		t := r.Confusion[name]
+		labels := map[string]struct{}{}
+		for e, row := range t {
+			labels[e] = struct{}{}
+			for g := range row {
+				labels[g] = struct{}{}
+			}
+		}
+		var cols []string
+		for l := range labels {
+			cols = append(cols, l)
+		}
+		sort.Strings(cols)
+		fmt.Fprintf(tw, "Confusion: %s (expected \\ found)", name)
+		for _, l := range cols {
+			fmt.Fprintf(tw, "\t%s", l)
+		}
+		fmt.Fprintln(tw)
+		for _, e := range cols {
+			if _, ok := t[e]; !ok {
+				continue
+			}
+			fmt.Fprint(tw, e)
+			for _, g := range cols {
+				fmt.Fprintf(tw, "\t%d", t[e][g])
+			}
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// relativeTime writes a time as the number of days from now and the time of
+// day, e.g. "+1d 17:00" for tomorrow at 5pm, as in EvalCase.Times.
+func relativeTime(now, t time.Time) string {
+	t = t.In(now.Location())
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	y, m, d = t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	// Days may be an hour short or long across a change to daylight
+	// saving time.
+	days := int(math.Floor(day.Sub(today).Hours()/24 + 0.5))
+	return fmt.Sprintf("%+dd %s", days, t.Format("15:04"))
+}
+
+func sortedScoreKeys(ss map[string]*EvalScore) []string {
+	var keys []string
+	for k := range ss {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func lowerAll(ss []string) []string {
+	var lower []string
+	for _, s := range ss {
+		lower = append(lower, strings.ToLower(s))
+	}
+	return lower
+}
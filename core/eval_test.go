@@ -0,0 +1,106 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestReadEvalCases(t *testing.T) {
+	r := strings.NewReader(`{"Sentence": "Order pizza", "Commands": ["order"], "Plugin": ""}
+
+{"Sentence": "Call Jim", "People": []}
+`)
+	cs, err := ReadEvalCases(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("expected 2 cases, got %d", len(cs))
+	}
+	if cs[0].Plugin == nil || *cs[0].Plugin != "" {
+		t.Fatal("expected no plugin to be expected")
+	}
+	if cs[1].People == nil || cs[1].Commands != nil {
+		t.Fatalf("expected only people to be labeled, got %+v", cs[1])
+	}
+
+	r = strings.NewReader(`{"Sentence": "Order pizza"}
+{"Commands": ["order"]}`)
+	_, err = ReadEvalCases(r)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	ner, err := buildClassifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := ners
+	ners = map[string]classifier{dt.LangEnglish: ner}
+	defer func() { ners = prev }()
+
+	none := ""
+	cs := []EvalCase{
+		{
+			Sentence: "Order pizza for Jim",
+			Language: dt.LangEnglish,
+			Commands: []string{"order"},
+			Objects:  []string{"pizza"},
+			People:   []string{"Jim", "Sarah"},
+			Plugin:   &none,
+		},
+	}
+	rep := Evaluate(cs)
+	if rep.Cases != 1 {
+		t.Fatalf("expected 1 case, got %d", rep.Cases)
+	}
+	s := rep.Types[evalPeople]
+	if s == nil || s.TP != 1 || s.FN != 1 {
+		t.Fatalf("expected 1 person found and 1 missed, got %+v", s)
+	}
+	if s.Recall() != 0.5 {
+		t.Fatalf("expected recall 0.5, got %f", s.Recall())
+	}
+	if rep.Confusion["words"][evalPeople][evalNone] != 1 {
+		t.Fatalf("expected Sarah to be confused with no type, got %v",
+			rep.Confusion["words"])
+	}
+	if rep.Routes[evalNone] == nil || rep.Routes[evalNone].TP != 1 {
+		t.Fatalf("expected no route to be found, got %v", rep.Routes)
+	}
+}
+
+func TestEvalRegressions(t *testing.T) {
+	base := newEvalReport()
+	base.Types[evalCommands] = &EvalScore{TP: 9, FN: 1}
+	base.Routes["weather"] = &EvalScore{TP: 4}
+	rep := newEvalReport()
+	rep.Types[evalCommands] = &EvalScore{TP: 9, FN: 1}
+	rep.Routes["weather"] = &EvalScore{TP: 3, FN: 1}
+	regs := rep.Regressions(base, 0)
+	if len(regs) != 1 || !strings.HasPrefix(regs[0], "route weather") {
+		t.Fatalf("expected a regression in the weather route, got %v",
+			regs)
+	}
+	if regs = rep.Regressions(base, 0.2); len(regs) > 0 {
+		t.Fatalf("expected no regressions within tolerance, got %v",
+			regs)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2016, 3, 12, 9, 0, 0, 0, time.UTC)
+	tm := time.Date(2016, 3, 13, 17, 0, 0, 0, time.UTC)
+	if got := relativeTime(now, tm); got != "+1d 17:00" {
+		t.Fatalf("expected +1d 17:00, got %s", got)
+	}
+	tm = time.Date(2016, 3, 12, 8, 30, 0, 0, time.UTC)
+	if got := relativeTime(now, tm); got != "+0d 08:30" {
+		t.Fatalf("expected +0d 08:30, got %s", got)
+	}
+}
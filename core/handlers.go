@@ -32,6 +32,10 @@ import (
 var tmplLayout *template.Template
 var ws = websocket.NewAtomicWebSocketSet()
 
+// sessions holds websockets for users chatting through a web session, through
+// which Abot pushes messages.
+var sessions = websocket.NewAtomicSessionSet()
+
 // ErrInvalidUserPass reports an invalid username/password combination during
 // login.
 var ErrInvalidUserPass = errors.New("Invalid username/password combination")
@@ -51,6 +55,7 @@ func newRouter() *httprouter.Router {
 	router.HandlerFunc("GET", "/", hIndex)
 	router.HandlerFunc("POST", "/", hMain)
 	router.HandlerFunc("OPTIONS", "/", hOptions)
+	router.Handler("GET", "/ws/session", w.Handler(hSessionSocket))
 
 	// Route any unknown request to our single page app front-end
 	router.NotFound = http.HandlerFunc(hIndex)
@@ -80,6 +85,11 @@ func newRouter() *httprouter.Router {
 	router.HandlerFunc("DELETE", "/api/admin/remote_tokens.json", hapiRemoteTokensDelete)
 	router.HandlerFunc("PUT", "/api/admin/settings.json", hapiSettingsUpdate)
 	router.HandlerFunc("GET", "/api/admin/dashboard.json", hapiDashboard)
+	router.HandlerFunc("GET", "/api/admin/errors.json", hapiErrors)
+	router.HandlerFunc("GET", "/api/admin/training_sentences.json", hapiTrainingSentences)
+	router.HandlerFunc("POST", "/api/admin/training_sentences.json", hapiTrainingSentencesSubmit)
+	router.HandlerFunc("PUT", "/api/admin/training_sentences.json", hapiTrainingSentencesUpdate)
+	router.HandlerFunc("DELETE", "/api/admin/training_sentences.json", hapiTrainingSentencesDelete)
 	return router
 }
 
@@ -112,26 +122,94 @@ func hIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// hMain is the endpoint to hit when you want a direct response via JSON.
-// The Abot console uses this endpoint.
+// hMain is the endpoint to hit when you want a direct response. The Abot
+// console uses this endpoint. Clients which accept application/json receive the
+// full dt.Response, including quick-reply options, cards and payloads. All
+// other clients receive the response degraded to plain text.
 func hMain(w http.ResponseWriter, r *http.Request) {
-	errMsg := "Something went wrong with my wiring... I'll get that fixed up soon."
 	ret, err := ProcessText(r)
 	if err != nil {
-		if len(ret) > 0 {
-			ret = errMsg
+		if ret == nil {
+			ret = &dt.Response{Sentence: fallbackReply}
 		}
 		log.Info("failed to process text.", err)
-		// TODO notify plugins listening for errors
 	}
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Access-Control-Allow-Origin")
-	_, err = fmt.Fprint(w, ret)
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(ret); err != nil {
+			writeErrorInternal(w, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = fmt.Fprint(w, ret.Text())
 	if err != nil {
 		writeErrorInternal(w, err)
 	}
 }
 
+// acceptsJSON determines whether the client prefers a JSON response, based on
+// the request's Accept header.
+func acceptsJSON(r *http.Request) bool {
+	for _, typ := range strings.Split(r.Header.Get("Accept"), ",") {
+		typ = strings.TrimSpace(strings.SplitN(typ, ";", 2)[0])
+		if typ == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// hSessionSocket opens a websocket for a web session, identified by the flexid
+// query parameter, through which Abot pushes messages to the user (see
+// dt.Plugin.Push). The token query parameter must be the session's token, as
+// returned in Abot's replies to the session (see dt.Response.SessionToken), so
+// only the client which owns the session receives its messages. The socket is
+// held open until the client disconnects.
+func hSessionSocket(wsc *w.Conn) {
+	q := wsc.Request().URL.Query()
+	flexID := q.Get("flexid")
+	if len(flexID) == 0 || !isValidSessionToken(flexID, q.Get("token")) {
+		log.Debug("rejected session socket", flexID)
+		_ = wsc.Close()
+		return
+	}
+	sessions.Set(flexID, wsc)
+	defer sessions.Delete(flexID, wsc)
+	for {
+		// Clients don't send messages over the socket, but reading
+		// detects when the client disconnects.
+		var msg string
+		if err := w.Message.Receive(wsc, &msg); err != nil {
+			return
+		}
+	}
+}
+
+// sessionToken returns the token which proves ownership of a web session,
+// signing its flexid with ABOT_SECRET.
+func sessionToken(flexID string) string {
+	return base64.URLEncoding.EncodeToString(sessionMAC(flexID))
+}
+
+// isValidSessionToken reports whether a token proves ownership of a web
+// session.
+func isValidSessionToken(flexID, token string) bool {
+	b, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sessionMAC(flexID), b)
+}
+
+func sessionMAC(flexID string) []byte {
+	mac := hmac.New(sha512.New, []byte(os.Getenv("ABOT_SECRET")))
+	_, _ = mac.Write([]byte("session:" + flexID))
+	return mac.Sum(nil)
+}
+
 // hOptions sets appropriate response headers in cases like browser-based
 // communication with Abot.
 func hOptions(w http.ResponseWriter, r *http.Request) {
@@ -643,9 +721,10 @@ func hapiConversation(w http.ResponseWriter, r *http.Request,
 		}
 	}
 	var msgs []struct {
-		Sentence  string
-		AbotSent  bool
-		CreatedAt time.Time
+		Sentence    string
+		AbotSent    bool
+		CreatedAt   time.Time
+		Corrections json.RawMessage
 	}
 	var name, location string
 	var signedUp time.Time
@@ -655,7 +734,8 @@ func hapiConversation(w http.ResponseWriter, r *http.Request,
 	offset := ps.ByName("off")
 	if uid != "0" {
 		q := `WITH t AS (
-			SELECT sentence, abotsent, createdat FROM messages
+			SELECT sentence, abotsent, createdat, corrections
+			FROM messages
 			WHERE userid=$1
 			ORDER BY createdat DESC LIMIT 30 OFFSET $2
 		      ) SELECT * FROM t ORDER BY createdat ASC`
@@ -686,7 +766,8 @@ func hapiConversation(w http.ResponseWriter, r *http.Request,
 		}
 	} else {
 		q := `WITH t AS (
-			SELECT sentence, abotsent, createdat FROM messages
+			SELECT sentence, abotsent, createdat, corrections
+			FROM messages
 		        WHERE flexid=$1 AND flexidtype=$2
 		        ORDER BY createdat DESC LIMIT 30 OFFSET $3
 		      ) SELECT * FROM t ORDER BY createdat ASC`
@@ -723,9 +804,10 @@ func hapiConversation(w http.ResponseWriter, r *http.Request,
 		CreatedAt time.Time
 		Location  string
 		Messages  []struct {
-			Sentence  string
-			AbotSent  bool
-			CreatedAt time.Time
+			Sentence    string
+			AbotSent    bool
+			CreatedAt   time.Time
+			Corrections json.RawMessage
 		}
 	}{
 		Name:      name,
@@ -808,36 +890,14 @@ func hapiSendMessage(w http.ResponseWriter, r *http.Request) {
 		AbotSent:   true,
 	}
 	switch req.FlexIDType {
-	case dt.FIDTPhone:
-		if smsConn == nil {
-			writeErrorInternal(w, errors.New("No SMS driver installed."))
-			return
-		}
-		if err := smsConn.Send(msg.FlexID, msg.Sentence); err != nil {
+	case dt.FIDTPhone, dt.FIDTEmail, dt.FIDTSession:
+		resp := &dt.Response{Sentence: msg.Sentence}
+		err := sendResponse(msg.FlexID, msg.FlexIDType, "New message",
+			resp)
+		if err != nil {
 			writeErrorInternal(w, err)
 			return
 		}
-	case dt.FIDTEmail:
-		/*
-			// TODO
-			if emailConn == nil {
-				writeErrorInternal(w, errors.New("No email driver installed."))
-				return
-			}
-			adminEmail := os.Getenv("ABOT_EMAIL")
-			email := template.GenericEmail(req.Name)
-			err := emailConn.SendHTML(msg.FlexID, adminEmail, "SUBJ", email)
-			if err != nil {
-				writeErrorInternal(w, err)
-				return
-			}
-		*/
-	case dt.FIDTSession:
-		/*
-			// TODO
-			if err := ws.NotifySocketSession(); err != nil {
-			}
-		*/
 	default:
 		writeErrorInternal(w, errors.New("invalid flexidtype"))
 		return
@@ -882,6 +942,196 @@ func hapiAdmins(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// hapiErrors returns the errors Abot has encountered while processing user
+// messages since it booted, most recent first, including the route, plugin and
+// message ID of each for debugging.
+func hapiErrors(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !isAdmin(w, r) {
+			return
+		}
+		if !isLoggedIn(w, r) {
+			return
+		}
+	}
+	b, err := json.Marshal(errReg.all())
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		log.Info("failed to write response.", err)
+	}
+}
+
+// hapiTrainingSentences returns the local training sentences of the plugin
+// given by the "plugin" query parameter, or of all plugins if it's omitted.
+func hapiTrainingSentences(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !isAdmin(w, r) {
+			return
+		}
+		if !isLoggedIn(w, r) {
+			return
+		}
+	}
+	ss, err := getTrainingSentences(db, r.URL.Query().Get("plugin"))
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+
+	// Include the entities found in each sentence, so they can be
+	// highlighted.
+	type sentence struct {
+		tSentence
+		Entities []dt.Entity
+	}
+	resp := []sentence{}
+	for _, s := range ss {
+		resp = append(resp, sentence{
+			tSentence: s,
+			Entities:  extractEntities(s.Sentence),
+		})
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		log.Info("failed to write response.", err)
+	}
+}
+
+// hapiTrainingSentencesSubmit adds a training sentence labeled with an intent
+// for a plugin and responds with the saved sentence. The plugin's classifier
+// learns the sentence immediately.
+func hapiTrainingSentencesSubmit(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !isAdmin(w, r) {
+			return
+		}
+		if !isLoggedIn(w, r) {
+			return
+		}
+		if !isValidCSRF(w, r) {
+			return
+		}
+	}
+	var s tSentence
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	s.Source = trainingSourceAdmin
+	added, err := addTrainingSentence(db, &s)
+	if err != nil {
+		if err == errInvalidTrainingSentence {
+			writeErrorBadRequest(w, err)
+			return
+		}
+		writeErrorInternal(w, err)
+		return
+	}
+
+	// A sentence which already existed is already in the intent model.
+	if added {
+		if err = learnTrainingSentence(db, &s); err != nil {
+			writeErrorInternal(w, err)
+			return
+		}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		log.Info("failed to write response.", err)
+	}
+}
+
+// hapiTrainingSentencesUpdate changes the plugin, intent or sentence of a
+// training sentence, retrains the classifiers of the plugins to which it
+// belonged before and after, and responds with 200 OK.
+func hapiTrainingSentencesUpdate(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !isAdmin(w, r) {
+			return
+		}
+		if !isLoggedIn(w, r) {
+			return
+		}
+		if !isValidCSRF(w, r) {
+			return
+		}
+	}
+	var s tSentence
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	oldPluginName, err := updateTrainingSentence(db, &s)
+	if err != nil {
+		if err == errInvalidTrainingSentence ||
+			err == errMissingTrainingSentence ||
+			err == errDuplicateTrainingSentence {
+			writeErrorBadRequest(w, err)
+			return
+		}
+		writeErrorInternal(w, err)
+		return
+	}
+	pluginNames := []string{s.PluginName}
+	if oldPluginName != s.PluginName {
+		pluginNames = append(pluginNames, oldPluginName)
+	}
+	if err = retrainPlugins(db, pluginNames...); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// hapiTrainingSentencesDelete removes a training sentence, retrains the
+// plugin's classifier and responds with 200 OK.
+func hapiTrainingSentencesDelete(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !isAdmin(w, r) {
+			return
+		}
+		if !isLoggedIn(w, r) {
+			return
+		}
+		if !isValidCSRF(w, r) {
+			return
+		}
+	}
+	var req struct{ ID uint64 }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	pluginName, err := deleteTrainingSentence(db, req.ID)
+	if err != nil {
+		if err == errMissingTrainingSentence {
+			writeErrorBadRequest(w, err)
+			return
+		}
+		writeErrorInternal(w, err)
+		return
+	}
+	if err = retrainPlugins(db, pluginName); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // hapiAdminsUpdate adds or removes admin permission from a given user.
 func hapiAdminsUpdate(w http.ResponseWriter, r *http.Request) {
 	if os.Getenv("ABOT_ENV") != "test" {
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
@@ -148,6 +149,413 @@ func TestHAPIProfile(t *testing.T) {
 	}
 }
 
+func TestSessionToken(t *testing.T) {
+	token := sessionToken("abc")
+	if !isValidSessionToken("abc", token) {
+		t.Fatal("expected the session's token to be valid")
+	}
+	if isValidSessionToken("abd", token) {
+		t.Fatal("expected another session's token to be invalid")
+	}
+	if isValidSessionToken("abc", "") {
+		t.Fatal("expected a missing token to be invalid")
+	}
+}
+
+func TestPluginConnAfterTurn(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	var kept *dt.Msg
+	p := &dt.Plugin{
+		Config: dt.PluginConfig{Name: "later"},
+		DB:     db,
+		Log:    log.New("later"),
+		Keywords: &dt.Keywords{Dict: map[string]dt.KeywordFn{
+			"I_later": func(in *dt.Msg) string {
+				kept = in
+				return "ok"
+			},
+		}},
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &dt.Msg{
+		User: user,
+		StructuredInput: &dt.StructuredInput{
+			Intents: []string{"later"},
+		},
+	}
+	in.SetTx(tx)
+	if _, _, err = callPlugin(p, in, true); err != nil {
+		t.Fatal(err)
+	}
+	in.EndTx()
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plugin writes after the turn ends, as from a goroutine.
+	q := `INSERT INTO states (key, value, pluginname, userid)
+	      VALUES ($1, $2, $3, $4)`
+	_, err = p.Conn(kept).Exec(q, "k", []byte(`"v"`), p.Config.Name,
+		user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasMemory(kept, "k") {
+		t.Fatal("expected the plugin's write to be saved")
+	}
+}
+
+func TestPluginPanicRollsBackTurn(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	stems := StemTokens([]string{"juggle", "pineapples"})
+	route := "CO_" + stems[0] + "_" + stems[1]
+	p := &dt.Plugin{
+		Config: dt.PluginConfig{Name: "panicky", Priority: 100},
+		DB:     db,
+		Log:    log.New("panicky"),
+	}
+	p.Keywords = &dt.Keywords{Dict: map[string]dt.KeywordFn{
+		route: func(in *dt.Msg) string {
+			p.SetMemory(in, "juggled", true)
+			panic("dropped a pineapple")
+		},
+	}}
+	RegPlugins.Set(route, p)
+	req := dt.Request{CMD: "Juggle pineapples", UserID: user.ID}
+	byt, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, b := request("POST", "/", byt)
+	if c != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", c)
+	}
+	if b != fallbackReply {
+		t.Fatalf("expected %q, got %q", fallbackReply, b)
+	}
+
+	// The plugin's memory and the saved context are rolled back.
+	var n int
+	q := `SELECT COUNT(*) FROM states WHERE userid=$1`
+	if err = db.Get(&n, q, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("expected no states, got", n)
+	}
+
+	// Only the message and reply saved for the failed turn remain.
+	var sentences []string
+	q = `SELECT sentence FROM messages WHERE userid=$1 ORDER BY id`
+	if err = db.Select(&sentences, q, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(sentences) != 2 || sentences[1] != fallbackReply {
+		t.Fatal("expected the failed turn's message and reply, got",
+			sentences)
+	}
+}
+
+func TestResponderRunsBeforeSave(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	scrub := func(in *dt.Msg) string {
+		in.Sentence = strings.Replace(in.Sentence, "555-1234", "[phone]", -1)
+		in.Sentence = strings.Replace(in.Sentence, "tomorrow", "[time]", -1)
+		return ""
+	}
+	if err := Responders.AddBefore(ResponderOffense, "scrub", scrub); err != nil {
+		t.Fatal(err)
+	}
+	defer Responders.Remove("scrub")
+	req := dt.Request{CMD: "Hi, call me tomorrow at 555-1234",
+		UserID: user.ID}
+	byt, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := request("POST", "/", byt); c != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", c)
+	}
+	var sentence string
+	q := `SELECT sentence FROM messages
+	      WHERE userid=$1 AND abotsent IS FALSE`
+	if err = db.Get(&sentence, q, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sentence, "555-1234") {
+		t.Fatal("expected the scrubbed sentence to be saved, got",
+			sentence)
+	}
+	// The context is recorded from the scrubbed sentence, so the time
+	// which was scrubbed isn't kept.
+	var n int
+	q = `SELECT COUNT(*) FROM states WHERE userid=$1 AND key=$2`
+	if err = db.Get(&n, q, user.ID, keyContextTime); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("expected no time context from the scrubbed sentence")
+	}
+}
+
+func TestUserLanguage(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	in := &dt.Msg{User: user}
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	tests := []struct {
+		sent string
+		lang string
+	}{
+		{"ok", dt.LangEnglish},
+		{"quiero una pizza", dt.LangSpanish},
+		{"ok", dt.LangSpanish},
+	}
+	for _, test := range tests {
+		lang, err := userLanguage(tx, in, splitTokens(test.sent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lang != test.lang {
+			t.Fatalf("%q: expected %q, got %q", test.sent, test.lang,
+				lang)
+		}
+	}
+
+	// A locale detected in a turn that's rolled back isn't remembered.
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	lang, err := userLanguage(db, in, splitTokens("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang != dt.LangEnglish {
+		t.Fatal("expected default language, got", lang)
+	}
+}
+
+func TestTrainingSentences(t *testing.T) {
+	if _, err := db.Exec(`DELETE FROM trainingsentences`); err != nil {
+		t.Fatal(err)
+	}
+	s := &tSentence{
+		PluginName: "weather",
+		Intent:     "get_weather",
+		Sentence:   "Will it rain?",
+	}
+	added, err := addTrainingSentence(db, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !added {
+		t.Fatal("expected a new sentence to be added")
+	}
+	id := s.ID
+	if added, err = addTrainingSentence(db, s); err != nil {
+		t.Fatal(err)
+	}
+	if added || s.ID != id {
+		t.Fatal("expected an existing sentence not to be added again")
+	}
+
+	// Move the sentence to another plugin.
+	s.PluginName = "forecast"
+	oldPluginName, err := updateTrainingSentence(db, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldPluginName != "weather" {
+		t.Fatal("expected old plugin weather, got", oldPluginName)
+	}
+	ss, err := getTrainingSentences(db, "forecast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ss) != 1 || ss[0].ID != id {
+		t.Fatal("expected the sentence to be moved, got", ss)
+	}
+
+	// Editing a sentence to match another is rejected.
+	other := &tSentence{
+		PluginName: "forecast",
+		Intent:     "get_weather",
+		Sentence:   "Will it snow?",
+	}
+	if _, err = addTrainingSentence(db, other); err != nil {
+		t.Fatal(err)
+	}
+	other.Sentence = s.Sentence
+	byt, err := json.Marshal(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := "/api/admin/training_sentences.json"
+	if c, _ := request("PUT", u, byt); c != http.StatusBadRequest {
+		t.Fatal("expected", http.StatusBadRequest, "got", c)
+	}
+}
+
+func TestPluginConnReleasesRow(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	p := &dt.Plugin{
+		Config: dt.PluginConfig{Name: "rows"},
+		DB:     db,
+		Log:    log.New("rows"),
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	in := &dt.Msg{User: user}
+	in.SetTx(tx)
+	conn := p.Conn(in)
+	var n int
+	if err = conn.QueryRowx(`SELECT 1`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Exec(`SELECT 1`); err != nil {
+		t.Fatal(err)
+	}
+	in.EndTx()
+
+	// Every savepoint of the plugin's queries has been released.
+	if _, err = tx.Exec("RELEASE SAVEPOINT abot_plugin_query"); err == nil {
+		t.Fatal("expected no savepoint to remain")
+	}
+}
+
+func TestSavedAddress(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	in := &dt.Msg{User: user}
+	q := `INSERT INTO states (key, value, pluginname, userid)
+	      VALUES ($1, $2, $3, $4)`
+	_, err := db.Exec(q, "home_address", []byte(`{"Line1": "1 Main St"}`),
+		"maps", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(q, "home_address", []byte(`"Springfield"`), "notes",
+		user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := savedAddress(db, in, "home_address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr == nil || addr.Line1 != "1 Main St" {
+		t.Fatal("expected the saved address, got", addr)
+	}
+	if addr, err = savedAddress(db, in, "work_address"); err != nil {
+		t.Fatal(err)
+	}
+	if addr != nil {
+		t.Fatal("expected no saved address, got", addr)
+	}
+}
+
+func TestTurnPanicRollsBack(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	stems := StemTokens([]string{"juggle", "pineapples"})
+	route := "CO_" + stems[0] + "_" + stems[1]
+	var panics bool
+	RegPlugins.Set(route, &dt.Plugin{
+		Config: dt.PluginConfig{Name: "declining", Priority: 200},
+		DB:     db,
+		Log:    log.New("declining"),
+		Decline: func(*dt.Msg) bool {
+			if panics {
+				panic("declined a pineapple")
+			}
+			return true
+		},
+	})
+	responder := func(in *dt.Msg) string {
+		if in.Sentence == "Panic" {
+			panic("no pineapples")
+		}
+		return ""
+	}
+	if err := Responders.AddBefore(ResponderOffense, "panicky",
+		responder); err != nil {
+		t.Fatal(err)
+	}
+	defer Responders.Remove("panicky")
+	send := func(cmd string) string {
+		byt, err := json.Marshal(dt.Request{CMD: cmd, UserID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, b := request("POST", "/", byt)
+		if c != http.StatusOK {
+			t.Fatal("expected", http.StatusOK, "got", c)
+		}
+		return b
+	}
+	if b := send("Panic"); b != fallbackReply {
+		t.Fatalf("expected %q, got %q", fallbackReply, b)
+	}
+	panics = true
+	if b := send("Juggle pineapples"); b != fallbackReply {
+		t.Fatalf("expected %q, got %q", fallbackReply, b)
+	}
+	panics = false
+
+	// The turns were rolled back, releasing the user's lock, so the
+	// user's next message is processed.
+	var n int
+	q := `SELECT COUNT(*) FROM states WHERE userid=$1`
+	if err := db.Get(&n, q, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("expected no states, got", n)
+	}
+	if b := send("Hi"); b == fallbackReply {
+		t.Fatal("expected the next message to be processed")
+	}
+}
+
+func TestLockUserTimeout(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	defer func(d time.Duration) { userLockTimeout = d }(userLockTimeout)
+	userLockTimeout = 100 * time.Millisecond
+	tx1, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx1.Rollback()
+	if err = lockUser(tx1, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stuck turn holds the lock, so the user's next turn gives up.
+	tx2, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx2.Rollback()
+	if err = lockUser(tx2, user); err == nil {
+		t.Fatal("expected waiting for the lock to time out")
+	}
+}
+
 func request(method, path string, data []byte) (int, string) {
 	router := newRouter()
 	u := "http://localhost:" + os.Getenv("PORT")
@@ -195,6 +603,14 @@ func reset(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(`DELETE FROM states`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`DELETE FROM usersessions`)
+	if err != nil {
+		t.Fatal(err)
+	}
 }
 
 func seedDBUser(t *testing.T) (u *dt.User, fid string, fidT dt.FlexIDType) {
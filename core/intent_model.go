@@ -0,0 +1,440 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/jbrukh/bayesian"
+)
+
+// defaultIntentThreshold is the minimum probability with which a plugin's
+// classifier must recognize an intent, unless the plugin sets its
+// IntentThreshold.
+const defaultIntentThreshold = 0.7
+
+// intentModelsKept is the number of most recent intent models kept in the
+// database. Older models are deleted as new ones are saved.
+const intentModelsKept = 10
+
+// noIntent is the class added to the classifiers of plugins trained on a
+// single intent, since a classifier requires at least two classes. It's never
+// recognized as an intent.
+const noIntent = "__no_intent"
+
+// intentModel is a set of trained intent classifiers, one for each plugin. A
+// model is never modified once in use. Changes are made to a copy, which then
+// replaces the model in use (see intentModels).
+type intentModel struct {
+	// Version identifies the model in the database. It's recorded on each
+	// message classified by the model.
+	Version uint64
+
+	// classifiers holds the trained bayesian classifiers for our plugins.
+	// The key is the name of the plugin to which the classifier belongs.
+	classifiers map[string]*bayesian.Classifier
+
+	// intents holds the intents for which each plugin has been trained,
+	// in the order of the classes of the plugin's classifier.
+	intents map[string][]bayesian.Class
+}
+
+// intentModelStore holds the intent model in use, which is swapped atomically
+// as the model is retrained.
+type intentModelStore struct {
+	model *intentModel
+	mutex *sync.RWMutex
+
+	// training serializes changes to the model, so concurrent changes
+	// aren't lost.
+	training *sync.Mutex
+}
+
+// intentModels holds the intent model used to classify messages.
+var intentModels = intentModelStore{
+	model:    newIntentModel(),
+	mutex:    &sync.RWMutex{},
+	training: &sync.Mutex{},
+}
+
+// get returns the intent model in use. The model must not be modified.
+func (s *intentModelStore) get() *intentModel {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.model
+}
+
+// set replaces the intent model in use.
+func (s *intentModelStore) set(m *intentModel) {
+	s.mutex.Lock()
+	s.model = m
+	s.mutex.Unlock()
+}
+
+func newIntentModel() *intentModel {
+	return &intentModel{
+		classifiers: map[string]*bayesian.Classifier{},
+		intents:     map[string][]bayesian.Class{},
+	}
+}
+
+// copy returns a shallow copy of the model, which may be changed by replacing
+// its classifiers. The classifiers themselves are shared and must not be
+// modified.
+func (m *intentModel) copy() *intentModel {
+	c := newIntentModel()
+	for name, cl := range m.classifiers {
+		c.classifiers[name] = cl
+	}
+	for name, intents := range m.intents {
+		c.intents[name] = intents
+	}
+	return c
+}
+
+// hasIntent reports whether a plugin's classifier has been trained on the
+// intent.
+func (m *intentModel) hasIntent(pluginName, intent string) bool {
+	for _, i := range m.intents[pluginName] {
+		if string(i) == intent {
+			return true
+		}
+	}
+	return false
+}
+
+// classify records every intent considered by each plugin's classifier for the
+// stems of a message in si.IntentCandidates. Intents whose probability exceeds
+// the plugin's threshold are added to si.Intents and si.IntentScores.
+func (m *intentModel) classify(stems []string, si *dt.StructuredInput) {
+	si.IntentScores = map[string]float64{}
+	si.IntentCandidates = []dt.IntentCandidate{}
+	names := make([]string, 0, len(m.classifiers))
+	for name := range m.classifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		scores, _, _ := m.classifiers[name].ProbScores(stems)
+		for i, prob := range scores {
+			intent := string(m.intents[name][i])
+			if intent == noIntent {
+				continue
+			}
+			si.IntentCandidates = append(si.IntentCandidates,
+				dt.IntentCandidate{
+					Intent:      intent,
+					Plugin:      name,
+					Probability: prob,
+				})
+		}
+	}
+	sort.Stable(byProbability(si.IntentCandidates))
+	for _, c := range si.IntentCandidates {
+		log.Debug("intent score", c.Plugin, c.Intent, c.Probability)
+		if c.Probability <= intentThreshold(c.Plugin) {
+			continue
+		}
+		if _, exists := si.IntentScores[c.Intent]; !exists {
+			si.Intents = append(si.Intents, c.Intent)
+			// Candidates are sorted, so the first score is the
+			// highest.
+			si.IntentScores[c.Intent] = c.Probability
+		}
+	}
+}
+
+// intentThreshold returns the minimum probability with which a plugin's
+// classifier must recognize an intent, as defined in its plugin.json.
+func intentThreshold(pluginName string) float64 {
+	for _, pconf := range PluginsGo {
+		if pconf.Name == pluginName && pconf.IntentThreshold > 0 {
+			return pconf.IntentThreshold
+		}
+	}
+	return defaultIntentThreshold
+}
+
+// byProbability implements sort.Interface to order intent candidates from most
+// to least probable.
+type byProbability []dt.IntentCandidate
+
+func (s byProbability) Len() int           { return len(s) }
+func (s byProbability) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byProbability) Less(i, j int) bool { return s[i].Probability > s[j].Probability }
+
+// storedIntentModel is the form in which an intent model is saved to the
+// database.
+type storedIntentModel struct {
+	Intents     map[string][]bayesian.Class
+	Classifiers map[string][]byte
+}
+
+// encode serializes the model's classifiers.
+func (m *intentModel) encode() ([]byte, error) {
+	sm := storedIntentModel{
+		Intents:     m.intents,
+		Classifiers: map[string][]byte{},
+	}
+	for name, c := range m.classifiers {
+		var buf bytes.Buffer
+		if err := c.WriteTo(&buf); err != nil {
+			return nil, err
+		}
+		sm.Classifiers[name] = buf.Bytes()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(sm); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeIntentModel deserializes a model's classifiers.
+func decodeIntentModel(b []byte) (*intentModel, error) {
+	var sm storedIntentModel
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&sm); err != nil {
+		return nil, err
+	}
+	m := newIntentModel()
+	for name, cb := range sm.Classifiers {
+		c, err := bayesian.NewClassifierFromReader(bytes.NewReader(cb))
+		if err != nil {
+			return nil, err
+		}
+		m.classifiers[name] = c
+		m.intents[name] = sm.Intents[name]
+	}
+	return m, nil
+}
+
+// cloneClassifier returns a deep copy of a classifier, which may be trained
+// further without affecting the original.
+func cloneClassifier(c *bayesian.Classifier) (*bayesian.Classifier, error) {
+	var buf bytes.Buffer
+	if err := c.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return bayesian.NewClassifierFromReader(&buf)
+}
+
+// trainIntentClassifier trains a classifier on a plugin's training sentences,
+// returning the classifier and its intents. The classifier is nil if there are
+// no sentences.
+func trainIntentClassifier(ss []tSentence) (*bayesian.Classifier,
+	[]bayesian.Class) {
+
+	// Assemble list of Bayesian classes from all trained intents. m is
+	// used to keep track of the classes already added.
+	var intents []bayesian.Class
+	m := map[string]struct{}{}
+	for _, s := range ss {
+		if _, ok := m[s.Intent]; ok {
+			continue
+		}
+		log.Debug("learning intent", s.Intent)
+		m[s.Intent] = struct{}{}
+		intents = append(intents, bayesian.Class(s.Intent))
+	}
+	// Calling bayesian.NewClassifier() with 0 or 1 classes causes a
+	// panic.
+	if len(intents) == 0 {
+		return nil, nil
+	}
+	if len(intents) == 1 {
+		intents = append(intents, bayesian.Class(noIntent))
+	}
+	c := bayesian.NewClassifier(intents...)
+
+	// With the classifier initialized, train it on each sentence's stems.
+	for _, s := range ss {
+		stems := sentenceStems(s.Sentence)
+		c.Learn(stems, bayesian.Class(s.Intent))
+	}
+	return c, intents
+}
+
+// buildIntentModel trains a classifier for each plugin in the training
+// sentences.
+func buildIntentModel(ss []tSentence) *intentModel {
+	byPlugin := map[string][]tSentence{}
+	for _, s := range ss {
+		byPlugin[s.PluginName] = append(byPlugin[s.PluginName], s)
+	}
+	m := newIntentModel()
+	for name, pss := range byPlugin {
+		c, intents := trainIntentClassifier(pss)
+		if c == nil {
+			continue
+		}
+		m.classifiers[name] = c
+		m.intents[name] = intents
+	}
+	return m
+}
+
+// saveIntentModel saves the model to the database, setting its version.
+func saveIntentModel(db dt.DBConn, m *intentModel) error {
+	b, err := m.encode()
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO intentmodels (data) VALUES ($1) RETURNING id`
+	if err = db.QueryRowx(q, b).Scan(&m.Version); err != nil {
+		return err
+	}
+	q = `DELETE FROM intentmodels WHERE id<=$1`
+	_, err = db.Exec(q, int64(m.Version)-intentModelsKept)
+	return err
+}
+
+// loadIntentModel returns the most recently saved intent model and when it was
+// saved. The model is nil if none has been saved.
+func loadIntentModel(db dt.DBConn) (*intentModel, time.Time, error) {
+	var row struct {
+		ID        uint64
+		Data      []byte
+		CreatedAt time.Time
+	}
+	q := `SELECT id, data, createdat FROM intentmodels
+	      ORDER BY id DESC LIMIT 1`
+	err := db.Get(&row, q)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	m, err := decodeIntentModel(row.Data)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	m.Version = row.ID
+	return m, row.CreatedAt, nil
+}
+
+// intentModelStale reports whether training sentences were added, changed or
+// deleted since an intent model was saved at t.
+func intentModelStale(db dt.DBConn, t time.Time) (bool, error) {
+	var stale bool
+	q := `SELECT EXISTS (SELECT 1 FROM trainingsentences
+	      WHERE updatedat>=$1 OR deletedat>=$1)`
+	err := db.Get(&stale, q, t)
+	return stale, err
+}
+
+// TrainIntentModel trains a new intent model from Abot's local training data,
+// saves it and puts it in use. Any other Abot servers sharing the database
+// switch to the new model within a minute (see reloadIntentModels).
+func TrainIntentModel(db dt.DBConn) error {
+	intentModels.training.Lock()
+	defer intentModels.training.Unlock()
+	ss, err := getTrainingSentences(db, "")
+	if err != nil {
+		return err
+	}
+	m := buildIntentModel(ss)
+	if err = saveIntentModel(db, m); err != nil {
+		return err
+	}
+	intentModels.set(m)
+	log.Debug("trained intent model", m.Version)
+	return nil
+}
+
+// learnTrainingSentence updates the intent model in use with a newly added
+// training sentence. Sentences of an intent on which the plugin's classifier
+// was already trained are learned incrementally. Otherwise the plugin's
+// classifier is retrained.
+func learnTrainingSentence(db dt.DBConn, s *tSentence) error {
+	intentModels.training.Lock()
+	defer intentModels.training.Unlock()
+	cur := intentModels.get()
+	if !cur.hasIntent(s.PluginName, s.Intent) {
+		return retrainPluginsLocked(db, s.PluginName)
+	}
+	c, err := cloneClassifier(cur.classifiers[s.PluginName])
+	if err != nil {
+		return err
+	}
+	stems := sentenceStems(s.Sentence)
+	c.Learn(stems, bayesian.Class(s.Intent))
+	m := cur.copy()
+	m.classifiers[s.PluginName] = c
+	if err = saveIntentModel(db, m); err != nil {
+		return err
+	}
+	intentModels.set(m)
+	log.Debug("learned training sentence, intent model", m.Version)
+	return nil
+}
+
+// retrainPlugins retrains the classifiers of plugins whose training sentences
+// changed, putting the updated intent model in use.
+func retrainPlugins(db dt.DBConn, pluginNames ...string) error {
+	intentModels.training.Lock()
+	defer intentModels.training.Unlock()
+	return retrainPluginsLocked(db, pluginNames...)
+}
+
+// retrainPluginsLocked implements retrainPlugins. The caller must hold
+// intentModels.training.
+func retrainPluginsLocked(db dt.DBConn, pluginNames ...string) error {
+	m := intentModels.get().copy()
+	for _, name := range pluginNames {
+		ss, err := getTrainingSentences(db, name)
+		if err != nil {
+			return err
+		}
+		c, intents := trainIntentClassifier(ss)
+		if c == nil {
+			delete(m.classifiers, name)
+			delete(m.intents, name)
+			continue
+		}
+		m.classifiers[name] = c
+		m.intents[name] = intents
+	}
+	if err := saveIntentModel(db, m); err != nil {
+		return err
+	}
+	intentModels.set(m)
+	log.Debug("retrained", pluginNames, "intent model", m.Version)
+	return nil
+}
+
+// reloadIntentModels checks for intent models saved by other Abot servers, or
+// by "abot train", every interval, putting any newer model in use.
+func reloadIntentModels(interval time.Duration) {
+	t := time.NewTicker(interval)
+	for range t.C {
+		if err := reloadIntentModel(); err != nil {
+			log.Info("failed to reload intent model", err)
+		}
+	}
+}
+
+func reloadIntentModel() error {
+	intentModels.training.Lock()
+	defer intentModels.training.Unlock()
+	var latest uint64
+	q := `SELECT COALESCE(MAX(id), 0) FROM intentmodels`
+	if err := db.Get(&latest, q); err != nil {
+		return err
+	}
+	if latest <= intentModels.get().Version {
+		return nil
+	}
+	m, _, err := loadIntentModel(db)
+	if err != nil || m == nil {
+		return err
+	}
+	intentModels.set(m)
+	log.Debug("reloaded intent model", m.Version)
+	return nil
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/jbrukh/bayesian"
+)
+
+func TestIntentModelEncode(t *testing.T) {
+	ss := []tSentence{
+		{PluginName: "weather", Intent: "get_weather", Sentence: "Will it rain today?"},
+		{PluginName: "weather", Intent: "get_forecast", Sentence: "What's the forecast this week?"},
+		{PluginName: "restaurant", Intent: "find_restaurant", Sentence: "Find me a place to eat"},
+	}
+	m := buildIntentModel(ss)
+	if len(m.classifiers) != 2 {
+		t.Fatalf("expected 2 classifiers, got %d", len(m.classifiers))
+	}
+	// Classifiers require two classes, so plugins with a single intent
+	// are given a placeholder.
+	if !m.hasIntent("restaurant", noIntent) {
+		t.Fatalf("expected %q for a single intent, got %v", noIntent,
+			m.intents["restaurant"])
+	}
+	b, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := decodeIntentModel(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, intents := range m.intents {
+		intents2 := m2.intents[name]
+		if len(intents2) != len(intents) {
+			t.Fatalf("expected intents %v, got %v", intents, intents2)
+		}
+		for i := range intents {
+			if intents[i] != intents2[i] {
+				t.Fatalf("expected intents %v, got %v", intents,
+					intents2)
+			}
+		}
+		if m2.classifiers[name] == nil {
+			t.Fatalf("expected classifier for %s", name)
+		}
+	}
+}
+
+func TestIntentModelCopy(t *testing.T) {
+	m := buildIntentModel([]tSentence{
+		{PluginName: "weather", Intent: "get_weather", Sentence: "Will it rain today?"},
+	})
+	c := m.copy()
+	c.classifiers["weather"] = bayesian.NewClassifier("a", "b")
+	c.intents["weather"] = []bayesian.Class{"a", "b"}
+	if m.hasIntent("weather", "a") || !m.hasIntent("weather", "get_weather") {
+		t.Fatal("expected changes to a copy to leave the model unchanged")
+	}
+}
+
+func TestIntentModelClassify(t *testing.T) {
+	m := buildIntentModel([]tSentence{
+		{PluginName: "weather", Intent: "get_weather", Sentence: "Will it rain today?"},
+		{PluginName: "weather", Intent: "get_weather", Sentence: "Is it raining?"},
+		{PluginName: "weather", Intent: "get_forecast", Sentence: "What's the forecast this week?"},
+		{PluginName: "restaurant", Intent: "find_restaurant", Sentence: "Find me a place to eat"},
+	})
+	stems := StemTokens(TokenizeSentence("will it rain today"))
+	si := &dt.StructuredInput{}
+	m.classify(stems, si)
+	if len(si.IntentCandidates) != 3 {
+		t.Fatalf("expected 3 candidates, got %v", si.IntentCandidates)
+	}
+	top := si.IntentCandidates[0]
+	if top.Intent != "get_weather" || top.Plugin != "weather" {
+		t.Fatalf("expected get_weather from weather first, got %+v", top)
+	}
+	for i := 1; i < len(si.IntentCandidates); i++ {
+		if si.IntentCandidates[i].Probability > top.Probability {
+			t.Fatalf("expected candidates sorted by probability, got %v",
+				si.IntentCandidates)
+		}
+	}
+	if len(si.Intents) != 1 || si.Intents[0] != "get_weather" {
+		t.Fatalf("expected intent get_weather, got %v", si.Intents)
+	}
+
+	// Raising the plugin's threshold above the probability keeps the
+	// intent only as a candidate.
+	prev := PluginsGo
+	defer func() { PluginsGo = prev }()
+	PluginsGo = []dt.PluginConfig{{Name: "weather", IntentThreshold: 0.9999}}
+	si = &dt.StructuredInput{}
+	m.classify(stems, si)
+	if len(si.Intents) != 0 {
+		t.Fatalf("expected no intents, got %v", si.Intents)
+	}
+	if si.Confidence("get_weather") != top.Probability {
+		t.Fatalf("expected confidence %f, got %f", top.Probability,
+			si.Confidence("get_weather"))
+	}
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// keyLanguage is the key under which a user's locale is remembered.
+const keyLanguage = "__language"
+
+// languages are the languages Abot understands. Each has a stemmer (see
+// package snowball) and a set of NER dictionaries in data/ner.
+var languages = []string{dt.LangEnglish, dt.LangSpanish}
+
+// stopWords are common words used to detect the language of a message. Words
+// shared between languages, such as "a", "no" and "me", are left out, since
+// they're no evidence of either.
+var stopWords = map[string]map[string]struct{}{
+	dt.LangEnglish: wordSet("the", "and", "is", "are", "you", "what",
+		"when", "where", "how", "my", "it", "to", "for", "of", "with",
+		"please", "can", "will", "would", "i", "at", "this", "that",
+		"tomorrow", "today", "want", "need", "not"),
+	dt.LangSpanish: wordSet("el", "la", "los", "las", "es", "son", "que",
+		"qué", "cuándo", "dónde", "cómo", "mi", "mis", "para", "por",
+		"con", "quiero", "necesito", "puedes", "favor", "mañana", "hoy",
+		"una", "un", "del", "al", "y", "está", "estoy", "hola",
+		"gracias", "de", "en", "lo", "tu", "yo"),
+}
+
+func wordSet(words ...string) map[string]struct{} {
+	m := map[string]struct{}{}
+	for _, w := range words {
+		m[w] = struct{}{}
+	}
+	return m
+}
+
+// defaultLanguage is the language assumed for users whose locale isn't yet
+// known. It may be set with ABOT_LANGUAGE and defaults to English.
+func defaultLanguage() string {
+	lang := os.Getenv("ABOT_LANGUAGE")
+	for _, l := range languages {
+		if lang == l {
+			return lang
+		}
+	}
+	return dt.LangEnglish
+}
+
+// detectLanguage guesses the language of a tokenized sentence by counting its
+// stop words. Characters unique to Spanish, such as "ñ" and "¿", count as
+// further evidence. It reports false when there's too little evidence to
+// tell, as with short messages like "ok".
+func detectLanguage(tokens []string) (string, bool) {
+	hits := map[string]int{}
+	for _, t := range tokens {
+		t = strings.ToLower(t)
+		for lang, words := range stopWords {
+			if _, ok := words[t]; ok {
+				hits[lang]++
+			}
+		}
+		if strings.ContainsAny(t, "ñ¿¡áéíóú") {
+			hits[dt.LangSpanish]++
+		}
+	}
+	var best string
+	var max, next int
+	for _, lang := range languages {
+		switch n := hits[lang]; {
+		case n > max:
+			best, max, next = lang, n, max
+		case n > next:
+			next = n
+		}
+	}
+	if max == 0 || max == next {
+		return "", false
+	}
+	return best, true
+}
+
+// userLanguage returns the language of a message. When the language can be
+// detected from the message itself, it's remembered as the user's locale;
+// otherwise the remembered locale is used, falling back to the default
+// language. The locale is read and written through db, usually the
+// transaction of the user's turn, so a turn that's rolled back doesn't change
+// it, and every instance of Abot sees the same locale.
+func userLanguage(db dt.DBConn, in *dt.Msg, tokens []string) (string,
+	error) {
+
+	var lang string
+	err := getState(db, in, keyLanguage, &lang)
+	if err == sql.ErrNoRows {
+		lang = defaultLanguage()
+	} else if err != nil {
+		return "", err
+	}
+	detected, ok := detectLanguage(tokens)
+	if !ok || detected == lang {
+		return lang, nil
+	}
+	if err = saveState(db, in, keyLanguage, detected); err != nil {
+		return "", err
+	}
+	return detected, nil
+}
+
+// sentenceStems returns the stems of a sentence in the language detected from
+// the sentence itself, or English if it can't be detected. It's used for
+// training sentences, which belong to no user.
+func sentenceStems(sent string) []string {
+	lang, ok := detectLanguage(splitTokens(sent))
+	if !ok {
+		lang = dt.LangEnglish
+	}
+	return stemTokens(tokenize(sent, lang), lang)
+}
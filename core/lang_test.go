@@ -0,0 +1,63 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		sent string
+		lang string
+		ok   bool
+	}{
+		{"What's the weather like today?", dt.LangEnglish, true},
+		{"Order me a pizza for tomorrow", dt.LangEnglish, true},
+		{"Quiero pedir una pizza para mañana", dt.LangSpanish, true},
+		{"¿Cuándo sale el vuelo?", dt.LangSpanish, true},
+		{"hola", dt.LangSpanish, true},
+		{"ok", "", false},
+		{"no", "", false},
+	}
+	for _, test := range tests {
+		lang, ok := detectLanguage(splitTokens(test.sent))
+		if lang != test.lang || ok != test.ok {
+			t.Errorf("%q: expected %q (%t), got %q (%t)", test.sent,
+				test.lang, test.ok, lang, ok)
+		}
+	}
+}
+
+func TestTokenizeLanguage(t *testing.T) {
+	tokens := tokenize("I'll go", dt.LangEnglish)
+	if tokens[2] != "will" {
+		t.Fatal("expected English contractions to be expanded", tokens)
+	}
+	tokens = tokenize("d'Artagnan", dt.LangSpanish)
+	if tokens[0] != "d" {
+		t.Fatal("expected Spanish tokens to be left alone", tokens)
+	}
+}
+
+func TestBuildLanguageClassifier(t *testing.T) {
+	ner, err := buildLanguageClassifier(dt.LangSpanish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := ner.classifyTokens(tokenize("quiero pedir una pizza con Jim",
+		dt.LangSpanish))
+	if len(si.Commands) == 0 || si.Commands[len(si.Commands)-1] != "pedir" {
+		t.Fatal("expected command pedir, got", si.Commands)
+	}
+	if len(si.Objects) == 0 || si.Objects[len(si.Objects)-1] != "pizza" {
+		t.Fatal("expected object pizza, got", si.Objects)
+	}
+	var found bool
+	for _, p := range si.People {
+		found = found || p.Name == "Jim"
+	}
+	if !found {
+		t.Fatal("expected names to be shared across languages", si.People)
+	}
+}
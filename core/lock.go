@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// userLockTimeout is the longest a turn waits for the user's previous turn to
+// finish before failing, so one stuck turn can't hold up the user for good.
+var userLockTimeout = 30 * time.Second
+
+// lockUser serializes the turns of a single user. It takes a Postgres advisory
+// lock scoped to the turn's transaction, so a second message from the same
+// user, e.g. in a burst of SMS, waits until the first is committed or rolled
+// back. Since the lock is held by the database, turns are serialized even when
+// multiple Abot instances share the database. Waiting for the lock fails after
+// userLockTimeout.
+func lockUser(db dt.DBConn, u *dt.User) error {
+	q := fmt.Sprintf(`SET LOCAL lock_timeout = %d`,
+		userLockTimeout/time.Millisecond)
+	if _, err := db.Exec(q); err != nil {
+		return err
+	}
+	_, err := db.Exec(`SELECT pg_advisory_xact_lock($1)`, userLockKey(u))
+	if err != nil {
+		return err
+	}
+
+	// The timeout is only for the lock, not the rest of the turn.
+	_, err = db.Exec(`SET LOCAL lock_timeout TO DEFAULT`)
+	return err
+}
+
+// userLockKey identifies a user for locking. Users are identified by ID when
+// known, and otherwise by the flexid through which they're messaging. Distinct
+// users whose keys collide are merely serialized with one another.
+func userLockKey(u *dt.User) int64 {
+	var s string
+	if u.ID > 0 {
+		s = fmt.Sprintf("user:%d", u.ID)
+	} else {
+		s = fmt.Sprintf("flexid:%d:%s", u.FlexIDType, u.FlexID)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestUserLockKey(t *testing.T) {
+	phone := &dt.User{FlexID: "+13105555555", FlexIDType: dt.FIDTPhone}
+	email := &dt.User{FlexID: "+13105555555", FlexIDType: dt.FIDTEmail}
+	if userLockKey(phone) != userLockKey(&dt.User{
+		FlexID:     "+13105555555",
+		FlexIDType: dt.FIDTPhone,
+	}) {
+		t.Fatal("expected the same flexid to share a lock")
+	}
+	if userLockKey(phone) == userLockKey(email) {
+		t.Fatal("expected different flexid types not to share a lock")
+	}
+
+	// Known users are locked by ID regardless of the channel used.
+	phone.ID, email.ID = 1, 1
+	if userLockKey(phone) != userLockKey(email) {
+		t.Fatal("expected the same user to share a lock")
+	}
+}
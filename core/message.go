@@ -1,39 +1,87 @@
 package core
 
-import (
-	"github.com/itsabot/abot/core/log"
-	"github.com/itsabot/abot/shared/datatypes"
-)
+import "github.com/itsabot/abot/shared/datatypes"
 
 // NewMsg builds a message struct with Tokens, Stems, and a Structured Input.
 func NewMsg(u *dt.User, cmd string) (*dt.Msg, error) {
-	tokens := TokenizeSentence(cmd)
-	stems := StemTokens(tokens)
-	si := ner.classifyTokens(tokens)
+	return newMsg(db, u, cmd)
+}
 
-	// Get the intents as determined by each plugin
-	for pluginID, c := range bClassifiers {
-		scores, idx, _ := c.ProbScores(stems)
-		log.Debug("intent score", pluginIntents[pluginID][idx],
-			scores[idx])
-		if scores[idx] > 0.7 {
-			si.Intents = append(si.Intents,
-				string(pluginIntents[pluginID][idx]))
-		}
+// newMsg builds a message, recording and retrieving the user's context with the
+// given connection, such as the transaction of the user's turn.
+func newMsg(db dt.DBConn, u *dt.User, cmd string) (*dt.Msg, error) {
+	m, err := buildMsg(db, u, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err = saveContext(db, m); err != nil {
+		return nil, err
 	}
+	return m, nil
+}
 
+// buildMsg builds a message like newMsg, but without recording its context,
+// so the message may still be changed and analyzed again before its context
+// is saved (see processTurn).
+func buildMsg(db dt.DBConn, u *dt.User, cmd string) (*dt.Msg, error) {
 	m := &dt.Msg{
-		User:            u,
-		Sentence:        cmd,
-		Tokens:          tokens,
-		Stems:           stems,
-		StructuredInput: si,
+		User:     u,
+		Sentence: cmd,
+		Response: &dt.Response{},
 	}
-	if err := saveContext(db, m); err != nil {
+	var err error
+	m.Language, err = userLanguage(db, m, splitTokens(cmd))
+	if err != nil {
 		return nil, err
 	}
-	if err := addContext(db, m); err != nil {
+	if err = understandMsg(db, m); err != nil {
 		return nil, err
 	}
 	return m, nil
 }
+
+// understandMsg analyzes the sentence of a message, then finds its places and
+// adds the user's context to it.
+func understandMsg(db dt.DBConn, m *dt.Msg) error {
+	analyzeMsg(m)
+	places, err := extractPlaces(db, m, m.Tokens)
+	if err != nil {
+		return err
+	}
+	m.StructuredInput.Places = places
+
+	// Context is added before the message's own is saved, so follow-ups
+	// such as "an hour later" are resolved against what came before.
+	return addContext(db, m)
+}
+
+// analyzeMsg tokenizes, stems and classifies the sentence of a message in its
+// language, which must already be set. Finding places is left to the caller,
+// since it requires the user's context.
+func analyzeMsg(m *dt.Msg) {
+	tokens := tokenize(m.Sentence, m.Language)
+	ner := classifierFor(m.Language)
+
+	// Words are understood as corrected, but the tokens are left as the
+	// user wrote them.
+	corrected, corrections := spelling.correct(tokens, m.Language, ner)
+	stems := stemTokens(corrected, m.Language)
+	si := ner.classifyTokens(corrected)
+
+	// The part-of-speech tagger is trained on an English corpus.
+	if tagger != nil && m.Language == dt.LangEnglish {
+		si.Resolved = tagger.resolve(corrected, ambiguousWords(si))
+	}
+
+	// Get the intents as determined by each plugin
+	model := intentModels.get()
+	model.classify(stems, si)
+	detectNegation(corrected, m.Language, si)
+
+	m.Tokens = tokens
+	m.Stems = stems
+	m.StructuredInput = si
+	m.Corrections = corrections
+	m.IntentModelVersion = model.Version
+	si.Entities = extractEntities(m.Sentence)
+}
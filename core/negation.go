@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// errCanceled is returned by GetPlugin when the user calls off their request,
+// but the plugin with which they were conversing doesn't handle cancellation.
+var errCanceled = errors.New("request canceled")
+
+// cancelReply is sent to the user when they call off a request which no plugin
+// handles.
+const cancelReply = "OK, never mind."
+
+// negationCues are the words which begin the scope of a negation in each
+// language. Contractions such as "don't" are expanded to "not" before
+// negations are found.
+var negationCues = map[string]map[string]struct{}{
+	dt.LangEnglish: wordSet("not", "never", "no", "without", "neither",
+		"nor"),
+	dt.LangSpanish: wordSet("no", "nunca", "jamás", "sin", "ni",
+		"tampoco"),
+}
+
+// scopeBreaks end the scope of a negation, e.g. "but" in "Don't order pizza
+// but order sushi".
+var scopeBreaks = wordSet(".", ",", ";", "!", "?", "but", "instead",
+	"rather", "then", "except", "pero", "sino", "luego", "excepto")
+
+// scopeConjunctions end the scope of a negation when followed by a Command,
+// e.g. "and" in "Don't order pizza and call Jim", but not in "Don't order
+// pizza and soda".
+var scopeConjunctions = wordSet("and", "or", "y", "o", "e", "u")
+
+// cancelPhrases call off the user's request in each language.
+var cancelPhrases = map[string][][]string{
+	dt.LangEnglish: {
+		{"never", "mind"}, {"nevermind"}, {"forget", "it"},
+		{"forget", "that"}, {"forget", "about", "it"},
+		{"cancel", "that"}, {"cancel", "it"}, {"cancel", "this"},
+	},
+	dt.LangSpanish: {
+		{"olvídalo"}, {"olvidalo"}, {"olvida", "eso"}, {"no", "importa"},
+		{"déjalo"}, {"dejalo"}, {"cancela", "eso"}, {"cancélalo"},
+	},
+}
+
+// detectNegation marks the Commands and Objects of a tokenized sentence which
+// lie within the scope of a negation, and adds the cancel intent if the user
+// called off their request. A negation's scope runs from its cue, e.g. "not",
+// to the end of the clause.
+func detectNegation(tokens []string, lang string, si *dt.StructuredInput) {
+	cues, ok := negationCues[lang]
+	if !ok {
+		cues = negationCues[dt.LangEnglish]
+	}
+	commands := wordSet(si.Commands...)
+	objects := wordSet(si.Objects...)
+	negated := map[string]bool{}
+	affirmed := map[string]struct{}{}
+	isCue := func(i int) bool {
+		if i >= len(tokens) {
+			return false
+		}
+		_, ok := cues[strings.ToLower(tokens[i])]
+		return ok
+	}
+
+	// scoped marks the tokens which follow a negation's cue within its
+	// scope, so a cancel phrase negated by an earlier cue, e.g. "Don't
+	// cancel that", isn't taken to call off the user's request.
+	scoped := make([]bool, len(tokens))
+	var inScope bool
+	for i, t := range tokens {
+		t = strings.ToLower(t)
+		scoped[i] = inScope
+
+		// The auxiliary of a negation, e.g. "do" in "do not" or "don"
+		// in "don't", falls within its scope.
+		aux := isCue(i+1) || i+1 < len(tokens) && tokens[i+1] == "'" &&
+			isCue(i+2)
+		if _, ok := scopeBreaks[t]; ok {
+			inScope = false
+			continue
+		}
+		if _, ok := scopeConjunctions[t]; ok && i+1 < len(tokens) {
+			next := strings.ToLower(tokens[i+1])
+			if _, ok = commands[next]; ok {
+				inScope = false
+			}
+			continue
+		}
+		if isCue(i) {
+			inScope = true
+			continue
+		}
+		_, isCommand := commands[t]
+		_, isObject := objects[t]
+		if !isCommand && !isObject {
+			continue
+		}
+		if inScope || aux {
+			negated[t] = true
+		} else {
+			affirmed[t] = struct{}{}
+		}
+	}
+	for t := range affirmed {
+		delete(negated, t)
+	}
+	if len(negated) > 0 {
+		si.Negated = negated
+	}
+	if cancels(tokens, lang, scoped) {
+		si.Intents = append(si.Intents, dt.IntentCancel)
+		if si.IntentScores == nil {
+			si.IntentScores = map[string]float64{}
+		}
+		si.IntentScores[dt.IntentCancel] = 1
+	}
+}
+
+// cancels reports whether a tokenized sentence contains a phrase calling off
+// the user's request. Phrases beginning within the scope of a negation, as
+// marked by scoped, are skipped.
+func cancels(tokens []string, lang string, scoped []bool) bool {
+	phrases, ok := cancelPhrases[lang]
+	if !ok {
+		phrases = cancelPhrases[dt.LangEnglish]
+	}
+	for i := range tokens {
+		if scoped[i] {
+			continue
+		}
+		for _, phrase := range phrases {
+			if i+len(phrase) > len(tokens) {
+				continue
+			}
+			match := true
+			for j, w := range phrase {
+				if strings.ToLower(tokens[i+j]) != w {
+					match = false
+					break
+				}
+			}
+			if match {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestDetectNegation(t *testing.T) {
+	ner, err := buildClassifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sent     string
+		negated  []string
+		affirmed []string
+		negative bool
+		canceled bool
+	}{
+		{"order pizza", nil, []string{"order", "pizza"}, false, false},
+		{"Don't order pizza", []string{"order", "pizza"}, nil, true,
+			false},
+		{"never mind the pizza", []string{"pizza"}, nil, true, true},
+		{"do not order pizza", []string{"order", "pizza"}, nil, true,
+			false},
+		{"don't order pizza, order sushi", []string{"pizza"},
+			[]string{"order", "sushi"}, false, false},
+		{"don't order pizza and call Jim", []string{"pizza"},
+			[]string{"call"}, false, false},
+		{"forget it", nil, nil, false, true},
+		{"Don't cancel that", []string{"cancel"}, nil, true, false},
+		{"oh never mind", nil, nil, true, true},
+	}
+	for _, test := range tests {
+		tokens := TokenizeSentence(test.sent)
+		si := ner.classifyTokens(tokens)
+		detectNegation(tokens, dt.LangEnglish, si)
+		for _, w := range test.negated {
+			if !si.IsNegated(w) {
+				t.Errorf("%q: expected %q to be negated", test.sent, w)
+			}
+		}
+		for _, w := range test.affirmed {
+			if si.IsNegated(w) {
+				t.Errorf("%q: expected %q not to be negated",
+					test.sent, w)
+			}
+		}
+		if si.Negative() != test.negative {
+			t.Errorf("%q: expected negative %t", test.sent,
+				test.negative)
+		}
+		if si.Canceled() != test.canceled {
+			t.Errorf("%q: expected canceled %t", test.sent,
+				test.canceled)
+		}
+	}
+}
+
+func TestDetectNegationSpanish(t *testing.T) {
+	si := &dt.StructuredInput{
+		Commands: []string{"pidas"},
+		Objects:  []string{"pizza"},
+	}
+	detectNegation(tokenize("no pidas pizza", dt.LangSpanish),
+		dt.LangSpanish, si)
+	if !si.Negative() {
+		t.Fatal("expected negative message")
+	}
+	si = &dt.StructuredInput{}
+	detectNegation(tokenize("olvídalo", dt.LangSpanish), dt.LangSpanish,
+		si)
+	if !si.Canceled() {
+		t.Fatal("expected canceled message")
+	}
+}
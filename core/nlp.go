@@ -8,9 +8,9 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/dchest/stemmer/porter2"
 	"github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/snowball"
 	"github.com/itsabot/abot/shared/helpers/timeparse"
 )
 
@@ -91,18 +91,57 @@ func (c classifier) classifyTokens(tokens []string) *dt.StructuredInput {
 		}
 		s.Times = append(s.Times, timeparse.Parse(sec)...)
 	}
+	if expr := timeRangeExpression(tokens); expr != "" {
+		s.TimeRanges = timeparse.ParseRange(expr)
+	}
 	return &s
 }
 
+// known reports whether a lowercase word is in the classifier's dictionaries.
+func (c classifier) known(word string) bool {
+	for _, prefix := range []string{"C", "O", "PM", "PF"} {
+		if _, ok := c[prefix+word]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sex returns the sex of a person judging by their first name, or SexInvalid
+// if the name isn't known.
+func (c classifier) sex(name string) dt.Sex {
+	fields := strings.Fields(strings.ToLower(name))
+	if len(fields) == 0 {
+		return dt.SexInvalid
+	}
+	_, male := c["PM"+fields[0]]
+	_, female := c["PF"+fields[0]]
+	switch {
+	case male && female:
+		return dt.SexEither
+	case male:
+		return dt.SexMale
+	case female:
+		return dt.SexFemale
+	}
+	return dt.SexInvalid
+}
+
 // buildClassifier prepares the Named Entity Recognizer (NER) to find Commands
 // and Objects using a simple dictionary lookup. This has the benefit of high
 // speed--constant time, O(1)--with insignificant memory use and high accuracy
 // given false positives (marking something as both a Command and an Object when
-// it's really acting as an Object) are OK. Ultimately this should be a first
-// pass, and any double-marked words should be passed through something like an
-// n-gram Bayesian filter to determine the correct part of speech within its
-// context in the sentence.
+// it's really acting as an Object) are OK. This is a first pass, and any
+// double-marked words are then resolved by the part-of-speech tagger to the
+// part they play within the sentence (see posTagger).
 func buildClassifier() (classifier, error) {
+	return buildLanguageClassifier(dt.LangEnglish)
+}
+
+// buildLanguageClassifier prepares the NER for a language. English word lists
+// are found in data/ner, and those of other languages in data/ner/<lang>,
+// e.g. data/ner/es. Names are shared by all languages.
+func buildLanguageClassifier(lang string) (classifier, error) {
 	ner := classifier{}
 	var p string
 	if os.Getenv("ABOT_ENV") == "test" {
@@ -111,79 +150,63 @@ func buildClassifier() (classifier, error) {
 	} else {
 		p = filepath.Join("data", "ner")
 	}
-	fi, err := os.Open(filepath.Join(p, "nouns.txt"))
-	if err != nil {
-		return ner, err
-	}
-	scanner := bufio.NewScanner(fi)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		ner["O"+scanner.Text()] = struct{}{}
-	}
-	if err = fi.Close(); err != nil {
-		return ner, err
-	}
-	fi, err = os.Open(filepath.Join(p, "verbs.txt"))
-	if err != nil {
-		return ner, err
-	}
-	scanner = bufio.NewScanner(fi)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		ner["C"+scanner.Text()] = struct{}{}
-	}
-	if err = fi.Close(); err != nil {
-		return ner, err
-	}
-	fi, err = os.Open(filepath.Join(p, "adjectives.txt"))
-	if err != nil {
-		return ner, err
-	}
-	scanner = bufio.NewScanner(fi)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		ner["O"+scanner.Text()] = struct{}{}
-	}
-	if err = fi.Close(); err != nil {
-		return ner, err
-	}
-	fi, err = os.Open(filepath.Join(p, "adverbs.txt"))
-	if err != nil {
-		return ner, err
-	}
-	scanner = bufio.NewScanner(fi)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		ner["O"+scanner.Text()] = struct{}{}
-	}
-	if err = fi.Close(); err != nil {
-		return ner, err
-	}
-	fi, err = os.Open(filepath.Join(p, "names_female.txt"))
-	if err != nil {
-		return ner, err
-	}
-	scanner = bufio.NewScanner(fi)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		ner["PF"+scanner.Text()] = struct{}{}
-	}
-	if err = fi.Close(); err != nil {
-		return ner, err
-	}
-	fi, err = os.Open(filepath.Join(p, "names_male.txt"))
-	if err != nil {
-		return ner, err
+	words := p
+	if lang != dt.LangEnglish {
+		words = filepath.Join(p, lang)
+	}
+	files := []struct {
+		path, prefix string
+	}{
+		{filepath.Join(words, "nouns.txt"), "O"},
+		{filepath.Join(words, "verbs.txt"), "C"},
+		{filepath.Join(words, "adjectives.txt"), "O"},
+		{filepath.Join(words, "adverbs.txt"), "O"},
+		{filepath.Join(p, "names_female.txt"), "PF"},
+		{filepath.Join(p, "names_male.txt"), "PM"},
+	}
+	for _, f := range files {
+		fi, err := os.Open(f.path)
+		if err != nil {
+			return ner, err
+		}
+		scanner := bufio.NewScanner(fi)
+		scanner.Split(bufio.ScanLines)
+		for scanner.Scan() {
+			ner[f.prefix+scanner.Text()] = struct{}{}
+		}
+		if err = fi.Close(); err != nil {
+			return ner, err
+		}
 	}
-	scanner = bufio.NewScanner(fi)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		ner["PM"+scanner.Text()] = struct{}{}
+	return ner, nil
+}
+
+// buildClassifiers prepares the NER for each language Abot understands.
+// Languages whose word lists can't be loaded are skipped, falling back to
+// English (see classifierFor).
+func buildClassifiers() (map[string]classifier, error) {
+	ners := map[string]classifier{}
+	for _, lang := range languages {
+		ner, err := buildLanguageClassifier(lang)
+		if err != nil {
+			if lang == dt.LangEnglish {
+				return ners, err
+			}
+			log.Debug("could not build classifier for", lang, err)
+			continue
+		}
+		ners[lang] = ner
 	}
-	if err = fi.Close(); err != nil {
-		return ner, err
+	return ners, nil
+}
+
+// classifierFor returns the NER for a language, or the English NER if the
+// language has none.
+func classifierFor(lang string) classifier {
+	if ner, ok := ners[lang]; ok {
+		return ner
 	}
-	return ner, nil
+	return ners[dt.LangEnglish]
 }
 
 // buildOffensiveMap creates a map of offensive terms for which Abot will refuse
@@ -337,6 +360,22 @@ func ConfusedLang() string {
 // contractions into the words they represent, e.g. "How're you?" becomes
 // []string{"How", "'", "are", "you", "?"}.
 func TokenizeSentence(sent string) []string {
+	return tokenize(sent, dt.LangEnglish)
+}
+
+// tokenize breaks a sentence in the given language into tokens, expanding
+// contractions only in English.
+func tokenize(sent, lang string) []string {
+	tokens := splitTokens(sent)
+	if lang == dt.LangEnglish {
+		expandContractions(tokens)
+	}
+	log.Debug("found tokens", tokens)
+	return tokens
+}
+
+// splitTokens breaks a sentence into words and punctuation.
+func splitTokens(sent string) []string {
 	tokens := []string{}
 	for _, w := range strings.Fields(sent) {
 		found := []int{}
@@ -386,9 +425,14 @@ func TokenizeSentence(sent string) []string {
 		}
 	}
 
-	// Expand contractions. This isn't perfect and doesn't need to be to
-	// fulfill its purpose, which is fundamentally making it easier to find
-	// times in a sentence containing contractions.
+	return tokens
+}
+
+// expandContractions replaces the parts of English contractions with the words
+// they represent. This isn't perfect and doesn't need to be to fulfill its
+// purpose, which is fundamentally making it easier to find times in a sentence
+// containing contractions.
+func expandContractions(tokens []string) {
 	for i, t := range tokens {
 		switch t {
 		case "s":
@@ -407,14 +451,17 @@ func TokenizeSentence(sent string) []string {
 			tokens[i] = "would"
 		}
 	}
-	log.Debug("found tokens", tokens)
-	return tokens
 }
 
 // StemTokens returns the porter2 (snowball) stems for each token passed into
 // it.
 func StemTokens(tokens []string) []string {
-	eng := porter2.Stemmer
+	return stemTokens(tokens, dt.LangEnglish)
+}
+
+// stemTokens returns the snowball stems for each token in the given language.
+func stemTokens(tokens []string, lang string) []string {
+	stemmer := snowball.For(lang)
 	stems := []string{}
 	for _, w := range tokens {
 		if len(w) == 1 {
@@ -424,7 +471,7 @@ func StemTokens(tokens []string) []string {
 			}
 		}
 		w = strings.ToLower(w)
-		stems = append(stems, eng.Stem(w))
+		stems = append(stems, stemmer.Stem(w))
 	}
 	return stems
 }
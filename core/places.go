@@ -0,0 +1,230 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/address"
+	"github.com/itsabot/abot/shared/prefs"
+	"github.com/jmoiron/sqlx"
+)
+
+// maxCityWords is the most words in a city's name, e.g. "Salt Lake City".
+const maxCityWords = 3
+
+// placePrepositions signal that a place may follow, e.g. "in" in "Find
+// restaurants in Los Angeles". Cities are only looked up following them.
+var placePrepositions = map[string]map[string]struct{}{
+	dt.LangEnglish: wordSet("in", "at", "to", "from", "near", "around",
+		"into"),
+	dt.LangSpanish: wordSet("en", "a", "de", "desde", "cerca", "hacia",
+		"hasta"),
+}
+
+// placeLabels map the words with which users refer to their saved addresses
+// to the memory keys under which they're saved.
+var placeLabels = map[string]string{
+	"home":    prefs.HomeAddress,
+	"work":    prefs.WorkAddress,
+	"office":  prefs.WorkAddress,
+	"casa":    prefs.HomeAddress,
+	"trabajo": prefs.WorkAddress,
+	"oficina": prefs.WorkAddress,
+}
+
+// placeDeterminers may come between a place preposition and a place label,
+// e.g. "my" in "directions to my office".
+var placeDeterminers = map[string]map[string]struct{}{
+	dt.LangEnglish: wordSet("my", "our", "the"),
+	dt.LangSpanish: wordSet("mi", "nuestra", "nuestro", "la", "el"),
+}
+
+// extractPlaces finds the places mentioned in a message: the user's saved
+// addresses referred to by label, e.g. "home", a street address, and cities
+// following a preposition such as "in". Places which can't be resolved, such
+// as "home" when no home address was saved, are left out.
+func extractPlaces(db dt.DBConn, in *dt.Msg, tokens []string) ([]dt.Place,
+	error) {
+
+	var places []dt.Place
+	seen := map[string]struct{}{}
+	for _, label := range findPlaceLabels(tokens, in.Language) {
+		key := placeLabels[label]
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		addr, err := savedAddress(db, in, key)
+		if err != nil {
+			return nil, err
+		}
+		if addr == nil {
+			continue
+		}
+		places = append(places, dt.Place{Name: label, Address: addr})
+	}
+	if addr, err := address.Parse(in.Sentence); err == nil {
+		places = append(places, dt.Place{
+			Name:    addr.Line1,
+			Address: addr,
+		})
+	}
+	cities, err := extractCities(db, placeCandidates(tokens, in.Language))
+	if err != nil {
+		return nil, err
+	}
+	for i := range cities {
+		places = append(places, dt.Place{
+			Name: cities[i].Name,
+			City: &cities[i],
+		})
+	}
+	return places, nil
+}
+
+// findPlaceLabels returns the lowercase labels of saved addresses, e.g.
+// "home", which follow a preposition signaling a place, as in "directions to
+// my office". Labels elsewhere are left out, since words like "work" are
+// common verbs, e.g. "does this work?"
+func findPlaceLabels(tokens []string, lang string) []string {
+	preps, ok := placePrepositions[lang]
+	if !ok {
+		lang = dt.LangEnglish
+		preps = placePrepositions[lang]
+	}
+	var labels []string
+	for i, t := range tokens {
+		label := strings.ToLower(t)
+		if _, ok := placeLabels[label]; !ok {
+			continue
+		}
+		j := i - 1
+		if j >= 0 {
+			_, ok = placeDeterminers[lang][strings.ToLower(tokens[j])]
+			if ok {
+				j--
+			}
+		}
+		if j < 0 {
+			continue
+		}
+		if _, ok = preps[strings.ToLower(tokens[j])]; ok {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// savedAddress returns the address saved in a user's memory under a key, e.g.
+// prefs.HomeAddress, by any plugin. The most recently updated address is
+// preferred, and values which aren't addresses are skipped. It returns nil if
+// no address was saved.
+func savedAddress(db dt.DBConn, in *dt.Msg, key string) (*dt.Address,
+	error) {
+
+	var vals [][]byte
+	var err error
+	if in.User.ID > 0 {
+		q := `SELECT value FROM states WHERE userid=$1 AND key=$2
+		      ORDER BY updatedat DESC, id DESC`
+		err = db.Select(&vals, q, in.User.ID, key)
+	} else {
+		q := `SELECT value FROM states
+		      WHERE flexid=$1 AND flexidtype=$2 AND key=$3
+		      ORDER BY updatedat DESC, id DESC`
+		err = db.Select(&vals, q, in.User.FlexID, in.User.FlexIDType,
+			key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, val := range vals {
+		addr := &dt.Address{}
+		if err = json.Unmarshal(val, addr); err != nil {
+			log.Debug("skipping saved", key, "which isn't an address")
+			continue
+		}
+		if len(addr.Line1) > 0 {
+			return addr, nil
+		}
+	}
+	return nil, nil
+}
+
+// placeCandidates returns the words and phrases of up to maxCityWords which
+// follow a preposition signaling a place, title-cased to match the names in
+// the cities table.
+func placeCandidates(tokens []string, lang string) []string {
+	preps, ok := placePrepositions[lang]
+	if !ok {
+		preps = placePrepositions[dt.LangEnglish]
+	}
+	var cands []string
+	for i, t := range tokens {
+		if _, ok := preps[strings.ToLower(t)]; !ok {
+			continue
+		}
+		var words []string
+		for j := i + 1; j < len(tokens) && len(words) < maxCityWords; j++ {
+			w := tokens[j]
+			if len(w) == 1 && strings.ContainsAny(w, ".,;:!?'\"") {
+				break
+			}
+			words = append(words, strings.Title(strings.ToLower(w)))
+			cands = append(cands, strings.Join(words, " "))
+		}
+	}
+	return cands
+}
+
+// extractCities looks up the candidate names in the cities table. Where a name
+// is shared by several countries, the US city is preferred. Cities whose
+// names are part of a longer city found, e.g. "York" in "New York", are left
+// out.
+func extractCities(db dt.DBConn, cands []string) ([]dt.City, error) {
+	if len(cands) == 0 {
+		return nil, nil
+	}
+	var rows []struct {
+		Name        string
+		CountryCode string
+		Lat         sql.NullFloat64
+		Lon         sql.NullFloat64
+	}
+	q := `SELECT DISTINCT ON (name) name, countrycode, lat, lon
+	      FROM cities WHERE name IN (?)
+	      ORDER BY name, countrycode='US' DESC`
+	q, args, err := sqlx.In(q, cands)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Select(&rows, sqlx.Rebind(sqlx.DOLLAR, q),
+		args...); err != nil {
+		return nil, err
+	}
+	var cities []dt.City
+	for _, row := range rows {
+		var partial bool
+		for _, other := range rows {
+			if other.Name != row.Name &&
+				strings.Contains(other.Name, row.Name) {
+				partial = true
+				break
+			}
+		}
+		if partial {
+			continue
+		}
+		cities = append(cities, dt.City{
+			Name:        row.Name,
+			CountryCode: row.CountryCode,
+			Lat:         row.Lat.Float64,
+			Lon:         row.Lon.Float64,
+		})
+	}
+	log.Debug("found cities", cities)
+	return cities, nil
+}
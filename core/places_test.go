@@ -0,0 +1,60 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestPlaceCandidates(t *testing.T) {
+	tests := map[string][]string{
+		"find restaurants in los angeles": {"Los", "Los Angeles"},
+		"fly from Salt Lake City to Denver, please": {"Salt",
+			"Salt Lake", "Salt Lake City", "Denver"},
+		"order pizza": nil,
+	}
+	for sent, exp := range tests {
+		cands := placeCandidates(TokenizeSentence(sent), dt.LangEnglish)
+		if !reflect.DeepEqual(cands, exp) {
+			t.Errorf("%q: expected %v, got %v", sent, exp, cands)
+		}
+	}
+	cands := placeCandidates(tokenize("busca hoteles en Madrid",
+		dt.LangSpanish), dt.LangSpanish)
+	if !reflect.DeepEqual(cands, []string{"Madrid"}) {
+		t.Error("expected Madrid, got", cands)
+	}
+}
+
+func TestFindPlaceLabels(t *testing.T) {
+	tests := map[string][]string{
+		"directions to work":      {"work"},
+		"drive me to my home":     {"home"},
+		"does this work?":         nil,
+		"work from home":          {"home"},
+		"home is where the heart": nil,
+	}
+	for sent, exp := range tests {
+		labels := findPlaceLabels(TokenizeSentence(sent), dt.LangEnglish)
+		if !reflect.DeepEqual(labels, exp) {
+			t.Errorf("%q: expected %v, got %v", sent, exp, labels)
+		}
+	}
+	labels := findPlaceLabels(tokenize("llévame a mi casa",
+		dt.LangSpanish), dt.LangSpanish)
+	if !reflect.DeepEqual(labels, []string{"casa"}) {
+		t.Error("expected casa, got", labels)
+	}
+}
+
+func TestPlaceCoordinates(t *testing.T) {
+	p := dt.Place{Name: "home", Address: &dt.Address{Line1: "1 Main St"}}
+	if _, _, ok := p.Coordinates(); ok {
+		t.Fatal("expected unknown coordinates")
+	}
+	p = dt.Place{Name: "Austin", City: &dt.City{Lat: 30.27, Lon: -97.74}}
+	if lat, _, ok := p.Coordinates(); !ok || lat != 30.27 {
+		t.Fatal("expected the city's coordinates")
+	}
+}
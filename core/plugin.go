@@ -3,13 +3,13 @@ package core
 import (
 	"database/sql"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 
-	"github.com/dchest/stemmer/porter2"
 	"github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
-	"github.com/jmoiron/sqlx"
+	"github.com/itsabot/abot/shared/helpers/snowball"
 )
 
 // PluginJSON holds the plugins.json structure.
@@ -24,7 +24,7 @@ type PluginJSON struct {
 // RegPlugins initializes a PkgMap and holds it in global memory, which works OK
 // given PkgMap is an atomic, thread-safe map.
 var RegPlugins = PkgMap{
-	plugins: make(map[string]*dt.Plugin),
+	plugins: make(map[string][]*dt.Plugin),
 	mutex:   &sync.Mutex{},
 }
 
@@ -33,48 +33,145 @@ var AllPlugins = []*dt.Plugin{}
 
 // PkgMap is a thread-safe atomic map that's used to route user messages to the
 // appropriate plugins. The map's key is the route in the form of
-// command_object, e.g. "find_restaurant".
+// command_object, e.g. "find_restaurant". Multiple plugins may share a route,
+// in which case they're held in order of their priority (see
+// dt.PluginConfig.Priority). Plugins with equal priority are held in the order
+// they were registered.
 type PkgMap struct {
-	plugins map[string]*dt.Plugin
+	plugins map[string][]*dt.Plugin
 	mutex   *sync.Mutex
 }
 
-// Get is a thread-safe, locking way to access the values of a PkgMap.
+// Get is a thread-safe, locking way to access the values of a PkgMap. If
+// multiple plugins share the route, the one with the highest priority is
+// returned.
 func (pm PkgMap) Get(k string) *dt.Plugin {
 	var p *dt.Plugin
 	pm.mutex.Lock()
-	p = pm.plugins[k]
+	if plugins := pm.plugins[k]; len(plugins) > 0 {
+		p = plugins[0]
+	}
 	pm.mutex.Unlock()
 	runtime.Gosched()
 	return p
 }
 
-// Set is a thread-safe, locking way to set the values of a PkgMap.
+// GetAll is a thread-safe, locking way to access every plugin registered for a
+// route, ordered from highest to lowest priority.
+func (pm PkgMap) GetAll(k string) []*dt.Plugin {
+	pm.mutex.Lock()
+	plugins := append([]*dt.Plugin{}, pm.plugins[k]...)
+	pm.mutex.Unlock()
+	runtime.Gosched()
+	return plugins
+}
+
+// Set is a thread-safe, locking way to set the values of a PkgMap. The plugin
+// is added to the route in order of its priority. If a plugin with the same
+// name is already registered for the route, it's replaced.
 func (pm PkgMap) Set(k string, v *dt.Plugin) {
 	pm.mutex.Lock()
-	pm.plugins[k] = v
+	var plugins []*dt.Plugin
+	for _, p := range pm.plugins[k] {
+		if p.Config.Name != v.Config.Name {
+			plugins = append(plugins, p)
+		}
+	}
+	i := sort.Search(len(plugins), func(i int) bool {
+		return plugins[i].Config.Priority < v.Config.Priority
+	})
+	plugins = append(plugins, nil)
+	copy(plugins[i+1:], plugins[i:])
+	plugins[i] = v
+	pm.plugins[k] = plugins
 	pm.mutex.Unlock()
+	spelling.addTriggerRoute(k)
 	runtime.Gosched()
 }
 
-// GetPlugin attempts to find a plugin and route for the given msg input if none
-// can be found, it checks the database for the last route used and gets the
-// plugin for that. If there is no previously used plugin, we return
-// errMissingPlugin. The bool value return indicates whether this plugin is
-// different from the last plugin used by the user.
-func GetPlugin(db *sqlx.DB, m *dt.Msg) (p *dt.Plugin, route string, directroute,
-	followup bool, err error) {
+// getAccepting returns the highest priority plugin for a route which doesn't
+// decline the message, or nil if every plugin declines it.
+func (pm PkgMap) getAccepting(k string, in *dt.Msg) *dt.Plugin {
+	for _, p := range pm.GetAll(k) {
+		if p.Decline != nil && p.Decline(in) {
+			log.Debug("plugin declined message", p.Config.Name)
+			continue
+		}
+		return p
+	}
+	return nil
+}
 
-	// Iterate through all intents to see if any plugin has been registered
-	// for the route
-	for _, i := range m.StructuredInput.Intents {
-		route = "I_" + strings.ToLower(i)
-		log.Debug("searching for route", route)
-		if p = RegPlugins.Get(route); p != nil {
-			// Found route. Return it
-			return p, route, true, false, nil
+// getByName returns the plugin registered for a route with the given name, or
+// nil if that plugin isn't registered for the route.
+func (pm PkgMap) getByName(k, name string) *dt.Plugin {
+	for _, p := range pm.GetAll(k) {
+		if p.Config.Name == name {
+			return p
 		}
 	}
+	return nil
+}
+
+// Weights used to score each plugin which could respond to a message. See
+// GetPlugin.
+const (
+	// scoreIntent is multiplied by the probability of a matched intent.
+	scoreIntent = 2.0
+
+	// scoreCOMatch is added for each Command/Object route matched.
+	scoreCOMatch = 1.0
+
+	// scorePrevPlugin biases routing toward the plugin with which the user
+	// is already conversing.
+	scorePrevPlugin = 0.5
+
+	// scoreMargin is the minimum lead the top-scoring plugin must have
+	// over the next plugin. Any closer, and Abot asks the user which
+	// plugin was meant.
+	scoreMargin = 0.5
+)
+
+// pluginCandidate is a plugin that could respond to a message, scored by how
+// well it matches.
+type pluginCandidate struct {
+	Plugin *dt.Plugin
+	Route  string
+	Score  float64
+
+	// routeScore tracks the score contributed by Route alone, so the
+	// strongest route of each plugin is the one used.
+	routeScore float64
+}
+
+// errAmbiguousPlugin is returned by GetPlugin when multiple plugins match a
+// message with similar scores. Abot should ask the user which was meant.
+type errAmbiguousPlugin struct {
+	Candidates []*pluginCandidate
+}
+
+func (e *errAmbiguousPlugin) Error() string {
+	return "ambiguous plugin"
+}
+
+// GetPlugin attempts to find a plugin and route for the given msg input. Every
+// plugin registered for the message's intents or command/object pairs is
+// scored based on the probability of each matched intent, the number of
+// command/object routes matched, and whether the user was already conversing
+// with that plugin. The highest scoring plugin is returned. If two plugins
+// score too closely, an *errAmbiguousPlugin is returned listing them, so the
+// user can be asked which was meant.
+//
+// Negated commands and objects (see dt.StructuredInput.Negated) aren't routed,
+// and a message canceling the user's request is routed only to the plugin
+// with which they were conversing, or else errCanceled is returned.
+//
+// If no plugin matches, GetPlugin checks the database for the last route used
+// and gets the plugin for that. If there is no previously used plugin, we
+// return errMissingPlugin. The followup bool indicates whether this plugin is
+// the same as the last plugin used by the user.
+func GetPlugin(db dt.DBConn, m *dt.Msg) (p *dt.Plugin, route string, directroute,
+	followup bool, err error) {
 
 	log.Debug("getting last plugin route")
 	prevPlugin, prevRoute, err := m.GetLastPlugin(db)
@@ -86,27 +183,53 @@ func GetPlugin(db *sqlx.DB, m *dt.Msg) (p *dt.Plugin, route string, directroute,
 			prevPlugin, prevRoute)
 	}
 
-	// Iterate over all command/object pairs and see if any plugin has been
-	// registered for the resulting route
-	eng := porter2.Stemmer
-	for _, c := range m.StructuredInput.Commands {
-		c = strings.ToLower(eng.Stem(c))
-		for _, o := range m.StructuredInput.Objects {
-			o = strings.ToLower(eng.Stem(o))
-			route := "CO_" + c + "_" + o
-			log.Debug("searching for route", route)
-			if p = RegPlugins.Get(route); p != nil {
-				// Found route. Return it
-				followup := prevPlugin == p.Config.Name
-				return p, route, true, followup, nil
+	// If the user called off their request, let the plugin with which
+	// they were conversing handle it. Any question Abot asked about which
+	// plugin was meant is dropped.
+	if m.StructuredInput.Canceled() {
+		if err = deleteState(db, m, keyDisambiguation); err != nil {
+			return nil, "", false, false, err
+		}
+		route = "I_" + dt.IntentCancel
+		if p = RegPlugins.getByName(route, prevPlugin); p != nil {
+			return p, route, true, true, nil
+		}
+		return nil, "", false, false, errCanceled
+	}
+
+	// If Abot asked the user to choose between plugins, see if this
+	// message answers that question.
+	c, err := resolveDisambiguation(db, m)
+	if err != nil {
+		return nil, "", false, false, err
+	}
+	if c != nil {
+		return c.Plugin, c.Route, true, false, nil
+	}
+
+	cands := scorePlugins(m, prevPlugin)
+	if len(cands) > 1 && cands[0].Score-cands[1].Score < scoreMargin {
+		amb := &errAmbiguousPlugin{}
+		for _, c := range cands {
+			if cands[0].Score-c.Score >= scoreMargin {
+				break
 			}
+			amb.Candidates = append(amb.Candidates, c)
 		}
+		log.Debug("found ambiguous plugins", len(amb.Candidates))
+		return nil, "", false, false, amb
+	}
+	if len(cands) > 0 {
+		c := cands[0]
+		log.Debugf("found route %s (score %.2f)\n", c.Route, c.Score)
+		followup = prevPlugin == c.Plugin.Config.Name
+		return c.Plugin, c.Route, true, followup, nil
 	}
 
 	// The user input didn't match any plugins. Let's see if the previous
 	// route does
 	if prevRoute != "" {
-		if p = RegPlugins.Get(prevRoute); p != nil {
+		if p = RegPlugins.getAccepting(prevRoute, m); p != nil {
 			// Prev route matches a pkg! Return it
 			return p, prevRoute, false, true, nil
 		}
@@ -116,3 +239,87 @@ func GetPlugin(db *sqlx.DB, m *dt.Msg) (p *dt.Plugin, route string, directroute,
 	log.Debug("could not match user input to any plugin")
 	return nil, "", false, false, errMissingPlugin
 }
+
+// scorePlugins scores every plugin registered for the message's intents and
+// command/object pairs, returning them from highest to lowest score. The
+// plugin with which the user was last conversing, prevPlugin, scores higher.
+func scorePlugins(m *dt.Msg, prevPlugin string) []*pluginCandidate {
+	cands := []*pluginCandidate{}
+	add := func(p *dt.Plugin, route string, score float64) {
+		for _, c := range cands {
+			if c.Plugin.Config.Name != p.Config.Name {
+				continue
+			}
+			c.Score += score
+			if score > c.routeScore {
+				c.Route, c.routeScore = route, score
+			}
+			return
+		}
+		cands = append(cands, &pluginCandidate{
+			Plugin:     p,
+			Route:      route,
+			Score:      score,
+			routeScore: score,
+		})
+	}
+	// A negative message, e.g. "Don't order pizza", triggers no plugin by
+	// its intents, nor by any negated command or object.
+	negative := m.StructuredInput.Negative()
+	for _, i := range m.StructuredInput.Intents {
+		if negative {
+			break
+		}
+		route := "I_" + strings.ToLower(i)
+		log.Debug("searching for route", route)
+		if p := RegPlugins.getAccepting(route, m); p != nil {
+			prob, ok := m.StructuredInput.IntentScores[i]
+			if !ok {
+				prob = 1
+			}
+			add(p, route, scoreIntent*prob)
+		}
+	}
+	// Words the part-of-speech tagger resolved, e.g. "book" as an Object
+	// in "Put the book on the table", are only paired as that part.
+	si := m.StructuredInput
+	stemmer := snowball.For(m.Language)
+	for _, c := range si.Commands {
+		if si.IsNegated(c) || !si.ActsAs(c, dt.SITCommand) {
+			continue
+		}
+		c = strings.ToLower(stemmer.Stem(c))
+		for _, o := range si.Objects {
+			if si.IsNegated(o) || !si.ActsAs(o, dt.SITObject) {
+				continue
+			}
+			o = strings.ToLower(stemmer.Stem(o))
+			route := "CO_" + c + "_" + o
+			log.Debug("searching for route", route)
+			if p := RegPlugins.getAccepting(route, m); p != nil {
+				add(p, route, scoreCOMatch)
+			}
+		}
+	}
+	for _, c := range cands {
+		if c.Plugin.Config.Name == prevPlugin {
+			c.Score += scorePrevPlugin
+		}
+	}
+	return rankPluginCandidates(cands)
+}
+
+// rankPluginCandidates sorts candidates from highest to lowest score. Plugins
+// with equal scores retain the order in which they were found.
+func rankPluginCandidates(cands []*pluginCandidate) []*pluginCandidate {
+	sort.Stable(byScore(cands))
+	return cands
+}
+
+// byScore implements sort.Interface to order plugin candidates by descending
+// score.
+type byScore []*pluginCandidate
+
+func (s byScore) Len() int           { return len(s) }
+func (s byScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool { return s[i].Score > s[j].Score }
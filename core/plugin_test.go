@@ -0,0 +1,55 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestPkgMapPriority(t *testing.T) {
+	pm := PkgMap{
+		plugins: map[string][]*dt.Plugin{},
+		mutex:   &sync.Mutex{},
+	}
+	newPlugin := func(name string, priority int) *dt.Plugin {
+		p := &dt.Plugin{}
+		p.Config.Name = name
+		p.Config.Priority = priority
+		return p
+	}
+	route := "CO_find_restaurant"
+	pm.Set(route, newPlugin("a", 0))
+	pm.Set(route, newPlugin("b", 10))
+	pm.Set(route, newPlugin("c", 0))
+	pm.Set(route, newPlugin("d", 5))
+	exp := []string{"b", "d", "a", "c"}
+	plugins := pm.GetAll(route)
+	if len(plugins) != len(exp) {
+		t.Fatalf("expected %d plugins, got %d", len(exp), len(plugins))
+	}
+	for i := range exp {
+		if plugins[i].Config.Name != exp[i] {
+			t.Fatalf("expected %s at %d, got %s", exp[i], i,
+				plugins[i].Config.Name)
+		}
+	}
+	if p := pm.Get(route); p.Config.Name != "b" {
+		t.Fatal("expected b, got", p.Config.Name)
+	}
+
+	// Re-registering a plugin replaces it rather than duplicating it.
+	pm.Set(route, newPlugin("b", -1))
+	if n := len(pm.GetAll(route)); n != len(exp) {
+		t.Fatalf("expected %d plugins, got %d", len(exp), n)
+	}
+	if p := pm.Get(route); p.Config.Name != "d" {
+		t.Fatal("expected d, got", p.Config.Name)
+	}
+
+	// Declining plugins are skipped.
+	pm.plugins[route][0].Decline = func(in *dt.Msg) bool { return true }
+	if p := pm.getAccepting(route, &dt.Msg{}); p.Config.Name != "a" {
+		t.Fatal("expected a, got", p.Config.Name)
+	}
+}
@@ -0,0 +1,373 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// posTrainingIterations is the number of passes made over the corpus when
+// training the tagger.
+const posTrainingIterations = 5
+
+// posTagVerb is the tag assigned to verbs by the tagger. Tokens tagged as
+// verbs act as Commands, and all other tags act as Objects.
+const posTagVerb = "VERB"
+
+// errInvalidCorpus is returned when the POS corpus contains a token which isn't
+// of the form word/TAG.
+var errInvalidCorpus = errors.New("invalid POS corpus")
+
+// posTagger is an averaged perceptron part-of-speech tagger. It's used as a
+// contextual second pass after the classifier, resolving words the classifier
+// marked as both a Command and an Object to the part they play in the
+// sentence, e.g. "order" in "order pizza" versus "cancel my order". The tagger
+// trains in-process from a small corpus on boot.
+type posTagger struct {
+	model *perceptron
+
+	// tagdict maps frequent, unambiguous words directly to their tags,
+	// skipping the model entirely.
+	tagdict map[string]string
+}
+
+// taggedToken is a word and its part-of-speech tag in the training corpus.
+type taggedToken struct {
+	Word string
+	Tag  string
+}
+
+// resolve returns the Structured Input Type of each of the ambiguous words as
+// determined by its part of speech within the tokenized sentence.
+func (t *posTagger) resolve(tokens []string, ambiguous []string) map[string]dt.SIT {
+	if len(ambiguous) == 0 {
+		return nil
+	}
+	tags := t.tag(tokens)
+	resolved := map[string]dt.SIT{}
+	for _, a := range ambiguous {
+		for i, tok := range tokens {
+			if strings.ToLower(tok) != a {
+				continue
+			}
+			if tags[i] == posTagVerb {
+				resolved[a] = dt.SITCommand
+			} else {
+				resolved[a] = dt.SITObject
+			}
+			break
+		}
+	}
+	return resolved
+}
+
+// ambiguousWords returns the words the classifier marked as both a Command and
+// an Object.
+func ambiguousWords(si *dt.StructuredInput) []string {
+	var words []string
+	for _, c := range si.Commands {
+		for _, o := range si.Objects {
+			if c == o {
+				words = append(words, c)
+				break
+			}
+		}
+	}
+	return words
+}
+
+// tag returns the part-of-speech tag for each token in a sentence.
+func (t *posTagger) tag(tokens []string) []string {
+	ctx := posContext(tokens)
+	tags := make([]string, len(tokens))
+	prev, prev2 := "-START-", "-START2-"
+	for i, tok := range tokens {
+		w := normalizeWord(tok)
+		tag, ok := t.tagdict[w]
+		if !ok {
+			tag = t.model.predict(posFeatures(i, w, ctx, prev, prev2))
+		}
+		tags[i] = tag
+		prev2, prev = prev, tag
+	}
+	return tags
+}
+
+// train learns weights from the tagged sentences, making iters passes over the
+// corpus. Sentences are shuffled between passes with a fixed seed, so the
+// trained tagger is the same on every boot.
+func (t *posTagger) train(sents [][]taggedToken, iters int) {
+	t.buildTagdict(sents)
+	classes := map[string]struct{}{}
+	for _, s := range sents {
+		for _, tt := range s {
+			classes[tt.Tag] = struct{}{}
+		}
+	}
+	t.model = newPerceptron(classes)
+	rnd := rand.New(rand.NewSource(1))
+	for iter := 0; iter < iters; iter++ {
+		for _, s := range sents {
+			tokens := make([]string, len(s))
+			for i, tt := range s {
+				tokens[i] = tt.Word
+			}
+			ctx := posContext(tokens)
+			prev, prev2 := "-START-", "-START2-"
+			for i, tt := range s {
+				w := normalizeWord(tt.Word)
+				guess, ok := t.tagdict[w]
+				if !ok {
+					feats := posFeatures(i, w, ctx, prev, prev2)
+					guess = t.model.predict(feats)
+					t.model.update(tt.Tag, guess, feats)
+				}
+				prev2, prev = prev, guess
+			}
+		}
+		for i := range sents {
+			j := rnd.Intn(i + 1)
+			sents[i], sents[j] = sents[j], sents[i]
+		}
+	}
+	t.model.average()
+}
+
+// buildTagdict records the words which appear often enough in the corpus, and
+// almost always with the same tag, to be tagged without the model.
+func (t *posTagger) buildTagdict(sents [][]taggedToken) {
+	const freqThreshold = 10
+	const ambiguityThreshold = 0.97
+	counts := map[string]map[string]int{}
+	for _, s := range sents {
+		for _, tt := range s {
+			w := normalizeWord(tt.Word)
+			if counts[w] == nil {
+				counts[w] = map[string]int{}
+			}
+			counts[w][tt.Tag]++
+		}
+	}
+	t.tagdict = map[string]string{}
+	for w, tags := range counts {
+		var tag string
+		var n, total int
+		for tg, c := range tags {
+			total += c
+			if c > n || (c == n && tg < tag) {
+				tag, n = tg, c
+			}
+		}
+		if total >= freqThreshold &&
+			float64(n)/float64(total) >= ambiguityThreshold {
+			t.tagdict[w] = tag
+		}
+	}
+}
+
+// posContext pads the normalized tokens of a sentence, so features may look two
+// words ahead or behind any token.
+func posContext(tokens []string) []string {
+	ctx := make([]string, 0, len(tokens)+4)
+	ctx = append(ctx, "-START-", "-START2-")
+	for _, tok := range tokens {
+		ctx = append(ctx, normalizeWord(tok))
+	}
+	return append(ctx, "-END-", "-END2-")
+}
+
+// posFeatures describes the ith token of a sentence by the word itself, its
+// affixes, its neighbors, and the tags already predicted for the tokens
+// before it.
+func posFeatures(i int, word string, ctx []string, prev, prev2 string) []string {
+	i += 2
+	return []string{
+		"bias",
+		"i suffix " + suffix(word, 3),
+		"i pref1 " + prefix(word, 1),
+		"i-1 tag " + prev,
+		"i-2 tag " + prev2,
+		"i tag+i-2 tag " + prev + " " + prev2,
+		"i word " + ctx[i],
+		"i-1 tag+i word " + prev + " " + ctx[i],
+		"i-1 word " + ctx[i-1],
+		"i-1 suffix " + suffix(ctx[i-1], 3),
+		"i-2 word " + ctx[i-2],
+		"i+1 word " + ctx[i+1],
+		"i+1 suffix " + suffix(ctx[i+1], 3),
+		"i+2 word " + ctx[i+2],
+	}
+}
+
+// normalizeWord lowercases a word and collapses numbers, so the tagger
+// generalizes beyond the exact numbers in its corpus.
+func normalizeWord(w string) string {
+	if len(w) == 0 {
+		return w
+	}
+	if strings.Contains(w[1:], "-") {
+		return "!HYPHEN"
+	}
+	if unicode.IsDigit([]rune(w)[0]) {
+		return "!DIGITS"
+	}
+	return strings.ToLower(w)
+}
+
+func suffix(w string, n int) string {
+	r := []rune(w)
+	if len(r) <= n {
+		return w
+	}
+	return string(r[len(r)-n:])
+}
+
+func prefix(w string, n int) string {
+	r := []rune(w)
+	if len(r) <= n {
+		return w
+	}
+	return string(r[:n])
+}
+
+// perceptron is a multiclass perceptron whose weights are averaged over every
+// update made during training, which makes it far less sensitive to the order
+// of the training data.
+type perceptron struct {
+	classes []string
+	weights map[string]map[string]float64
+
+	// Training state used to average the weights. totals accumulates
+	// each weight over time, and stamps records the update at which each
+	// weight last changed.
+	totals map[string]float64
+	stamps map[string]int
+	i      int
+}
+
+func newPerceptron(classes map[string]struct{}) *perceptron {
+	p := &perceptron{
+		weights: map[string]map[string]float64{},
+		totals:  map[string]float64{},
+		stamps:  map[string]int{},
+	}
+	for c := range classes {
+		p.classes = append(p.classes, c)
+	}
+	// Sort the classes, so ties are broken the same way every time.
+	sort.Strings(p.classes)
+	return p
+}
+
+// predict returns the highest scoring class for the features.
+func (p *perceptron) predict(feats []string) string {
+	scores := map[string]float64{}
+	for _, f := range feats {
+		for c, w := range p.weights[f] {
+			scores[c] += w
+		}
+	}
+	var best string
+	bestScore := math.Inf(-1)
+	for _, c := range p.classes {
+		if scores[c] > bestScore {
+			best, bestScore = c, scores[c]
+		}
+	}
+	return best
+}
+
+// update rewards the features of the true class and penalizes those of the
+// guess when the guess was wrong.
+func (p *perceptron) update(truth, guess string, feats []string) {
+	p.i++
+	if truth == guess {
+		return
+	}
+	for _, f := range feats {
+		p.updateFeature(truth, f, 1)
+		p.updateFeature(guess, f, -1)
+	}
+}
+
+func (p *perceptron) updateFeature(c, f string, v float64) {
+	if p.weights[f] == nil {
+		p.weights[f] = map[string]float64{}
+	}
+	key := f + "\x00" + c
+	w := p.weights[f][c]
+	p.totals[key] += float64(p.i-p.stamps[key]) * w
+	p.stamps[key] = p.i
+	p.weights[f][c] = w + v
+}
+
+// average replaces each weight with its average over all updates and discards
+// the training state.
+func (p *perceptron) average() {
+	for f, ws := range p.weights {
+		for c, w := range ws {
+			key := f + "\x00" + c
+			total := p.totals[key] + float64(p.i-p.stamps[key])*w
+			avg := math.Floor(total/float64(p.i)*1000+0.5) / 1000
+			if avg == 0 {
+				delete(ws, c)
+				continue
+			}
+			ws[c] = avg
+		}
+	}
+	p.totals, p.stamps = nil, nil
+}
+
+// buildTagger trains the part-of-speech tagger from the corpus bundled in
+// data/pos.
+func buildTagger() (*posTagger, error) {
+	var p string
+	if os.Getenv("ABOT_ENV") == "test" {
+		p = filepath.Join(os.Getenv("ABOT_PATH"), "base", "data",
+			"pos")
+	} else {
+		p = filepath.Join("data", "pos")
+	}
+	fi, err := os.Open(filepath.Join(p, "corpus.txt"))
+	if err != nil {
+		return nil, err
+	}
+	var sents [][]taggedToken
+	scanner := bufio.NewScanner(fi)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		var sent []taggedToken
+		for _, f := range strings.Fields(line) {
+			i := strings.LastIndex(f, "/")
+			if i <= 0 || i == len(f)-1 {
+				_ = fi.Close()
+				return nil, errInvalidCorpus
+			}
+			sent = append(sent, taggedToken{Word: f[:i], Tag: f[i+1:]})
+		}
+		sents = append(sents, sent)
+	}
+	if err = scanner.Err(); err != nil {
+		_ = fi.Close()
+		return nil, err
+	}
+	if err = fi.Close(); err != nil {
+		return nil, err
+	}
+	t := &posTagger{}
+	t.train(sents, posTrainingIterations)
+	return t, nil
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestPOSTaggerResolve(t *testing.T) {
+	ner, err := buildClassifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg, err := buildTagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Test string
+		Exp  map[string]dt.SIT
+	}{
+		{"order pizza", map[string]dt.SIT{"order": dt.SITCommand}},
+		{"cancel my order", map[string]dt.SIT{"order": dt.SITObject}},
+		{"find the post office", map[string]dt.SIT{
+			"find": dt.SITCommand,
+			"post": dt.SITObject,
+		}},
+		{"book a table for two", map[string]dt.SIT{"book": dt.SITCommand}},
+		{"I finished the book", map[string]dt.SIT{"book": dt.SITObject}},
+	}
+	for _, test := range tests {
+		tokens := TokenizeSentence(test.Test)
+		si := ner.classifyTokens(tokens)
+		resolved := tg.resolve(tokens, ambiguousWords(si))
+		for w, exp := range test.Exp {
+			if resolved[w] != exp {
+				t.Errorf("expected %q to resolve to %d, got %d in %q",
+					w, exp, resolved[w], test.Test)
+			}
+		}
+	}
+}
+
+func TestRouteResolved(t *testing.T) {
+	ner, err := buildClassifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg, err := buildTagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stems := StemTokens([]string{"book", "table"})
+	route := "CO_" + stems[0] + "_" + stems[1]
+	RegPlugins.Set(route, &dt.Plugin{
+		Config: dt.PluginConfig{Name: "tables"},
+	})
+	tests := map[string]bool{
+		"book a table for two":      true,
+		"put the book on the table": false,
+	}
+	for sent, exp := range tests {
+		tokens := TokenizeSentence(sent)
+		si := ner.classifyTokens(tokens)
+		si.Resolved = tg.resolve(tokens, ambiguousWords(si))
+		m := &dt.Msg{Language: dt.LangEnglish, StructuredInput: si}
+		var routed bool
+		for _, c := range scorePlugins(m, "") {
+			routed = routed || c.Route == route
+		}
+		if routed != exp {
+			t.Errorf("%q: expected routed %t, got %t", sent, exp,
+				routed)
+		}
+	}
+}
+
+func TestPOSTaggerDeterministic(t *testing.T) {
+	tg1, err := buildTagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg2, err := buildTagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := TokenizeSentence("schedule a call with jim and check my order")
+	tags1, tags2 := tg1.tag(tokens), tg2.tag(tokens)
+	for i := range tags1 {
+		if tags1[i] != tags2[i] {
+			t.Fatalf("expected identical tags, got %v and %v", tags1,
+				tags2)
+		}
+	}
+}
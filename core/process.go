@@ -1,12 +1,15 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"runtime/debug"
 
 	log "github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/jmoiron/sqlx"
 )
 
 // errMissingPlugin denotes that Abot could find neither a plugin with
@@ -15,36 +18,137 @@ import (
 // doesn't initially trigger a plugin.
 var errMissingPlugin = errors.New("missing plugin")
 
+// fallbackReply is sent to the user when Abot fails to process their message.
+const fallbackReply = "Something went wrong with my wiring... I'll get that fixed up soon."
+
 // preprocess converts a user input into a Msg that's been persisted to the
-// database
-func preprocess(r *http.Request) (*dt.Msg, error) {
+// database within the transaction of the user's turn.
+func preprocess(tx *sqlx.Tx, r *http.Request) (*dt.Msg, error) {
 	req := &dt.Request{}
 	err := json.NewDecoder(r.Body).Decode(req)
 	if err != nil {
 		log.Info("could not parse empty body", err)
 		return nil, err
 	}
-	sendPostReceiveEvent(&req.CMD)
+	ctx := r.Context()
+	sendPostReceiveEvent(ctx, &req.CMD)
 	u, err := dt.GetUser(db, req)
 	if err != nil {
 		return nil, err
 	}
-	sendPreProcessingEvent(&req.CMD, u)
+	// Wait for any other turn of this user to complete, so state
+	// transitions happen in the order the user's messages arrived.
+	if err = lockUser(tx, u); err != nil {
+		return nil, err
+	}
+	sendPreProcessingEvent(ctx, &req.CMD, u)
 	// TODO trigger training if needed (see buildInput)
-	return NewMsg(u, req.CMD)
+	in, err := buildMsg(tx, u, req.CMD)
+	if err != nil {
+		return nil, err
+	}
+	in.SetTx(tx)
+	in = in.WithContext(ctx)
+	if err = loadSession(tx, in); err != nil {
+		return nil, err
+	}
+	return in, nil
 }
 
 // ProcessText is Abot's core logic. This function processes a user's message,
 // routes it to the correct plugin, and handles edge cases like offensive
-// language before returning a response to the user. Any user-presentable error
-// is returned in the string. Errors returned from this function are not for the
-// user, so they are handled by Abot explicitly on this function's return
-// (logging, notifying admins, etc.).
-func ProcessText(r *http.Request) (ret string, err error) {
+// language (see Responders) before returning a response to the user. Any
+// user-presentable error is returned in the response's Sentence. Errors
+// returned from this function are not for the user, so they are handled by
+// Abot explicitly on this function's return (logging, notifying admins, etc.).
+// They're recorded in Abot's error registry, and plugins listening for errors
+// are notified through their OnError events.
+//
+// Each turn runs in a single database transaction, so the user's message,
+// Abot's response, and any context, memories or state saved along the way are
+// committed together. If the turn fails, such as when a plugin or Responder
+// panics, the transaction is rolled back and the user receives a fallback reply. Turns of
+// the same user are serialized (see lockUser).
+func ProcessText(r *http.Request) (ret *dt.Response, err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		reportError(nil, err)
+		return nil, err
+	}
+	in, ret, err := processTurn(tx, r)
+	if in != nil {
+		// The transaction ends with the turn, so plugins still
+		// running, e.g. in a goroutine, use the database directly.
+		in.EndTx()
+	}
+	if err != nil {
+		if errR := tx.Rollback(); errR != nil {
+			log.Info("failed to roll back turn", errR)
+		}
+		if in != nil {
+			ret, err = recordFailedTurn(in, err)
+		}
+		reportError(in, err)
+		return ret, err
+	}
+	if err = tx.Commit(); err != nil {
+		reportError(in, err)
+		return nil, err
+	}
+	if in.User.FlexIDType == dt.FIDTSession && len(in.User.FlexID) > 0 {
+		ret.SessionToken = sessionToken(in.User.FlexID)
+	}
+	return ret, nil
+}
+
+// recordFailedTurn logs the error which failed a user's turn. The user's
+// message and a fallback reply are saved outside of the rolled back
+// transaction, so the conversation's history remains complete.
+func recordFailedTurn(in *dt.Msg, turnErr error) (*dt.Response, error) {
+	if e, ok := turnErr.(*dt.PluginPanicError); ok {
+		log.Infof("%s\n%s", e.Error(), e.Stack)
+	}
+	sentence := fallbackReply
+	if turnErr == errPluginTimeout {
+		sentence = timeoutReply
+	}
+	in.ID = 0
+	if err := in.Save(db); err != nil {
+		log.Info("failed to save message of failed turn", err)
+	}
+	resp := &dt.Msg{
+		User:      in.User,
+		Sentence:  sentence,
+		Plugin:    in.Plugin,
+		AbotSent:  true,
+		SessionID: in.SessionID,
+	}
+	if err := resp.Save(db); err != nil {
+		log.Info("failed to save reply to failed turn", err)
+	}
+	return &dt.Response{Sentence: sentence}, turnErr
+}
+
+// processTurn processes a user's message within the transaction of their turn.
+// The message is returned whenever it was built, even on error, so a failed
+// turn can be recorded.
+func processTurn(tx *sqlx.Tx, r *http.Request) (in *dt.Msg,
+	ret *dt.Response, err error) {
+
+	// A panic anywhere in the turn, e.g. in a Responder, a plugin's
+	// Decline or one of its events, fails the turn like a plugin's panic,
+	// so the transaction is rolled back and the user's lock is released.
+	defer func() {
+		if r := recover(); r != nil {
+			ret = nil
+			err = &dt.PluginPanicError{Val: r, Stack: debug.Stack()}
+		}
+	}()
+
 	// Process message
-	in, err := preprocess(r)
+	in, err = preprocess(tx, r)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	log.Debug("processed input into message...")
 	log.Debug("commands:", in.StructuredInput.Commands)
@@ -52,42 +156,83 @@ func ProcessText(r *http.Request) (ret string, err error) {
 	log.Debug(" intents:", in.StructuredInput.Intents)
 	log.Debug("  people:", in.StructuredInput.People)
 	log.Debug("   times:", in.StructuredInput.Times)
-	plugin, route, directRoute, followup, pluginErr := GetPlugin(db, in)
-	if pluginErr != nil && pluginErr != errMissingPlugin {
-		return "", pluginErr
+
+	// Run the pre-plugin pipeline before routing the message, so its
+	// stages can change both where the message is routed and what's saved.
+	ret = &dt.Response{}
+	resp := &dt.Msg{}
+	resp.AbotSent = true
+	resp.User = in.User
+	resp.SessionID = in.SessionID
+	sentence := in.Sentence
+	resp.Sentence = Responders.run(in)
+	if in.Sentence != sentence {
+		// A Responder changed the message, e.g. scrubbing personal
+		// information, so it's understood again as changed.
+		if err = understandMsg(tx, in); err != nil {
+			return in, nil, err
+		}
+	}
+	if err = saveContext(tx, in); err != nil {
+		return in, nil, err
+	}
+	var plugin *dt.Plugin
+	var route string
+	var directRoute, followup, smAnswered, isAmbiguous bool
+	var ambiguous *errAmbiguousPlugin
+	var pluginErr error
+	if len(resp.Sentence) == 0 {
+		plugin, route, directRoute, followup, pluginErr = GetPlugin(tx,
+			in)
+		ambiguous, isAmbiguous = pluginErr.(*errAmbiguousPlugin)
+		if pluginErr != nil && pluginErr != errMissingPlugin &&
+			!isAmbiguous && pluginErr != errCanceled {
+			return in, nil, pluginErr
+		}
 	}
 	in.Route = route
 	in.Plugin = plugin
-	if err = in.Save(db); err != nil {
-		return "", err
+	if err = in.Save(tx); err != nil {
+		return in, nil, err
 	}
 	sendPostProcessingEvent(in)
 
 	// Determine appropriate response
-	var smAnswered bool
-	resp := &dt.Msg{}
-	resp.AbotSent = true
-	resp.User = in.User
-	resp.Sentence = RespondWithOffense(in)
 	if len(resp.Sentence) > 0 {
 		goto saveAndReturn
 	}
-	resp.Sentence = RespondWithHelp(in)
-	if len(resp.Sentence) > 0 {
+	if isAmbiguous {
+		// Ask the user which plugin was meant, and route the answer
+		// on the user's next message.
+		resp.Sentence = ambiguous.question()
+		if err = saveDisambiguation(tx, in, ambiguous); err != nil {
+			return in, nil, err
+		}
 		goto saveAndReturn
 	}
-	resp.Sentence = RespondWithNicety(in)
-	if len(resp.Sentence) > 0 {
+	if pluginErr == errCanceled {
+		resp.Sentence = cancelReply
 		goto saveAndReturn
 	}
 	if pluginErr != errMissingPlugin {
-		resp.Sentence, smAnswered = dt.CallPlugin(plugin, in, followup)
+		resp.Sentence, smAnswered, err = callPlugin(plugin, in,
+			followup)
+		if err != nil {
+			return in, nil, err
+		}
+		if len(resp.Sentence) > 0 {
+			// Keep any rich content the plugin added to its response
+			ret = in.Response
+			if err = saveSelectionContext(tx, in); err != nil {
+				return in, nil, err
+			}
+		}
 	}
 	if len(resp.Sentence) == 0 {
 		resp.Sentence = RespondWithHelpConfused(in)
 		in.NeedsTraining = true
-		if err = in.Update(db); err != nil {
-			return "", err
+		if err = in.Update(tx); err != nil {
+			return in, nil, err
 		}
 	} else {
 		state := plugin.GetMemory(in, dt.StateKey).Int64()
@@ -95,8 +240,9 @@ func ProcessText(r *http.Request) (ret string, err error) {
 			in.NeedsTraining = true
 			if !smAnswered {
 				resp.Sentence = RespondWithHelpConfused(in)
-				if err = in.Update(db); err != nil {
-					return "", err
+				ret = &dt.Response{}
+				if err = in.Update(tx); err != nil {
+					return in, nil, err
 				}
 			}
 		}
@@ -106,32 +252,57 @@ func ProcessText(r *http.Request) (ret string, err error) {
 	}
 saveAndReturn:
 	sendPreResponseEvent(in, &resp.Sentence)
-	if err = resp.Save(db); err != nil {
-		return "", err
+	if err = resp.Save(tx); err != nil {
+		return in, nil, err
 	}
-	return resp.Sentence, nil
+	ret.Sentence = resp.Sentence
+	return in, ret, nil
 }
 
-func sendPostReceiveEvent(cmd *string) {
+func sendPostReceiveEvent(ctx context.Context, cmd *string) {
 	for _, p := range AllPlugins {
+		if p.Events.PostReceiveCtx != nil {
+			pctx, cancel := withPluginDeadline(ctx, p)
+			p.Events.PostReceiveCtx(pctx, cmd)
+			cancel()
+			continue
+		}
 		p.Events.PostReceive(cmd)
 	}
 }
 
-func sendPreProcessingEvent(cmd *string, u *dt.User) {
+func sendPreProcessingEvent(ctx context.Context, cmd *string, u *dt.User) {
 	for _, p := range AllPlugins {
+		if p.Events.PreProcessingCtx != nil {
+			pctx, cancel := withPluginDeadline(ctx, p)
+			p.Events.PreProcessingCtx(pctx, cmd, u)
+			cancel()
+			continue
+		}
 		p.Events.PreProcessing(cmd, u)
 	}
 }
 
 func sendPostProcessingEvent(in *dt.Msg) {
 	for _, p := range AllPlugins {
+		if p.Events.PostProcessingCtx != nil {
+			pctx, cancel := withPluginDeadline(in.Context(), p)
+			p.Events.PostProcessingCtx(pctx, in)
+			cancel()
+			continue
+		}
 		p.Events.PostProcessing(in)
 	}
 }
 
 func sendPreResponseEvent(in *dt.Msg, resp *string) {
 	for _, p := range AllPlugins {
+		if p.Events.PreResponseCtx != nil {
+			pctx, cancel := withPluginDeadline(in.Context(), p)
+			p.Events.PreResponseCtx(pctx, in, resp)
+			cancel()
+			continue
+		}
 		p.Events.PreResponse(in, resp)
 	}
 }
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// Responder is a stage in Abot's pre-plugin pipeline. Each Responder receives
+// the user's processed message before it's routed to a plugin or saved. A
+// Responder may inspect or modify the message (e.g. scrubbing personal
+// information). A changed sentence is analyzed again before the message's
+// context and entities are recorded, so nothing is kept from the original
+// sentence. A Responder may also short-circuit the pipeline by returning a
+// non-empty response, which is sent to the user without any plugin being
+// called.
+// Returning "" passes the message through to the next Responder.
+type Responder func(in *dt.Msg) (response string)
+
+// Names of Abot's built-in Responders. Use these to reorder or remove the
+// built-in stages of the pipeline.
+const (
+	ResponderOffense = "offense"
+	ResponderHelp    = "help"
+	ResponderNicety  = "nicety"
+)
+
+// ErrMissingResponder is returned when a Responder is expected in the
+// pipeline, but none is registered with the given name.
+var ErrMissingResponder = errors.New("missing responder")
+
+// ErrDuplicateResponder is returned when adding a Responder whose name is
+// already registered in the pipeline.
+var ErrDuplicateResponder = errors.New("duplicate responder")
+
+// Responders is the ordered pipeline run by ProcessText before routing a
+// message to a plugin. By default it responds to offensive language, requests
+// for help, and niceties, in that order. Custom stages can be added, and the
+// built-in stages can be reordered or removed, without modifying Abot's core.
+var Responders = ResponderPipeline{
+	names: []string{ResponderOffense, ResponderHelp, ResponderNicety},
+	fns: map[string]Responder{
+		ResponderOffense: RespondWithOffense,
+		ResponderHelp:    RespondWithHelp,
+		ResponderNicety:  RespondWithNicety,
+	},
+	mutex: &sync.Mutex{},
+}
+
+// ResponderPipeline is a thread-safe, ordered set of named Responders.
+type ResponderPipeline struct {
+	names []string
+	fns   map[string]Responder
+	mutex *sync.Mutex
+}
+
+// Add appends a Responder to the end of the pipeline.
+func (rp *ResponderPipeline) Add(name string, fn Responder) error {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	if _, exists := rp.fns[name]; exists {
+		return ErrDuplicateResponder
+	}
+	rp.names = append(rp.names, name)
+	rp.fns[name] = fn
+	return nil
+}
+
+// AddBefore inserts a Responder into the pipeline immediately before the
+// Responder named by before. For example, a stage which scrubs personal
+// information should run before every other stage:
+//
+//	core.Responders.AddBefore(core.ResponderOffense, "pii", scrubPII)
+func (rp *ResponderPipeline) AddBefore(before, name string, fn Responder) error {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	if _, exists := rp.fns[name]; exists {
+		return ErrDuplicateResponder
+	}
+	idx := rp.index(before)
+	if idx < 0 {
+		return ErrMissingResponder
+	}
+	rp.names = append(rp.names, "")
+	copy(rp.names[idx+1:], rp.names[idx:])
+	rp.names[idx] = name
+	rp.fns[name] = fn
+	return nil
+}
+
+// Remove deletes a Responder from the pipeline. It is not an error to remove a
+// Responder that does not exist.
+func (rp *ResponderPipeline) Remove(name string) {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	idx := rp.index(name)
+	if idx < 0 {
+		return
+	}
+	rp.names = append(rp.names[:idx], rp.names[idx+1:]...)
+	delete(rp.fns, name)
+}
+
+// SetOrder reorders the pipeline to run the named Responders in the given
+// order. Every registered Responder must be named exactly once.
+func (rp *ResponderPipeline) SetOrder(names ...string) error {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	if len(names) != len(rp.names) {
+		return errors.New("responder order must include every responder")
+	}
+	seen := map[string]struct{}{}
+	for _, name := range names {
+		if _, exists := rp.fns[name]; !exists {
+			return ErrMissingResponder
+		}
+		if _, exists := seen[name]; exists {
+			return ErrDuplicateResponder
+		}
+		seen[name] = struct{}{}
+	}
+	rp.names = append([]string{}, names...)
+	return nil
+}
+
+// Names returns the names of the Responders in the order they're run.
+func (rp *ResponderPipeline) Names() []string {
+	rp.mutex.Lock()
+	names := append([]string{}, rp.names...)
+	rp.mutex.Unlock()
+	return names
+}
+
+// run passes the message through each Responder in order, returning the first
+// non-empty response. If every Responder passes, run returns "".
+func (rp *ResponderPipeline) run(in *dt.Msg) string {
+	// Copy the pipeline before running it, so Responders which take a
+	// long time don't block changes to the pipeline, and so a Responder
+	// may safely modify the pipeline itself.
+	rp.mutex.Lock()
+	fns := make([]Responder, 0, len(rp.names))
+	for _, name := range rp.names {
+		fns = append(fns, rp.fns[name])
+	}
+	rp.mutex.Unlock()
+	for _, fn := range fns {
+		if resp := fn(in); len(resp) > 0 {
+			return resp
+		}
+	}
+	return ""
+}
+
+// index returns the position of a Responder in the pipeline or -1 if it
+// doesn't exist. The caller must hold the lock.
+func (rp *ResponderPipeline) index(name string) int {
+	for i, n := range rp.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestResponderPipeline(t *testing.T) {
+	rp := ResponderPipeline{fns: map[string]Responder{}, mutex: &sync.Mutex{}}
+	var called []string
+	pass := func(name string) Responder {
+		return func(in *dt.Msg) string {
+			called = append(called, name)
+			return ""
+		}
+	}
+	if err := rp.Add("a", pass("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Add("c", func(in *dt.Msg) string {
+		called = append(called, "c")
+		in.Sentence = "modified"
+		return "stop"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.AddBefore("c", "b", pass("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Add("d", pass("d")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Add("a", pass("a")); err != ErrDuplicateResponder {
+		t.Fatal("expected ErrDuplicateResponder, got", err)
+	}
+	in := &dt.Msg{Sentence: "hi"}
+	if resp := rp.run(in); resp != "stop" {
+		t.Fatalf("expected %q, got %q", "stop", resp)
+	}
+	if in.Sentence != "modified" {
+		t.Fatalf("expected responder to modify msg, got %q", in.Sentence)
+	}
+	exp := []string{"a", "b", "c"}
+	if len(called) != len(exp) {
+		t.Fatalf("expected %q, got %q", exp, called)
+	}
+	for i := range exp {
+		if called[i] != exp[i] {
+			t.Fatalf("expected %q, got %q", exp, called)
+		}
+	}
+
+	// Reorder and remove stages.
+	if err := rp.SetOrder("d", "a"); err == nil {
+		t.Fatal("expected error when order is missing responders")
+	}
+	rp.Remove("c")
+	if err := rp.SetOrder("d", "b", "a"); err != nil {
+		t.Fatal(err)
+	}
+	called = nil
+	if resp := rp.run(in); resp != "" {
+		t.Fatalf("expected no response, got %q", resp)
+	}
+	exp = []string{"d", "b", "a"}
+	for i := range exp {
+		if called[i] != exp[i] {
+			t.Fatalf("expected %q, got %q", exp, called)
+		}
+	}
+}
@@ -17,33 +17,48 @@ func sendEvents(evtChan chan *dt.ScheduledEvent, interval time.Duration) {
 	}
 }
 
-func sendEventsTick(evtChan chan *dt.ScheduledEvent, t time.Time) {
-	// Listen for events that need to be sent.
-	go func(chan *dt.ScheduledEvent) {
-		q := `UPDATE scheduledevents SET sent=TRUE WHERE id=$1`
-		select {
-		case evt := <-evtChan:
-			log.Debug("received event")
-			if smsConn == nil {
-				log.Info("failed to send scheduled event (missing SMS driver). will retry.")
-				return
-			}
-			// Send event. On error, event will be retried next
-			// minute.
-			if err := evt.Send(smsConn); err != nil {
-				log.Info("failed to send scheduled event", err)
-				return
-			}
-			// Update event as sent
-			if _, err := db.Exec(q, evt.ID); err != nil {
-				log.Info("failed to update scheduled event as sent",
-					err)
-				return
-			}
+// receiveEvents sends each event queued on evtChan until the channel is
+// closed. A single listener drains every event queued by sendEventsTick, so
+// queueing several events due at once never blocks.
+func receiveEvents(evtChan chan *dt.ScheduledEvent) {
+	for evt := range evtChan {
+		log.Debug("received event")
+		sendEvent(evt)
+	}
+}
+
+// sendEvent sends a scheduled event and then marks it as sent, or schedules
+// it again if it recurs. On error, the event will be retried next minute.
+func sendEvent(evt *dt.ScheduledEvent) {
+	if smsConn == nil {
+		log.Info("failed to send scheduled event (missing SMS driver). will retry.")
+		return
+	}
+	if err := evt.Send(smsConn); err != nil {
+		log.Info("failed to send scheduled event", err)
+		return
+	}
+	// Schedule a recurring event to be sent again.
+	if next, ok := evt.Next(time.Now()); ok {
+		q := `UPDATE scheduledevents
+		      SET sendat=$1, updatedat=CURRENT_TIMESTAMP
+		      WHERE id=$2`
+		if _, err := db.Exec(q, next, evt.ID); err != nil {
+			log.Info("failed to reschedule recurring event", err)
 		}
-	}(evtChan)
+		return
+	}
+	// Update event as sent
+	q := `UPDATE scheduledevents SET sent=TRUE WHERE id=$1`
+	if _, err := db.Exec(q, evt.ID); err != nil {
+		log.Info("failed to update scheduled event as sent", err)
+	}
+}
 
-	q := `SELECT id, content, flexid, flexidtype
+// sendEventsTick queues every unsent event that's due for receiveEvents to
+// send.
+func sendEventsTick(evtChan chan *dt.ScheduledEvent, t time.Time) {
+	q := `SELECT id, content, flexid, flexidtype, sendat, recurrence
 		      FROM scheduledevents
 		      WHERE sent=false AND sendat<=$1`
 	evts := []*dt.ScheduledEvent{}
@@ -52,6 +67,12 @@ func sendEventsTick(evtChan chan *dt.ScheduledEvent, t time.Time) {
 		return
 	}
 	for _, evt := range evts {
+		// sendat is stored without a time zone, so it's read back as
+		// UTC. Restore the server's local time so recurring events
+		// keep their time of day.
+		s := evt.SendAt
+		evt.SendAt = time.Date(s.Year(), s.Month(), s.Day(), s.Hour(),
+			s.Minute(), s.Second(), s.Nanosecond(), time.Local)
 		// Queue the event for sending
 		evtChan <- evt
 	}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestReceiveEvents(t *testing.T) {
+	evtChan := make(chan *dt.ScheduledEvent)
+	done := make(chan struct{})
+	go func() {
+		receiveEvents(evtChan)
+		close(done)
+	}()
+	// Queue more events than a single receive would take, as when
+	// several are due in the same minute.
+	for i := 0; i < 3; i++ {
+		select {
+		case evtChan <- &dt.ScheduledEvent{ID: uint64(i + 1)}:
+		case <-time.After(time.Second):
+			t.Fatalf("blocked queueing event %d", i+1)
+		}
+	}
+	close(evtChan)
+	<-done
+}
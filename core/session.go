@@ -0,0 +1,194 @@
+package core
+
+import (
+	"database/sql"
+	"os"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/jmoiron/sqlx"
+)
+
+// defaultSessionTimeout is the time a user may be idle before their
+// conversation session ends, unless ABOT_SESSION_TIMEOUT is set.
+const defaultSessionTimeout = 30 * time.Minute
+
+// session is a conversation between a user and Abot. A session ends once the
+// user has been idle for longer than the session timeout.
+type session struct {
+	ID            uint64
+	UserID        uint64
+	FlexID        string
+	FlexIDType    dt.FlexIDType
+	LastMessageAt time.Time
+}
+
+// sessionTimeout returns the time a user may be idle before their session
+// ends. It's configured through the ABOT_SESSION_TIMEOUT env var, e.g. "1h".
+func sessionTimeout() time.Duration {
+	t := os.Getenv("ABOT_SESSION_TIMEOUT")
+	if len(t) == 0 {
+		return defaultSessionTimeout
+	}
+	d, err := time.ParseDuration(t)
+	if err != nil || d <= 0 {
+		log.Info("invalid ABOT_SESSION_TIMEOUT", t)
+		return defaultSessionTimeout
+	}
+	return d
+}
+
+// msg returns a Msg identifying the session's user, which is passed to plugins
+// when the session ends.
+func (s *session) msg() *dt.Msg {
+	return &dt.Msg{
+		User: &dt.User{
+			ID:         s.UserID,
+			FlexID:     s.FlexID,
+			FlexIDType: s.FlexIDType,
+		},
+		SessionID: s.ID,
+	}
+}
+
+// loadSession assigns the message to the user's open session, starting a new
+// session if the user has none or if it expired. An expired session is ended
+// first, so the user doesn't resume a stale conversation. The caller must hold
+// the user's lock (see lockUser).
+func loadSession(tx *sqlx.Tx, in *dt.Msg) error {
+	u := in.User
+	s := &session{}
+	var err error
+	if u.ID > 0 {
+		q := `SELECT id, userid, flexid, flexidtype, lastmessageat
+		      FROM usersessions
+		      WHERE userid=$1 AND endedat IS NULL
+		      ORDER BY id DESC
+		      LIMIT 1`
+		err = tx.Get(s, q, u.ID)
+	} else {
+		q := `SELECT id, userid, flexid, flexidtype, lastmessageat
+		      FROM usersessions
+		      WHERE userid=0 AND flexid=$1 AND flexidtype=$2
+			AND endedat IS NULL
+		      ORDER BY id DESC
+		      LIMIT 1`
+		err = tx.Get(s, q, u.FlexID, u.FlexIDType)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	now := time.Now()
+	if err == nil {
+		if now.Sub(s.LastMessageAt) < sessionTimeout() {
+			q := `UPDATE usersessions SET lastmessageat=$1 WHERE id=$2`
+			if _, err = tx.Exec(q, now, s.ID); err != nil {
+				return err
+			}
+			in.SessionID = s.ID
+			return nil
+		}
+		if err = endSession(tx, s); err != nil {
+			return err
+		}
+	}
+	q := `INSERT INTO usersessions
+	      (userid, flexid, flexidtype, lastmessageat)
+	      VALUES ($1, $2, $3, $4) RETURNING id`
+	row := tx.QueryRowx(q, u.ID, u.FlexID, u.FlexIDType, now)
+	if err = row.Scan(&in.SessionID); err != nil {
+		return err
+	}
+	log.Debug("started session", in.SessionID)
+	for _, p := range AllPlugins {
+		p.Events.SessionStart(in)
+	}
+	return nil
+}
+
+// endSession marks a session as ended and resets the state machines of any
+// plugins the user was conversing with, so the user's next message starts
+// fresh. Plugins are then notified through their SessionEnd events.
+func endSession(tx *sqlx.Tx, s *session) error {
+	q := `UPDATE usersessions SET endedat=$1
+	      WHERE id=$2 AND endedat IS NULL`
+	res, err := tx.Exec(q, time.Now(), s.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// Already ended elsewhere, e.g. by another Abot instance.
+		return err
+	}
+	log.Debug("ended session", s.ID)
+	in := s.msg()
+	in.SetTx(tx)
+	defer in.EndTx()
+	for _, p := range AllPlugins {
+		if p.SM != nil && p.HasMemory(in, dt.StateKey) {
+			p.SM.Reset(in)
+		}
+	}
+	if err = deleteState(tx, in, keyDisambiguation); err != nil {
+		return err
+	}
+	for _, p := range AllPlugins {
+		p.Events.SessionEnd(in)
+	}
+	return nil
+}
+
+// expireSessions ends sessions which have been idle for longer than the
+// session timeout every interval, so plugins are notified even if the user
+// never returns.
+func expireSessions(interval time.Duration) {
+	t := time.NewTicker(interval)
+	for now := range t.C {
+		expireSessionsTick(now)
+	}
+}
+
+func expireSessionsTick(now time.Time) {
+	q := `SELECT id, userid, flexid, flexidtype, lastmessageat
+	      FROM usersessions
+	      WHERE endedat IS NULL AND lastmessageat<$1`
+	var idle []*session
+	if err := db.Select(&idle, q, now.Add(-sessionTimeout())); err != nil {
+		log.Info("failed to get expired sessions", err)
+		return
+	}
+	for _, s := range idle {
+		if err := expireSession(s); err != nil {
+			log.Info("failed to expire session", s.ID, err)
+		}
+	}
+}
+
+// expireSession ends a single idle session within its own transaction, holding
+// the user's lock so it can't race with a new message from the user.
+func expireSession(s *session) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	u := &dt.User{ID: s.UserID, FlexID: s.FlexID, FlexIDType: s.FlexIDType}
+	if err = lockUser(tx, u); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	// The user may have sent a message while waiting for the lock.
+	q := `SELECT lastmessageat FROM usersessions WHERE id=$1`
+	if err = tx.Get(&s.LastMessageAt, q, s.ID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if time.Since(s.LastMessageAt) < sessionTimeout() {
+		return tx.Rollback()
+	}
+	if err = endSession(tx, s); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestSessionTimeout(t *testing.T) {
+	old := os.Getenv("ABOT_SESSION_TIMEOUT")
+	defer os.Setenv("ABOT_SESSION_TIMEOUT", old)
+	tests := map[string]time.Duration{
+		"":      defaultSessionTimeout,
+		"1h":    time.Hour,
+		"never": defaultSessionTimeout,
+		"-5m":   defaultSessionTimeout,
+	}
+	for env, exp := range tests {
+		os.Setenv("ABOT_SESSION_TIMEOUT", env)
+		if d := sessionTimeout(); d != exp {
+			t.Errorf("%q: expected %s, got %s", env, exp, d)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	p, starts, ends := newSessionPlugin()
+	defer func(plugins []*dt.Plugin) { AllPlugins = plugins }(AllPlugins)
+	AllPlugins = append(AllPlugins, p)
+	in := startTestSession(t, user)
+	first := in.SessionID
+	if *starts != 1 {
+		t.Fatal("expected SessionStart once, got", *starts)
+	}
+	p.SetMemory(in, dt.StateKey, 2)
+	m := &dt.Msg{
+		User:      user,
+		Sentence:  "book a table",
+		Plugin:    p,
+		Route:     "I_book",
+		SessionID: first,
+	}
+	if err := m.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	if name, _, err := in.GetLastPlugin(db); err != nil ||
+		name != p.Config.Name {
+		t.Fatal("expected last plugin", p.Config.Name, "got", name, err)
+	}
+
+	// The user returns after their session expired.
+	idleSession(t, first)
+	in = startTestSession(t, user)
+	if in.SessionID == first {
+		t.Fatal("expected a new session")
+	}
+	if *ends != 1 || *starts != 2 {
+		t.Fatalf("expected 1 SessionEnd and 2 SessionStarts, got %d and %d",
+			*ends, *starts)
+	}
+	if state := p.GetMemory(in, dt.StateKey).Int64(); state != 0 {
+		t.Fatal("expected the plugin's state to be reset, got", state)
+	}
+	if name, _, err := in.GetLastPlugin(db); err != nil || name != "" {
+		t.Fatal("expected no last plugin in the new session, got", name,
+			err)
+	}
+}
+
+func TestExpireSessions(t *testing.T) {
+	reset(t)
+	user, _, _ := seedDBUser(t)
+	p, _, ends := newSessionPlugin()
+	defer func(plugins []*dt.Plugin) { AllPlugins = plugins }(AllPlugins)
+	AllPlugins = append(AllPlugins, p)
+	in := startTestSession(t, user)
+
+	// Sessions which aren't idle are left open.
+	expireSessionsTick(time.Now())
+	if *ends != 0 {
+		t.Fatal("expected an open session, got SessionEnd", *ends)
+	}
+	idleSession(t, in.SessionID)
+	expireSessionsTick(time.Now())
+	if *ends != 1 {
+		t.Fatal("expected SessionEnd once, got", *ends)
+	}
+	var ended bool
+	q := `SELECT endedat IS NOT NULL FROM usersessions WHERE id=$1`
+	if err := db.Get(&ended, q, in.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	if !ended {
+		t.Fatal("expected the session to be ended")
+	}
+}
+
+// newSessionPlugin returns a plugin with a state machine, counting the times
+// its SessionStart and SessionEnd events fire.
+func newSessionPlugin() (p *dt.Plugin, starts, ends *int) {
+	starts, ends = new(int), new(int)
+	p = &dt.Plugin{
+		Config: dt.PluginConfig{Name: "sessions"},
+		DB:     db,
+		Log:    log.New("sessions"),
+		Events: &dt.PluginEvents{
+			SessionStart: func(*dt.Msg) { *starts++ },
+			SessionEnd:   func(*dt.Msg) { *ends++ },
+		},
+	}
+	p.SM = dt.NewStateMachine(p)
+	return p, starts, ends
+}
+
+// startTestSession loads the user's session as their next message would.
+func startTestSession(t *testing.T, u *dt.User) *dt.Msg {
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &dt.Msg{User: u}
+	if err = loadSession(tx, in); err != nil {
+		_ = tx.Rollback()
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+// idleSession backdates a session's last message beyond the session timeout.
+func idleSession(t *testing.T, id uint64) {
+	q := `UPDATE usersessions SET lastmessageat=$1 WHERE id=$2`
+	_, err := db.Exec(q, time.Now().Add(-2*sessionTimeout()), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
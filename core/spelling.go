@@ -0,0 +1,206 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/snowball"
+)
+
+// minCorrectionLen is the shortest word Abot will try to correct. Shorter words
+// are within a single edit of too many others to guess what was meant.
+const minCorrectionLen = 4
+
+// commonWords are frequent words missing from the NER dictionaries, which
+// would otherwise be mistaken for misspellings of similar words, e.g. "with"
+// for "wish".
+var commonWords = wordSet("with", "from", "your", "yours", "their",
+	"theirs", "into", "onto", "which", "they", "them", "been", "were",
+	"than", "then", "those", "these", "also", "some", "each", "both",
+	"such", "only", "same", "many", "much", "whom", "whose", "ours",
+	"hers", "itself", "myself", "yourself", "shall", "might", "must",
+	"does", "doesn", "didn", "aren", "wasn", "weren", "haven", "hasn",
+	"wouldn", "couldn", "shouldn", "para", "pero", "como", "este", "esta",
+	"esto", "estos", "estas", "sobre", "entre", "desde", "hasta", "cuando",
+	"donde", "porque", "también", "todo", "toda", "todos", "nada", "algo",
+	"usted", "ustedes", "nosotros", "ellos", "ellas", "ella", "cual",
+	"quien", "nevermind", "olvídalo", "olvidalo", "déjalo", "dejalo",
+	"cancélalo")
+
+// spellingIndex holds the words to which misspelled tokens may be corrected:
+// the Commands and Objects of each language's NER dictionary, and the stems
+// of registered plugin triggers.
+type spellingIndex struct {
+	dictionaries map[string]*bkTree
+	triggers     *bkTree
+	mutex        *sync.RWMutex
+}
+
+// spelling is the index used to correct typos in messages. It's built on boot
+// and as plugins register their triggers.
+var spelling = spellingIndex{
+	dictionaries: map[string]*bkTree{},
+	triggers:     &bkTree{},
+	mutex:        &sync.RWMutex{},
+}
+
+// setDictionary indexes the Commands and Objects of a language's NER.
+func (s spellingIndex) setDictionary(lang string, c classifier) {
+	t := &bkTree{}
+	for k := range c {
+		switch k[0] {
+		case 'C', 'O':
+			t.add(k[1:])
+		}
+	}
+	s.mutex.Lock()
+	s.dictionaries[lang] = t
+	s.mutex.Unlock()
+}
+
+// addTriggerRoute indexes the Command and Object stems of a route in the form
+// of CO_command_object. Other routes are ignored.
+func (s spellingIndex) addTriggerRoute(route string) {
+	if !strings.HasPrefix(route, "CO_") {
+		return
+	}
+	s.mutex.Lock()
+	for _, stem := range strings.SplitN(route[3:], "_", 2) {
+		if len(stem) > 0 {
+			s.triggers.add(stem)
+		}
+	}
+	s.mutex.Unlock()
+}
+
+// correct returns the tokens with misspelled words replaced by the words they
+// were taken to mean, along with a record of each correction. Words are
+// corrected to a word in the language's dictionary, preferring one which stems
+// to a plugin trigger; failing that, to a plugin trigger stem. A word is left
+// alone when it's known, or when no single correction is clearly best.
+func (s spellingIndex) correct(tokens []string, lang string,
+	c classifier) ([]string, []dt.Correction) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	dict := s.dictionaries[lang]
+	if dict == nil {
+		dict = s.dictionaries[dt.LangEnglish]
+	}
+	stemmer := snowball.For(lang)
+	var corrections []dt.Correction
+	corrected := append([]string{}, tokens...)
+	for i, t := range tokens {
+		if !correctable(t, i, c) {
+			continue
+		}
+		word := strings.ToLower(t)
+		maxDist := 1
+		if utf8.RuneCountInString(word) >= 8 {
+			maxDist = 2
+		}
+		var m bkMatch
+		var ok bool
+		if dict != nil {
+			m, ok = s.bestMatch(dict.search(word, maxDist), word,
+				stemmer)
+		}
+		if !ok {
+			m, ok = s.bestMatch(s.triggers.search(stemmer.Stem(word),
+				maxDist), word, nil)
+		}
+		if !ok {
+			continue
+		}
+		log.Debugf("corrected %q to %q\n", t, m.Word)
+		corrected[i] = m.Word
+		corrections = append(corrections, dt.Correction{
+			Original:  t,
+			Corrected: m.Word,
+			Distance:  m.Distance,
+		})
+	}
+	return corrected, corrections
+}
+
+// bestMatch picks the closest match to a word. Ties are broken in favor of
+// words which stem to a plugin trigger (if a stemmer is given), then words
+// sharing the same first letter. It reports false if a tie remains.
+func (s spellingIndex) bestMatch(matches []bkMatch, word string,
+	stemmer snowball.Stemmer) (bkMatch, bool) {
+
+	var best []bkMatch
+	for _, m := range matches {
+		switch {
+		case len(best) == 0 || m.Distance < best[0].Distance:
+			best = []bkMatch{m}
+		case m.Distance == best[0].Distance:
+			best = append(best, m)
+		}
+	}
+	if len(best) > 1 && stemmer != nil {
+		best = filterMatches(best, func(m bkMatch) bool {
+			return s.triggers.has(stemmer.Stem(m.Word))
+		})
+	}
+	if len(best) > 1 {
+		first, _ := utf8.DecodeRuneInString(word)
+		best = filterMatches(best, func(m bkMatch) bool {
+			r, _ := utf8.DecodeRuneInString(m.Word)
+			return r == first
+		})
+	}
+	if len(best) != 1 {
+		return bkMatch{}, false
+	}
+	return best[0], true
+}
+
+// filterMatches returns the matches satisfying fn, or all the matches if none
+// do.
+func filterMatches(matches []bkMatch, fn func(bkMatch) bool) []bkMatch {
+	var filtered []bkMatch
+	for _, m := range matches {
+		if fn(m) {
+			filtered = append(filtered, m)
+		}
+	}
+	if len(filtered) == 0 {
+		return matches
+	}
+	return filtered
+}
+
+// correctable reports whether a token at position i of a sentence may be a
+// misspelling. Only unknown words made up entirely of letters are corrected.
+// Capitalized words after the start of a sentence are assumed to be names.
+func correctable(t string, i int, c classifier) bool {
+	if utf8.RuneCountInString(t) < minCorrectionLen {
+		return false
+	}
+	for j, r := range t {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+		if j == 0 && i > 0 && unicode.IsUpper(r) {
+			return false
+		}
+	}
+	word := strings.ToLower(t)
+	if c.known(word) {
+		return false
+	}
+	if _, ok := commonWords[word]; ok {
+		return false
+	}
+	for _, words := range stopWords {
+		if _, ok := words[word]; ok {
+			return false
+		}
+	}
+	return true
+}
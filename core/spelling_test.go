@@ -0,0 +1,78 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+func TestBKTree(t *testing.T) {
+	tree := &bkTree{}
+	for _, w := range []string{"book", "books", "cake", "boo", "cape",
+		"cart", "book"} {
+		tree.add(w)
+	}
+	if tree.size != 6 {
+		t.Fatal("expected 6 words, got", tree.size)
+	}
+	matches := tree.search("bok", 1)
+	if len(matches) != 2 {
+		t.Fatal("expected book and boo, got", matches)
+	}
+	if !tree.has("cart") || tree.has("car") {
+		t.Fatal("expected cart but not car to be indexed")
+	}
+	tests := map[[2]string]int{
+		{"kitten", "sitting"}: 3,
+		{"ordr", "order"}:     1,
+		{"", "abc"}:           3,
+		{"mañana", "manana"}:  1,
+	}
+	for words, exp := range tests {
+		if d := editDistance(words[0], words[1]); d != exp {
+			t.Errorf("%q to %q: expected %d, got %d", words[0],
+				words[1], exp, d)
+		}
+	}
+}
+
+func TestSpellingCorrect(t *testing.T) {
+	ner, err := buildClassifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := spellingIndex{
+		dictionaries: map[string]*bkTree{},
+		triggers:     &bkTree{},
+		mutex:        &sync.RWMutex{},
+	}
+	s.setDictionary(dt.LangEnglish, ner)
+	s.addTriggerRoute("CO_order_pizza")
+	s.addTriggerRoute("CO_find_restaur")
+	tests := map[string]string{
+		"find a resturant":       "find a restaurant",
+		"ordr a pizza":           "order a pizza",
+		"order pizza with Jimbo": "order pizza with Jimbo",
+		"call me at 555-1234":    "call me at 555-1234",
+	}
+	for sent, exp := range tests {
+		tokens := TokenizeSentence(sent)
+		corrected, corrections := s.correct(tokens, dt.LangEnglish,
+			ner)
+		if got := strings.Join(corrected, " "); got != exp {
+			t.Errorf("%q: expected %q, got %q", sent, exp, got)
+		}
+		if exp == sent && len(corrections) > 0 {
+			t.Errorf("%q: expected no corrections, got %v", sent,
+				corrections)
+		}
+	}
+	_, corrections := s.correct(TokenizeSentence("ordr a pizza"),
+		dt.LangEnglish, ner)
+	if len(corrections) != 1 || corrections[0].Original != "ordr" ||
+		corrections[0].Distance != 1 {
+		t.Fatal("expected ordr to be recorded, got", corrections)
+	}
+}
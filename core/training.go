@@ -0,0 +1,249 @@
+package core
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/lib/pq"
+)
+
+// Sources of training sentences, recorded with each sentence so admins can
+// tell where it came from.
+const (
+	trainingSourcePlugin = "plugin"
+	trainingSourceAdmin  = "admin"
+	trainingSourceImport = "import"
+	trainingSourceRemote = "remote"
+)
+
+// errInvalidTrainingSentence is returned when a training sentence is missing
+// its plugin name, intent or sentence.
+var errInvalidTrainingSentence = errors.New(
+	"training sentences require a plugin name, intent and sentence")
+
+// errMissingTrainingSentence is returned when editing or deleting a training
+// sentence which doesn't exist.
+var errMissingTrainingSentence = errors.New("training sentence not found")
+
+// errDuplicateTrainingSentence is returned when editing a training sentence
+// to match another sentence of the same plugin and intent.
+var errDuplicateTrainingSentence = errors.New(
+	"training sentence already exists for the plugin and intent")
+
+// pqUniqueViolation is the Postgres error code of a unique constraint
+// violation.
+const pqUniqueViolation = "23505"
+
+// remoteTrainingEnabled reports whether training sentences should be fetched
+// from the remote source (ITSABOT_URL) on boot. Private and offline
+// deployments may disable this by setting ABOT_REMOTE_TRAINING to "false",
+// relying only on local training data.
+func remoteTrainingEnabled() bool {
+	return os.Getenv("ABOT_REMOTE_TRAINING") != "false"
+}
+
+// validate trims the training sentence's fields and ensures none are empty.
+func (s *tSentence) validate() error {
+	s.PluginName = strings.TrimSpace(s.PluginName)
+	s.Intent = strings.TrimSpace(s.Intent)
+	s.Sentence = strings.TrimSpace(s.Sentence)
+	if len(s.PluginName) == 0 || len(s.Intent) == 0 ||
+		len(s.Sentence) == 0 {
+		return errInvalidTrainingSentence
+	}
+	return nil
+}
+
+// addTrainingSentence saves a training sentence, setting its ID. Adding a
+// sentence which was previously deleted restores it. It reports whether the
+// sentence was added or restored, rather than already existing.
+func addTrainingSentence(db dt.DBConn, s *tSentence) (bool, error) {
+	if err := s.validate(); err != nil {
+		return false, err
+	}
+	q := `INSERT INTO trainingsentences
+	      (pluginname, intent, sentence, source) VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (pluginname, intent, sentence)
+	      DO UPDATE SET deletedat=NULL, updatedat=CURRENT_TIMESTAMP
+	      WHERE trainingsentences.deletedat IS NOT NULL
+	      RETURNING id`
+	row := db.QueryRowx(q, s.PluginName, s.Intent, s.Sentence, s.Source)
+	err := row.Scan(&s.ID)
+	if err != sql.ErrNoRows {
+		return err == nil, err
+	}
+
+	// The sentence already exists.
+	q = `SELECT id FROM trainingsentences
+	     WHERE pluginname=$1 AND intent=$2 AND sentence=$3`
+	return false, db.Get(&s.ID, q, s.PluginName, s.Intent, s.Sentence)
+}
+
+// seedTrainingSentence saves a training sentence unless it already exists.
+// Unlike addTrainingSentence, sentences deleted by an admin stay deleted, so
+// seeding can be repeated on every boot.
+func seedTrainingSentence(db dt.DBConn, s *tSentence) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	q := `INSERT INTO trainingsentences
+	      (pluginname, intent, sentence, source) VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (pluginname, intent, sentence) DO NOTHING`
+	_, err := db.Exec(q, s.PluginName, s.Intent, s.Sentence, s.Source)
+	return err
+}
+
+// updateTrainingSentence changes the plugin, intent and sentence of a training
+// sentence, returning the name of the plugin to which it belonged before.
+func updateTrainingSentence(db dt.DBConn, s *tSentence) (string, error) {
+	if err := s.validate(); err != nil {
+		return "", err
+	}
+	q := `UPDATE trainingsentences t
+	      SET pluginname=$1, intent=$2, sentence=$3,
+		updatedat=CURRENT_TIMESTAMP
+	      FROM (SELECT id, pluginname FROM trainingsentences
+		    WHERE id=$4 FOR UPDATE) old
+	      WHERE t.id=old.id AND t.deletedat IS NULL
+	      RETURNING old.pluginname`
+	var oldPluginName string
+	row := db.QueryRowx(q, s.PluginName, s.Intent, s.Sentence, s.ID)
+	err := row.Scan(&oldPluginName)
+	if err == sql.ErrNoRows {
+		return "", errMissingTrainingSentence
+	}
+	if e, ok := err.(*pq.Error); ok && e.Code == pqUniqueViolation {
+		return "", errDuplicateTrainingSentence
+	}
+	return oldPluginName, err
+}
+
+// deleteTrainingSentence removes a training sentence, returning the name of the
+// plugin to which it belonged. The sentence is kept, marked as deleted, so it
+// isn't seeded again on the next boot.
+func deleteTrainingSentence(db dt.DBConn, id uint64) (string, error) {
+	q := `UPDATE trainingsentences SET deletedat=CURRENT_TIMESTAMP
+	      WHERE id=$1 AND deletedat IS NULL
+	      RETURNING pluginname`
+	var pluginName string
+	err := db.QueryRowx(q, id).Scan(&pluginName)
+	if err == sql.ErrNoRows {
+		return "", errMissingTrainingSentence
+	}
+	return pluginName, err
+}
+
+// getTrainingSentences returns the training sentences of a plugin, or of all
+// plugins if pluginName is empty.
+func getTrainingSentences(db dt.DBConn, pluginName string) ([]tSentence,
+	error) {
+
+	ss := []tSentence{}
+	q := `SELECT id, pluginname, intent, sentence, source
+	      FROM trainingsentences
+	      WHERE deletedat IS NULL AND ($1='' OR pluginname=$1)
+	      ORDER BY id`
+	if err := db.Select(&ss, q, pluginName); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// seedTrainingSentences adds the training sentences defined in each plugin's
+// plugin.json to the local training data.
+func seedTrainingSentences(db dt.DBConn) error {
+	for _, pconf := range PluginsGo {
+		for intent, sentences := range pconf.Training {
+			for _, sentence := range sentences {
+				s := &tSentence{
+					PluginName: pconf.Name,
+					Intent:     intent,
+					Sentence:   sentence,
+					Source:     trainingSourcePlugin,
+				}
+				if err := seedTrainingSentence(db, s); err != nil {
+					return fmt.Errorf("%s: %s", pconf.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cacheRemoteTrainingSentences fetches a plugin's training sentences from the
+// remote source and adds them to the local training data, so they remain
+// available if the remote source can't be reached later. Plugins which were
+// never published have no remote training sentences.
+func cacheRemoteTrainingSentences(db dt.DBConn, pconf dt.PluginConfig) error {
+	if pconf.ID == 0 {
+		return nil
+	}
+	ss, err := fetchTrainingSentences(pconf.ID, pconf.Name)
+	if err != nil {
+		return err
+	}
+	for _, s := range ss {
+		s.PluginName = pconf.Name
+		s.Source = trainingSourceRemote
+		if err = seedTrainingSentence(db, &s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportTrainingSentences adds training sentences from a JSONL file, where each
+// line is a JSON object such as:
+//
+//	{"PluginName": "weather", "Intent": "get_weather", "Sentence": "Will it rain?"}
+//
+// It returns the number of sentences imported. Pass a transaction to import all
+// sentences or none of them.
+func ImportTrainingSentences(db dt.DBConn, r io.Reader) (int, error) {
+	ss, err := readTrainingSentences(r)
+	if err != nil {
+		return 0, err
+	}
+	for i := range ss {
+		if _, err = addTrainingSentence(db, &ss[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(ss), nil
+}
+
+// readTrainingSentences parses training sentences from JSONL, skipping blank
+// lines. Errors include the line on which they occurred.
+func readTrainingSentences(r io.Reader) ([]tSentence, error) {
+	var ss []tSentence
+	scn := bufio.NewScanner(r)
+	var line int
+	for scn.Scan() {
+		line++
+		b := scn.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var s tSentence
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		s.ID = 0
+		s.Source = trainingSourceImport
+		ss = append(ss, s)
+	}
+	if err := scn.Err(); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
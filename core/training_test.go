@@ -0,0 +1,34 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadTrainingSentences(t *testing.T) {
+	r := strings.NewReader(`{"PluginName": "weather", "Intent": "get_weather", "Sentence": "Will it rain?"}
+
+{"PluginName": "weather", "Intent": "get_weather", "Sentence": " Is it sunny? ", "Source": "remote", "ID": 9}
+`)
+	ss, err := readTrainingSentences(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ss) != 2 {
+		t.Fatalf("expected 2 sentences, got %d", len(ss))
+	}
+	s := ss[1]
+	if s.Sentence != "Is it sunny?" {
+		t.Fatalf("expected trimmed sentence, got %q", s.Sentence)
+	}
+	if s.Source != trainingSourceImport || s.ID != 0 {
+		t.Fatalf("expected an unsaved imported sentence, got %+v", s)
+	}
+
+	r = strings.NewReader(`{"PluginName": "weather", "Intent": "get_weather", "Sentence": "Will it rain?"}
+{"PluginName": "weather", "Sentence": "Is it sunny?"}`)
+	_, err = readTrainingSentences(r)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("expected an error on line 2, got %v", err)
+	}
+}
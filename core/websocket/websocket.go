@@ -1,9 +1,11 @@
 // Package websocket manages websocket connections and notifications for Abot
 // clients. This is used by github.com/itsabot/plugin_training to notify
-// trainers of new user messages without requiring page reload.
+// trainers of new user messages without requiring page reload, and by Abot
+// core to push messages to users chatting through a web session.
 package websocket
 
 import (
+	"errors"
 	"runtime"
 	"sync"
 	"time"
@@ -79,3 +81,65 @@ func (as AtomicWebSocketSet) NotifySockets(uid uint64, cmd, ret string) error {
 	}
 	return websocket.JSON.Send(s, &data)
 }
+
+// ErrSessionClosed is returned when sending to a web session which has no open
+// websocket connection.
+var ErrSessionClosed = errors.New("session closed")
+
+// AtomicSessionSet maintains open websocket connections for users chatting
+// with Abot through a web session, with up to one available per session. The
+// string key within the map represents the session's flexid. Abot uses these
+// connections to push messages to users outside of a request.
+type AtomicSessionSet struct {
+	sockets map[string]*websocket.Conn
+	mutex   *sync.Mutex
+}
+
+// NewAtomicSessionSet returns an AtomicSessionSet to maintain open WebSocket
+// connections on a per-session basis.
+func NewAtomicSessionSet() AtomicSessionSet {
+	return AtomicSessionSet{
+		sockets: map[string]*websocket.Conn{},
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// Get returns a WebSocket connection for a given session in a thread-safe way.
+func (ss AtomicSessionSet) Get(flexID string) *websocket.Conn {
+	var conn *websocket.Conn
+	ss.mutex.Lock()
+	conn = ss.sockets[flexID]
+	ss.mutex.Unlock()
+	runtime.Gosched()
+	return conn
+}
+
+// Set a WebSocket connection for a given session in a thread-safe way.
+func (ss AtomicSessionSet) Set(flexID string, conn *websocket.Conn) {
+	ss.mutex.Lock()
+	ss.sockets[flexID] = conn
+	ss.mutex.Unlock()
+	runtime.Gosched()
+}
+
+// Delete a WebSocket connection for a given session in a thread-safe way. The
+// connection is only deleted if it's still the session's current connection,
+// so a newer connection for the same session isn't removed.
+func (ss AtomicSessionSet) Delete(flexID string, conn *websocket.Conn) {
+	ss.mutex.Lock()
+	if ss.sockets[flexID] == conn {
+		delete(ss.sockets, flexID)
+	}
+	ss.mutex.Unlock()
+	runtime.Gosched()
+}
+
+// Send v as JSON to a web session. If the session has no open connection,
+// ErrSessionClosed is returned.
+func (ss AtomicSessionSet) Send(flexID string, v interface{}) error {
+	s := ss.Get(flexID)
+	if s == nil {
+		return ErrSessionClosed
+	}
+	return websocket.JSON.Send(s, v)
+}
@@ -18,6 +18,11 @@ type Address struct {
 	Zip4           string
 	Country        string
 	DisplayAddress string
+
+	// Lat and Lon are the coordinates of the address, if it's been
+	// geocoded. They're zero when unknown.
+	Lat float64
+	Lon float64
 }
 
 // ErrNoAddress signals that no address could be found when one was expected.
@@ -4,4 +4,9 @@ package dt
 type City struct {
 	Name        string
 	CountryCode string
+
+	// Lat and Lon are the coordinates of the city. They're zero when
+	// unknown.
+	Lat float64
+	Lon float64
 }
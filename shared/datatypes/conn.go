@@ -0,0 +1,147 @@
+package dt
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DBConn is implemented by both *sqlx.DB and *sqlx.Tx, enabling the same
+// queries to run either directly against the database or within the
+// transaction of a user's turn (see Msg.Tx).
+type DBConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	QueryRowx(query string, args ...interface{}) *sqlx.Row
+}
+
+// turn holds the transaction of a user's turn. It's shared by every copy of
+// the turn's message (see Msg.WithContext), so ending the turn revokes the
+// transaction from everything still holding the message, such as a plugin's
+// goroutine.
+type turn struct {
+	mu sync.RWMutex
+	tx *sqlx.Tx
+
+	// rowPending is set while the savepoint of a row returned by
+	// turnConn.QueryRowx is held. It's released before the turn's next
+	// query, by which time the row has been scanned.
+	rowPending bool
+}
+
+// releaseRow releases the savepoint of a row returned by QueryRowx, if any.
+// Should scanning the row have failed the transaction, the savepoint is rolled
+// back first, leaving the transaction usable. The caller must hold the lock.
+func (t *turn) releaseRow() error {
+	if !t.rowPending || t.tx == nil {
+		return nil
+	}
+	t.rowPending = false
+	if _, err := t.tx.Exec("RELEASE SAVEPOINT " + savepoint); err == nil {
+		return nil
+	}
+	if _, err := t.tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint); err != nil {
+		return err
+	}
+	_, err := t.tx.Exec("RELEASE SAVEPOINT " + savepoint)
+	return err
+}
+
+// turnConn runs queries within the transaction of a user's turn while the turn
+// lasts, and directly against the database once it has ended. Each query holds
+// the turn open until it completes, so the transaction is never committed or
+// rolled back from under it.
+//
+// Within the transaction, each query runs in its own savepoint. On Postgres, a
+// failed statement aborts the rest of its transaction, so without savepoints a
+// query whose error a plugin ignores, e.g. in SetMemory, would fail every later
+// query of the turn.
+type turnConn struct {
+	turn *turn
+	db   *sqlx.DB
+}
+
+// savepoint is the name of the savepoint in which each of a plugin's queries
+// runs within the transaction of a user's turn.
+const savepoint = "abot_plugin_query"
+
+// run calls fn with the connection to use, within a savepoint if that's the
+// turn's transaction. The savepoint is rolled back if fn fails, leaving the
+// transaction usable, and released otherwise.
+func (c *turnConn) run(fn func(DBConn) error) error {
+	c.turn.mu.Lock()
+	defer c.turn.mu.Unlock()
+	tx := c.turn.tx
+	if tx == nil {
+		return fn(c.db)
+	}
+	if err := c.turn.releaseRow(); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("SAVEPOINT " + savepoint); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_, errR := tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint)
+		if errR != nil {
+			return errR
+		}
+		return err
+	}
+	_, err := tx.Exec("RELEASE SAVEPOINT " + savepoint)
+	return err
+}
+
+func (c *turnConn) Exec(query string, args ...interface{}) (sql.Result,
+	error) {
+
+	var res sql.Result
+	err := c.run(func(conn DBConn) error {
+		var err error
+		res, err = conn.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+func (c *turnConn) Get(dest interface{}, query string,
+	args ...interface{}) error {
+
+	return c.run(func(conn DBConn) error {
+		return conn.Get(dest, query, args...)
+	})
+}
+
+func (c *turnConn) Select(dest interface{}, query string,
+	args ...interface{}) error {
+
+	return c.run(func(conn DBConn) error {
+		return conn.Select(dest, query, args...)
+	})
+}
+
+// QueryRowx runs a query within a savepoint like the other queries. Since the
+// row isn't read until it's scanned, the savepoint is released before the
+// turn's next query or when the turn ends.
+func (c *turnConn) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	c.turn.mu.Lock()
+	defer c.turn.mu.Unlock()
+	tx := c.turn.tx
+	if tx == nil {
+		return c.db.QueryRowx(query, args...)
+	}
+	if err := c.turn.releaseRow(); err != nil {
+		return tx.QueryRowx(query, args...)
+	}
+	if _, err := tx.Exec("SAVEPOINT " + savepoint); err != nil {
+		return tx.QueryRowx(query, args...)
+	}
+	c.turn.rowPending = true
+	row := tx.QueryRowx(query, args...)
+	if row.Err() != nil {
+		_ = c.turn.releaseRow()
+	}
+	return row
+}
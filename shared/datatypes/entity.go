@@ -0,0 +1,51 @@
+package dt
+
+// EntityType identifies the kind of value held by an Entity.
+type EntityType string
+
+// EntityTypes recognized by Abot.
+const (
+	EntityQuantity   EntityType = "quantity"
+	EntityMoney      EntityType = "money"
+	EntityPercentage EntityType = "percentage"
+	EntityEmail      EntityType = "email"
+	EntityPhone      EntityType = "phone"
+	EntityURL        EntityType = "url"
+)
+
+// Entity is a typed value found in a message, such as an amount of money or an
+// email address, along with where it was found.
+type Entity struct {
+	Type EntityType
+
+	// Text is the entity as it was written, e.g. "$5.99".
+	Text string
+
+	// Start and End are the span of Msg.Tokens making up the entity, i.e.
+	// Tokens[Start:End].
+	Start int
+	End   int
+
+	// Number is the value of a quantity, percentage or amount of money,
+	// e.g. 5.99 for "$5.99" and 12.5 for "12.5%".
+	Number float64
+
+	// Currency is the ISO 4217 code of an amount of money, e.g. "USD".
+	Currency string
+
+	// Value is the normalized form of an email address, phone number or
+	// URL, e.g. "+14155550100" for "(415) 555-0100".
+	Value string
+}
+
+// EntitiesOf returns the message's entities of a given type in the order they
+// appear.
+func (s *StructuredInput) EntitiesOf(typ EntityType) []Entity {
+	var es []Entity
+	for _, e := range s.Entities {
+		if e.Type == typ {
+			es = append(es, e)
+		}
+	}
+	return es
+}
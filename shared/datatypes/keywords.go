@@ -1,15 +1,20 @@
 package dt
 
 import (
+	"context"
 	"strings"
 
-	"github.com/dchest/stemmer/porter2"
+	"github.com/itsabot/abot/shared/helpers/snowball"
 )
 
 // Keywords maintains sets of Commands and Objects recognized by plugins as well
 // as the functions to be performed when such Commands or Objects are found.
 type Keywords struct {
 	Dict map[string]KeywordFn
+
+	// DictCtx holds context-aware keyword functions, which take
+	// precedence over those in Dict.
+	DictCtx map[string]KeywordFnCtx
 }
 
 // KeywordHandler maintains sets of Commands and Objects recognized by plugins as
@@ -18,39 +23,78 @@ type Keywords struct {
 type KeywordHandler struct {
 	Fn      KeywordFn
 	Trigger *StructuredInput
+
+	// FnCtx is a context-aware variant of Fn, which is used instead when
+	// set.
+	FnCtx KeywordFnCtx
+
+	// Language is the ISO 639-1 code of the language of the Trigger's
+	// Commands and Objects, e.g. "es". It defaults to English.
+	Language string
 }
 
 // KeywordFn is a function run when the user sends a matched keyword as
 // defined by a plugin. The response returned is a user-presentable string from
-// the KeywordFn.
+// the KeywordFn. Rich content, like quick-reply options and cards, may be added
+// to in.Response.
 type KeywordFn func(in *Msg) (response string)
 
+// KeywordFnCtx is a context-aware variant of KeywordFn. The context is canceled
+// when the user's request ends or the plugin's deadline passes (see
+// PluginConfig.Timeout), so calls to slow APIs should be made with it.
+type KeywordFnCtx func(ctx context.Context, in *Msg) (response string)
+
+// get returns the keyword function for a route, preferring the context-aware
+// variant.
+func (k *Keywords) get(route string) (KeywordFnCtx, bool) {
+	if fn, ok := k.DictCtx[route]; ok {
+		return fn, true
+	}
+	fn, ok := k.Dict[route]
+	if !ok {
+		return nil, false
+	}
+	return func(ctx context.Context, in *Msg) string { return fn(in) }, true
+}
+
 // handle runs the first matching KeywordFn in the sentence.
 func (k *Keywords) handle(m *Msg) string {
 	if k == nil {
 		return ""
 	}
-	for _, intent := range m.StructuredInput.Intents {
-		fn, ok := k.Dict["I_"+intent]
+	si := m.StructuredInput
+	for _, intent := range si.Intents {
+		// A negative message, e.g. "Don't order pizza", triggers no
+		// intent other than its cancellation.
+		if si.Negative() && intent != IntentCancel {
+			continue
+		}
+		fn, ok := k.get("I_" + intent)
 		if !ok {
 			continue
 		}
 
 		// If we find an intent function, use that.
-		return fn(m)
+		return fn(m.Context(), m)
 	}
 
 	// No matching intent was found, so check for both Command and Object.
-	eng := porter2.Stemmer
-	for _, cmd := range m.StructuredInput.Commands {
-		cmd = strings.ToLower(eng.Stem(cmd))
-		for _, obj := range m.StructuredInput.Objects {
-			obj = strings.ToLower(eng.Stem(obj))
-			fn, ok := k.Dict["CO_"+cmd+"_"+obj]
+	stemmer := snowball.For(m.Language)
+	for _, cmd := range si.Commands {
+		if si.IsNegated(cmd) || !si.ActsAs(cmd, SITCommand) {
+			continue
+		}
+		cmd = strings.ToLower(stemmer.Stem(cmd))
+		for _, obj := range si.Objects {
+			if si.IsNegated(obj) || !si.ActsAs(obj, SITObject) {
+				continue
+			}
+			obj = strings.ToLower(stemmer.Stem(obj))
+			fn, ok := k.get("CO_" + cmd + "_" + obj)
 			if !ok {
 				continue
 			}
-			return fn(m)
+			return fn(m.Context(), m)
 		}
 	}
 	return ""
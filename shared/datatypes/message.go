@@ -1,12 +1,21 @@
 package dt
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 
+	"github.com/itsabot/abot/core/log"
 	"github.com/jmoiron/sqlx"
 )
 
+// Languages understood by Abot, identified by their ISO 639-1 codes.
+const (
+	LangEnglish = "en"
+	LangSpanish = "es"
+)
+
 // Msg is a message received by a user. It holds various fields that are useful
 // for plugins which are populated by Abot core in core/process.
 type Msg struct {
@@ -28,7 +37,95 @@ type Msg struct {
 	Tokens []string
 	Route  string
 
+	// SessionID identifies the conversation session to which the message
+	// belongs. A session ends once the user has been idle for longer than
+	// Abot's session timeout, after which their next message starts a new
+	// session.
+	SessionID uint64
+
+	// Language is the ISO 639-1 code of the language in which the
+	// message was written, e.g. "es". It's detected from the message,
+	// falling back to the language the user last wrote in.
+	Language string
+
+	// Corrections records the words Abot assumed were misspelled, and the
+	// words they were taken to mean, so trainers can see what was assumed.
+	Corrections []Correction
+
+	// IntentModelVersion identifies the version of Abot's intent model
+	// which classified the message's intents. It's 0 if no model had been
+	// trained.
+	IntentModelVersion uint64
+
 	Usage []string
+
+	// Response holds rich content for Abot's reply to this message, such
+	// as quick-reply options and cards. Plugins may add to it from a
+	// KeywordFn or a State's OnEntry function. The string returned by those
+	// functions becomes the Response's Sentence.
+	Response *Response
+
+	// turn holds the database transaction in which Abot processes this
+	// message. It's set through SetTx and shared by copies of the message.
+	turn *turn
+
+	// ctx is the context in which the message is processed. It's accessed
+	// through Context and set through WithContext.
+	ctx context.Context
+}
+
+// Context returns the message's context, which is canceled when the user's
+// request ends or the deadline of the plugin responding to the message passes.
+// If the message has no context, context.Background is returned.
+func (m *Msg) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the message with its context changed
+// to ctx.
+func (m *Msg) WithContext(ctx context.Context) *Msg {
+	m2 := *m
+	m2.ctx = ctx
+	return &m2
+}
+
+// Tx returns the database transaction in which Abot processes this message,
+// or nil once Abot has responded to it. Everything written during the user's
+// turn is committed together, or rolled back together if the turn fails, e.g.
+// on a plugin panic. Plugins should use Plugin.Conn rather than Tx, since a
+// plugin may still be running when the turn ends.
+func (m *Msg) Tx() *sqlx.Tx {
+	if m.turn == nil {
+		return nil
+	}
+	m.turn.mu.RLock()
+	defer m.turn.mu.RUnlock()
+	return m.turn.tx
+}
+
+// SetTx begins the user's turn for this message, so it's processed within the
+// transaction tx. Copies of the message made afterward share the turn.
+func (m *Msg) SetTx(tx *sqlx.Tx) {
+	m.turn = &turn{tx: tx}
+}
+
+// EndTx ends the user's turn, so neither the message nor any copy of it is
+// processed within the turn's transaction anymore. It waits for queries
+// already running within the transaction through Plugin.Conn, so it should be
+// called before the transaction is committed or rolled back.
+func (m *Msg) EndTx() {
+	if m.turn == nil {
+		return
+	}
+	m.turn.mu.Lock()
+	if err := m.turn.releaseRow(); err != nil {
+		log.Info("failed to release savepoint of plugin's row", err)
+	}
+	m.turn.tx = nil
+	m.turn.mu.Unlock()
 }
 
 // GetMsg returns a message for a given message ID.
@@ -43,8 +140,21 @@ func GetMsg(db *sqlx.DB, id uint64) (*Msg, error) {
 	return m, nil
 }
 
+// Correction is a word in a message which Abot assumed was misspelled.
+type Correction struct {
+	// Original is the word as it was written.
+	Original string
+
+	// Corrected is the word it was taken to mean.
+	Corrected string
+
+	// Distance is the number of letters inserted, deleted or substituted
+	// to correct the word.
+	Distance int
+}
+
 // Update a message as needing training.
-func (m *Msg) Update(db *sqlx.DB) error {
+func (m *Msg) Update(db DBConn) error {
 	q := `UPDATE messages SET needstraining=$1 WHERE id=$2`
 	if _, err := db.Exec(q, m.NeedsTraining, m.ID); err != nil {
 		return err
@@ -53,18 +163,27 @@ func (m *Msg) Update(db *sqlx.DB) error {
 }
 
 // Save a message to the database, updating the message ID.
-func (m *Msg) Save(db *sqlx.DB) error {
+func (m *Msg) Save(db DBConn) error {
 	var pluginName string
 	if m.Plugin != nil {
 		pluginName = m.Plugin.Config.Name
 	}
+	corrections := m.Corrections
+	if corrections == nil {
+		corrections = []Correction{}
+	}
+	byt, err := json.Marshal(corrections)
+	if err != nil {
+		return err
+	}
 	q := `INSERT INTO messages
 	      (userid, sentence, plugin, route, abotsent, needstraining, flexid,
-		flexidtype, trained)
-	      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+		flexidtype, trained, sessionid, intentmodelversion, corrections)
+	      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	      RETURNING id`
 	row := db.QueryRowx(q, m.User.ID, m.Sentence, pluginName, m.Route,
 		m.AbotSent, m.NeedsTraining, m.User.FlexID, m.User.FlexIDType,
-		m.Trained)
+		m.Trained, m.SessionID, m.IntentModelVersion, byt)
 	if err := row.Scan(&m.ID); err != nil {
 		return err
 	}
@@ -72,8 +191,10 @@ func (m *Msg) Save(db *sqlx.DB) error {
 }
 
 // GetLastPlugin for a given user so the previous plugin can be called again if
-// no new trigger is detected.
-func (m *Msg) GetLastPlugin(db *sqlx.DB) (string, string, error) {
+// no new trigger is detected. If the message belongs to a session, only plugins
+// used within that session are considered, so a user returning after their
+// session expired doesn't resume a stale conversation.
+func (m *Msg) GetLastPlugin(db DBConn) (string, string, error) {
 	var res struct {
 		Plugin string
 		Route  string
@@ -82,13 +203,18 @@ func (m *Msg) GetLastPlugin(db *sqlx.DB) (string, string, error) {
 	if m.User.ID > 0 {
 		q := `SELECT route, plugin FROM messages
 		      WHERE userid=$1 AND abotsent IS FALSE
-		      ORDER BY createdat DESC`
-		err = db.Get(&res, q, m.User.ID)
+		      AND ($2=0 OR sessionid=$2)
+		      ORDER BY createdat DESC
+		      LIMIT 1`
+		err = db.Get(&res, q, m.User.ID, m.SessionID)
 	} else {
 		q := `SELECT route, plugin FROM messages
 		      WHERE flexid=$1 AND flexidtype=$2 AND abotsent IS FALSE
-		      ORDER BY createdat DESC`
-		err = db.Get(&res, q, m.User.FlexID, m.User.FlexIDType)
+		      AND ($3=0 OR sessionid=$3)
+		      ORDER BY createdat DESC
+		      LIMIT 1`
+		err = db.Get(&res, q, m.User.FlexID, m.User.FlexIDType,
+			m.SessionID)
 	}
 	if err != nil && err != sql.ErrNoRows {
 		return "", "", err
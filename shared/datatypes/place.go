@@ -0,0 +1,25 @@
+package dt
+
+// Place is a location mentioned in a message. It's resolved to either a City
+// or an Address, such as a street address or one of the user's saved
+// addresses, e.g. "home".
+type Place struct {
+	// Name is the place as the user referred to it, e.g. "home" or "Los
+	// Angeles".
+	Name string
+
+	City    *City
+	Address *Address
+}
+
+// Coordinates returns the latitude and longitude of the place, reporting false
+// if they're unknown.
+func (p Place) Coordinates() (lat, lon float64, ok bool) {
+	switch {
+	case p.Address != nil && (p.Address.Lat != 0 || p.Address.Lon != 0):
+		return p.Address.Lat, p.Address.Lon, true
+	case p.City != nil && (p.City.Lat != 0 || p.City.Lon != 0):
+		return p.City.Lat, p.City.Lon, true
+	}
+	return 0, 0, false
+}
@@ -1,10 +1,12 @@
 package dt
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"runtime/debug"
 	"time"
 
 	"github.com/itsabot/abot/core/log"
@@ -23,6 +25,17 @@ type Plugin struct {
 	Log         *log.Logger
 	Events      *PluginEvents
 	SetBranches func(in *Msg) [][]State
+
+	// Decline allows a plugin to pass on a message routed to it, so the
+	// next plugin sharing the route is tried instead. By default plugins
+	// never decline.
+	Decline func(in *Msg) bool
+
+	// Triggers holds the plugin's triggers in languages other than
+	// English, keyed by the language's ISO 639-1 code, e.g. "es". Their
+	// Commands and Objects are stemmed for that language when the plugin
+	// registers, so messages in the language are routed to the plugin.
+	Triggers map[string]*StructuredInput
 }
 
 // PluginConfig holds options for a plugin.
@@ -50,6 +63,29 @@ type PluginConfig struct {
 	// required API key.
 	Settings map[string]*PluginSetting
 
+	// Priority orders plugins which share a route, e.g. two plugins both
+	// triggered by "find_restaurant". Plugins with a higher priority are
+	// tried first. It's defined in plugin.json and defaults to 0.
+	Priority int
+
+	// Timeout is the maximum time the plugin may take to respond to a
+	// message, e.g. "10s", after which Abot replies to the user that the
+	// request timed out. It's defined in plugin.json and defaults to 15
+	// seconds.
+	Timeout string
+
+	// IntentThreshold is the minimum probability, between 0 and 1, with
+	// which the plugin's classifier must recognize an intent for the intent
+	// to be added to a message's StructuredInput.Intents. It's defined in
+	// plugin.json and defaults to 0.7.
+	IntentThreshold float64
+
+	// Training maps each of the plugin's intents to example sentences,
+	// e.g. {"find_restaurant": ["Find me a place to eat"]}. They're added
+	// to Abot's local training data on boot, which builds the plugin's
+	// intent classifier. It's defined in plugin.json.
+	Training map[string][]string
+
 	// Tests contains a set of questions with a list of expected responses.
 	// The complex data structure enables developers to test plugin inputs
 	// against randomized or uncertain responses.
@@ -70,6 +106,44 @@ type PluginEvents struct {
 	PreProcessing  func(cmd *string, u *User)
 	PostProcessing func(in *Msg)
 	PreResponse    func(in *Msg, resp *string)
+
+	// SessionStart is called with the first message of each new
+	// conversation session.
+	SessionStart func(in *Msg)
+
+	// SessionEnd is called when a user's conversation session expires.
+	// The Msg identifies the user and the session that ended; it isn't a
+	// message from the user. Plugins' state machines have already been
+	// reset when it's called.
+	SessionEnd func(in *Msg)
+
+	// OnError is called when Abot fails to process a user's message, such
+	// as when a plugin panics or times out. The Msg is nil if the error
+	// occurred before the message was built.
+	OnError func(in *Msg, err error)
+
+	// Context-aware variants of the events above, which are called
+	// instead when set. The context is canceled when the user's request
+	// ends or the plugin's deadline passes (see PluginConfig.Timeout).
+	// Unlike a plugin's response, these events are called synchronously
+	// and aren't abandoned at the deadline, so an event which ignores its
+	// context still holds up the user's request.
+	PostReceiveCtx    func(ctx context.Context, cmd *string)
+	PreProcessingCtx  func(ctx context.Context, cmd *string, u *User)
+	PostProcessingCtx func(ctx context.Context, in *Msg)
+	PreResponseCtx    func(ctx context.Context, in *Msg, resp *string)
+}
+
+// Conn returns the database connection a plugin should use while responding to
+// a message. During a user's turn, queries run within the turn's transaction,
+// so the plugin's writes are rolled back along with Abot's if the turn fails.
+// Once the turn ends, such as in a goroutine which outlives the request, the
+// same connection runs queries against p.DB.
+func (p *Plugin) Conn(in *Msg) DBConn {
+	if in != nil && in.turn != nil {
+		return &turnConn{turn: in.turn, db: p.DB}
+	}
+	return p.DB
 }
 
 // Schedule a message to the user to be delivered at a future time. This is
@@ -85,10 +159,80 @@ func (p *Plugin) Schedule(in *Msg, content string, sendat time.Time) error {
 	q := `INSERT INTO scheduledevents (content, flexid, flexidtype, sendat,
 		pluginname)
 	      VALUES ($1, $2, $3, $4, $5)`
-	_, err := p.DB.Exec(q, content, in.User.FlexID, in.User.FlexIDType, sendat, p.Config.Name)
+	_, err := p.Conn(in).Exec(q, content, in.User.FlexID, in.User.FlexIDType, sendat, p.Config.Name)
+	return err
+}
+
+// ErrRecurrenceEnded is returned when scheduling a recurring event whose
+// recurrence has no occurrences in the future.
+var ErrRecurrenceEnded = errors.New("recurrence has no future occurrences")
+
+// ScheduleRecurring schedules a message to the user to be delivered repeatedly,
+// e.g. every Tuesday at 9, until its recurrence ends or the event is
+// unscheduled. Recurrences are usually parsed from the user's message by
+// timeparse.ParseRecurrence. It returns the ID of the event, which may be
+// passed to Unschedule.
+func (p *Plugin) ScheduleRecurring(in *Msg, content string, r *Recurrence) (
+	uint64, error) {
+
+	sendat, ok := r.Next(time.Now())
+	if !ok {
+		return 0, ErrRecurrenceEnded
+	}
+	q := `INSERT INTO scheduledevents (content, flexid, flexidtype, sendat,
+		pluginname, recurrence)
+	      VALUES ($1, $2, $3, $4, $5, $6)
+	      RETURNING id`
+	var id uint64
+	row := p.Conn(in).QueryRowx(q, content, in.User.FlexID,
+		in.User.FlexIDType, sendat, p.Config.Name, r.String())
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Unschedule cancels an event scheduled by the plugin for the user, so it's
+// never sent again.
+func (p *Plugin) Unschedule(in *Msg, id uint64) error {
+	q := `DELETE FROM scheduledevents
+	      WHERE id=$1 AND pluginname=$2 AND flexid=$3 AND flexidtype=$4`
+	_, err := p.Conn(in).Exec(q, id, p.Config.Name, in.User.FlexID,
+		in.User.FlexIDType)
 	return err
 }
 
+// ErrPushUnavailable is returned when pushing a message before Abot core has
+// booted.
+var ErrPushUnavailable = errors.New("push unavailable")
+
+// pushFn delivers a response to a user outside of the request cycle. It's set
+// by Abot core on boot via SetPushHandler.
+var pushFn func(p *Plugin, in *Msg, resp *Response) error
+
+// SetPushHandler sets the function used to deliver pushed messages. It's called
+// by Abot core on boot, and isn't needed when building plugins.
+func SetPushHandler(fn func(p *Plugin, in *Msg, resp *Response) error) {
+	pushFn = fn
+}
+
+// Push sends one or more messages to the user outside of the usual
+// request/response cycle, such as after a long-running lookup completes in a
+// goroutine. Messages are delivered over the user's current channel, e.g. SMS,
+// email or their web session, and are saved like any other response from Abot.
+// Delivery stops at the first message which fails to send.
+func (p *Plugin) Push(in *Msg, resps ...*Response) error {
+	if pushFn == nil {
+		return ErrPushUnavailable
+	}
+	for _, resp := range resps {
+		if err := pushFn(p, in, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // run is an unexported function that executes a plugin's behavior when the
 // plugin is called. First the plugin attempts to respond using keyword
 // functions. If that response is empty (""), run will try the plugin's state
@@ -118,21 +262,51 @@ func (p *Plugin) run(in *Msg) (resp string, stateMachineAnswered bool) {
 	return resp, stateMachineAnswered
 }
 
+// PluginPanicError is returned by CallPlugin when a plugin panics while
+// responding to a message. It's also returned when anything else panics during
+// a user's turn, such as a Responder or a plugin's event, in which case Plugin
+// is empty.
+type PluginPanicError struct {
+	Plugin string
+	Val    interface{}
+	Stack  []byte
+}
+
+func (e *PluginPanicError) Error() string {
+	if len(e.Plugin) == 0 {
+		return fmt.Sprintf("turn panicked: %v", e.Val)
+	}
+	return fmt.Sprintf("plugin %s panicked: %v", e.Plugin, e.Val)
+}
+
 // CallPlugin sends a plugin the user's preprocessed message. The followup bool
 // dictates whether this is the first consecutive time the user has sent that
 // plugin a message, or if the user is engaged in a conversation with the
 // plugin. This difference enables plugins to respond differently--like reset
-// state--when messaged for the first time in each new conversation.
+// state--when messaged for the first time in each new conversation. If the
+// plugin panics, the panic is recovered and returned as a *PluginPanicError,
+// so Abot can roll back the user's turn.
 func CallPlugin(p *Plugin, in *Msg, followup bool) (resp string,
-	stateMachineAnswered bool) {
+	stateMachineAnswered bool, err error) {
 
 	if p == nil {
-		return "", false
+		return "", false, nil
 	}
+	defer func() {
+		if r := recover(); r != nil {
+			resp, stateMachineAnswered = "", false
+			err = &PluginPanicError{
+				Plugin: p.Config.Name,
+				Val:    r,
+				Stack:  debug.Stack(),
+			}
+		}
+	}()
 	if !followup {
 		p.SM.Reset(in)
 	}
-	return p.run(in)
+	resp, stateMachineAnswered = p.run(in)
+	return resp, stateMachineAnswered, nil
 }
 
 // GetMemory retrieves a memory for a given key. Accessing that Memory's value
@@ -147,22 +321,22 @@ func (p *Plugin) GetMemory(in *Msg, k string) Memory {
 		if k == StateKey || k == stateEnteredKey {
 			q := `SELECT value FROM states
 			      WHERE userid=$1 AND key=$2 AND pluginname=$3`
-			err = p.DB.Get(&buf, q, in.User.ID, k, p.Config.Name)
+			err = p.Conn(in).Get(&buf, q, in.User.ID, k, p.Config.Name)
 		} else {
 			q := `SELECT value FROM states
 			      WHERE userid=$1 AND key=$2`
-			err = p.DB.Get(&buf, q, in.User.ID, k)
+			err = p.Conn(in).Get(&buf, q, in.User.ID, k)
 		}
 	} else {
 		if k == StateKey || k == stateEnteredKey {
 			q := `SELECT value FROM states
 			      WHERE flexid=$1 AND flexidtype=$2 AND key=$3 AND pluginname=$4`
-			err = p.DB.Get(&buf, q, in.User.FlexID,
+			err = p.Conn(in).Get(&buf, q, in.User.FlexID,
 				in.User.FlexIDType, k, p.Config.Name)
 		} else {
 			q := `SELECT value FROM states
 			      WHERE flexid=$1 AND flexidtype=$2 AND key=$3`
-			err = p.DB.Get(&buf, q, in.User.FlexID,
+			err = p.Conn(in).Get(&buf, q, in.User.FlexID,
 				in.User.FlexIDType, k)
 		}
 	}
@@ -198,14 +372,14 @@ func (p *Plugin) SetMemory(in *Msg, k string, v interface{}) {
 		      VALUES ($1, $2, $3, $4)
 		      ON CONFLICT (userid, pluginname, key)
 		      DO UPDATE SET value=$2`
-		_, err = p.DB.Exec(q, k, b, p.Config.Name, in.User.ID)
+		_, err = p.Conn(in).Exec(q, k, b, p.Config.Name, in.User.ID)
 	} else {
 		q := `INSERT INTO states
 		      (key, value, pluginname, flexid, flexidtype)
 		      VALUES ($1, $2, $3, $4, $5)
 		      ON CONFLICT (flexid, flexidtype, pluginname, key)
 		      DO UPDATE SET value=$2`
-		_, err = p.DB.Exec(q, k, b, p.Config.Name, in.User.FlexID,
+		_, err = p.Conn(in).Exec(q, k, b, p.Config.Name, in.User.FlexID,
 			in.User.FlexIDType)
 	}
 	if err != nil {
@@ -222,12 +396,12 @@ func (p *Plugin) DeleteMemory(in *Msg, k string) {
 	if in.User.ID > 0 {
 		q := `DELETE FROM states
 		      WHERE userid=$1 AND pluginname=$2 AND key=$3`
-		_, err = p.DB.Exec(q, in.User.ID, p.Config.Name, k)
+		_, err = p.Conn(in).Exec(q, in.User.ID, p.Config.Name, k)
 	} else {
 		q := `DELETE FROM states
 		      WHERE flexid=$1 AND flexidtype=$2 AND pluginname=$3
 		      AND key=$4`
-		_, err = p.DB.Exec(q, in.User.FlexID, in.User.FlexIDType,
+		_, err = p.Conn(in).Exec(q, in.User.FlexID, in.User.FlexIDType,
 			p.Config.Name, k)
 	}
 	if err != nil {
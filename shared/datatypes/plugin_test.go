@@ -0,0 +1,33 @@
+package dt
+
+import "testing"
+
+func TestCallPluginRecoversPanic(t *testing.T) {
+	p := &Plugin{
+		Config: PluginConfig{Name: "test"},
+		Keywords: &Keywords{Dict: map[string]KeywordFn{
+			"I_ok":   func(in *Msg) string { return "ok" },
+			"I_boom": func(in *Msg) string { panic("boom") },
+		}},
+	}
+	in := &Msg{StructuredInput: &StructuredInput{Intents: []string{"ok"}}}
+	resp, _, err := CallPlugin(p, in, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != "ok" {
+		t.Fatalf("expected %q, got %q", "ok", resp)
+	}
+	in.StructuredInput.Intents = []string{"boom"}
+	resp, _, err = CallPlugin(p, in, true)
+	e, ok := err.(*PluginPanicError)
+	if !ok {
+		t.Fatal("expected *PluginPanicError, got", err)
+	}
+	if e.Plugin != "test" || e.Val != "boom" || len(e.Stack) == 0 {
+		t.Fatalf("unexpected panic error %+v", e)
+	}
+	if len(resp) > 0 {
+		t.Fatal("expected no response, got", resp)
+	}
+}
@@ -0,0 +1,305 @@
+package dt
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecurrenceFreq is how often a Recurrence repeats. Its values match those of
+// cal/driver.RecurringFreq.
+type RecurrenceFreq int
+
+// Define options for recurrence frequencies.
+const (
+	FreqOnce RecurrenceFreq = iota
+	FreqDaily
+	FreqWeekly
+	FreqMonthly
+	FreqYearly
+)
+
+// maxRecurrencePeriods is the most periods, e.g. days for a daily recurrence,
+// searched for the next occurrence.
+const maxRecurrencePeriods = 1000
+
+// rruleTime is the format of UNTIL within an RRULE.
+const rruleTime = "20060102T150405Z"
+
+// ErrInvalidRRule is returned when parsing an RRULE which doesn't describe a
+// recurrence.
+var ErrInvalidRRule = errors.New("invalid rrule")
+
+// rruleFreqs maps the FREQ of an RRULE to a RecurrenceFreq.
+var rruleFreqs = map[string]RecurrenceFreq{
+	"DAILY":   FreqDaily,
+	"WEEKLY":  FreqWeekly,
+	"MONTHLY": FreqMonthly,
+	"YEARLY":  FreqYearly,
+}
+
+// rruleDays maps the days of an RRULE's BYDAY to weekdays.
+var rruleDays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Recurrence describes when a repeating event occurs. It's modeled on the
+// RRULE of RFC 5545 and is stored as one, e.g. "every Tuesday at 9" is
+// "FREQ=WEEKLY;BYDAY=TU;BYHOUR=9;BYMINUTE=0". Recurrences are usually parsed
+// from natural language by timeparse.ParseRecurrence.
+type Recurrence struct {
+	Freq RecurrenceFreq
+
+	// Interval is the number of periods of Freq between occurrences,
+	// e.g. 2 for "every other week". Zero is treated as 1.
+	Interval int
+
+	// ByDay holds the days of the week on which the event occurs, e.g.
+	// Tuesday and Thursday for "every Tuesday and Thursday".
+	ByDay []time.Weekday
+
+	// BySetPos picks one of the ByDay days in each month, e.g. 1 for "the
+	// first Monday of each month" or -1 for the last.
+	BySetPos int
+
+	// ByMonthDay is the day of the month on which a monthly event occurs,
+	// e.g. 15 for "the 15th of every month".
+	ByMonthDay int
+
+	// ByHour and ByMinute are the time of day at which the event occurs.
+	ByHour   int
+	ByMinute int
+
+	// Until is the time after which the event no longer occurs. The event
+	// continues indefinitely if it's nil.
+	Until *time.Time
+}
+
+// Next returns the first occurrence strictly after a given time, reporting
+// false if there's none before Until. Times are in after's location. Where the
+// recurrence doesn't say, the day of the week, month or year follows after,
+// so a weekly recurrence without ByDay falls on after's weekday. The period
+// containing after, e.g. its week, is taken to be one in which the event
+// occurs, which matters only when Interval is greater than 1.
+func (r *Recurrence) Next(after time.Time) (time.Time, bool) {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	for p := 0; p < maxRecurrencePeriods; p += interval {
+		for _, t := range r.occurrences(after, p) {
+			if !t.After(after) {
+				continue
+			}
+			if r.Until != nil && t.After(*r.Until) {
+				return time.Time{}, false
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// occurrences returns the times at which the event occurs in order within the
+// period p periods after the one containing t.
+func (r *Recurrence) occurrences(t time.Time, p int) []time.Time {
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, r.ByHour, r.ByMinute, 0, 0,
+			t.Location())
+	}
+	var ts []time.Time
+	switch r.Freq {
+	case FreqDaily:
+		day := at(t.Year(), t.Month(), t.Day()+p)
+		if len(r.ByDay) == 0 || r.onDay(day.Weekday()) {
+			ts = append(ts, day)
+		}
+	case FreqWeekly:
+		// Weeks begin on Monday.
+		monday := t.Day() - (int(t.Weekday())+6)%7 + 7*p
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{t.Weekday()}
+		}
+		for _, wd := range days {
+			d := monday + (int(wd)+6)%7
+			ts = append(ts, at(t.Year(), t.Month(), d))
+		}
+		sort.Sort(byTime(ts))
+	case FreqMonthly:
+		first := at(t.Year(), t.Month()+time.Month(p), 1)
+		y, m := first.Year(), first.Month()
+		last := first.AddDate(0, 1, -1).Day()
+		switch {
+		case len(r.ByDay) > 0:
+			var days []time.Time
+			for d := 1; d <= last; d++ {
+				day := at(y, m, d)
+				if r.onDay(day.Weekday()) {
+					days = append(days, day)
+				}
+			}
+			switch {
+			case r.BySetPos > 0 && r.BySetPos <= len(days):
+				ts = append(ts, days[r.BySetPos-1])
+			case r.BySetPos < 0 && -r.BySetPos <= len(days):
+				ts = append(ts, days[len(days)+r.BySetPos])
+			case r.BySetPos == 0:
+				ts = days
+			}
+		default:
+			d := r.ByMonthDay
+			if d == 0 {
+				d = t.Day()
+			}
+			// Months too short for the day are skipped.
+			if d <= last {
+				ts = append(ts, at(y, m, d))
+			}
+		}
+	case FreqYearly:
+		day := at(t.Year()+p, t.Month(), t.Day())
+		// Skip February 29th in other years.
+		if day.Day() == t.Day() {
+			ts = append(ts, day)
+		}
+	}
+	return ts
+}
+
+// onDay reports whether a weekday is among ByDay.
+func (r *Recurrence) onDay(wd time.Weekday) bool {
+	for _, d := range r.ByDay {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the recurrence as an RRULE, e.g.
+// "FREQ=WEEKLY;BYDAY=TU;BYHOUR=9;BYMINUTE=0".
+func (r *Recurrence) String() string {
+	var parts []string
+	for name, f := range rruleFreqs {
+		if f == r.Freq {
+			parts = append(parts, "FREQ="+name)
+		}
+	}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, wd := range r.ByDay {
+			days = append(days, strings.ToUpper(wd.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.BySetPos != 0 {
+		parts = append(parts, "BYSETPOS="+strconv.Itoa(r.BySetPos))
+	}
+	if r.ByMonthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(r.ByMonthDay))
+	}
+	parts = append(parts, "BYHOUR="+strconv.Itoa(r.ByHour),
+		"BYMINUTE="+strconv.Itoa(r.ByMinute))
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(rruleTime))
+	}
+	return strings.Join(parts, ";")
+}
+
+// ParseRRule parses a recurrence from an RRULE, such as one returned by
+// Recurrence.String. Only the parts used by Recurrence are supported.
+func ParseRRule(rrule string) (*Recurrence, error) {
+	r := &Recurrence{}
+	for _, part := range strings.Split(rrule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidRRule
+		}
+		var err error
+		switch kv[0] {
+		case "FREQ":
+			f, ok := rruleFreqs[kv[1]]
+			if !ok {
+				return nil, fmt.Errorf("unrecognized freq: %s", kv[1])
+			}
+			r.Freq = f
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(kv[1])
+		case "BYDAY":
+			for _, day := range strings.Split(kv[1], ",") {
+				wd, ok := rruleDays[day]
+				if !ok {
+					return nil, fmt.Errorf(
+						"unrecognized day: %s", day)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYSETPOS":
+			r.BySetPos, err = strconv.Atoi(kv[1])
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = strconv.Atoi(kv[1])
+		case "BYHOUR":
+			r.ByHour, err = strconv.Atoi(kv[1])
+		case "BYMINUTE":
+			r.ByMinute, err = strconv.Atoi(kv[1])
+		case "UNTIL":
+			var until time.Time
+			until, err = time.Parse(rruleTime, kv[1])
+			r.Until = &until
+		default:
+			return nil, fmt.Errorf("unsupported rrule part: %s", kv[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Freq == FreqOnce {
+		return nil, ErrInvalidRRule
+	}
+	return r, nil
+}
+
+// Scan converts an RRULE stored in the database to a Recurrence.
+func (r *Recurrence) Scan(src interface{}) error {
+	var rrule string
+	switch v := src.(type) {
+	case []byte:
+		rrule = string(v)
+	case string:
+		rrule = v
+	default:
+		return errors.New("scan source was not a string")
+	}
+	tmp, err := ParseRRule(rrule)
+	if err != nil {
+		return err
+	}
+	*r = *tmp
+	return nil
+}
+
+// Value converts a Recurrence to an RRULE for storage in the database.
+func (r Recurrence) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+// byTime implements sort.Interface to order times from earliest to latest.
+type byTime []time.Time
+
+func (t byTime) Len() int           { return len(t) }
+func (t byTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byTime) Less(i, j int) bool { return t[i].Before(t[j]) }
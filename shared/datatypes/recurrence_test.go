@@ -0,0 +1,87 @@
+package dt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceNext(t *testing.T) {
+	// A Wednesday morning.
+	a := time.Date(2016, 3, 9, 10, 0, 0, 0, time.UTC)
+	at := func(m time.Month, d, h int) time.Time {
+		return time.Date(2016, m, d, h, 0, 0, 0, time.UTC)
+	}
+	until := at(3, 20, 0)
+	tests := []struct {
+		rrule string
+		exp   []time.Time
+	}{
+		{"FREQ=DAILY;BYHOUR=12;BYMINUTE=0",
+			[]time.Time{at(3, 9, 12), at(3, 10, 12)}},
+		{"FREQ=DAILY;BYHOUR=9;BYMINUTE=0",
+			[]time.Time{at(3, 10, 9), at(3, 11, 9)}},
+		{"FREQ=WEEKLY;BYDAY=TU,TH;BYHOUR=9;BYMINUTE=0",
+			[]time.Time{at(3, 10, 9), at(3, 15, 9), at(3, 17, 9)}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=FR;BYHOUR=9;BYMINUTE=0",
+			[]time.Time{at(3, 11, 9), at(3, 25, 9)}},
+		{"FREQ=MONTHLY;BYDAY=MO;BYSETPOS=1;BYHOUR=9;BYMINUTE=0",
+			[]time.Time{at(4, 4, 9), at(5, 2, 9)}},
+		{"FREQ=MONTHLY;BYDAY=FR;BYSETPOS=-1;BYHOUR=9;BYMINUTE=0",
+			[]time.Time{at(3, 25, 9), at(4, 29, 9)}},
+		{"FREQ=MONTHLY;BYMONTHDAY=31;BYHOUR=9;BYMINUTE=0",
+			[]time.Time{at(3, 31, 9), at(5, 31, 9)}},
+		{"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;BYHOUR=8;BYMINUTE=0",
+			[]time.Time{at(3, 10, 8), at(3, 11, 8), at(3, 14, 8)}},
+		{"FREQ=WEEKLY;BYDAY=FR;BYHOUR=9;BYMINUTE=0;UNTIL=" +
+			until.Format(rruleTime),
+			[]time.Time{at(3, 11, 9), at(3, 18, 9)}},
+	}
+	for _, test := range tests {
+		r, err := ParseRRule(test.rrule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.String() != test.rrule {
+			t.Fatalf("expected %s, got %s", test.rrule, r)
+		}
+		next := a
+		for _, exp := range test.exp {
+			var ok bool
+			next, ok = r.Next(next)
+			if !ok || !next.Equal(exp) {
+				t.Fatalf("%s: expected %s, got %s", test.rrule, exp,
+					next)
+			}
+		}
+		if r.Until == nil {
+			continue
+		}
+		if next, ok := r.Next(next); ok {
+			t.Fatalf("%s: expected no more, got %s", test.rrule, next)
+		}
+	}
+	if _, err := ParseRRule("BYHOUR=9"); err != ErrInvalidRRule {
+		t.Fatal("expected ErrInvalidRRule, got", err)
+	}
+}
+
+func TestScheduledEventNext(t *testing.T) {
+	r, err := ParseRRule("FREQ=DAILY;BYHOUR=9;BYMINUTE=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := &ScheduledEvent{
+		SendAt:     time.Date(2016, 3, 9, 9, 0, 0, 0, time.UTC),
+		Recurrence: r,
+	}
+	// Occurrences missed while Abot was down are skipped.
+	now := time.Date(2016, 3, 12, 11, 0, 0, 0, time.UTC)
+	exp := time.Date(2016, 3, 13, 9, 0, 0, 0, time.UTC)
+	if next, ok := evt.Next(now); !ok || !next.Equal(exp) {
+		t.Fatalf("expected %s, got %s", exp, next)
+	}
+	evt.Recurrence = nil
+	if _, ok := evt.Next(now); ok {
+		t.Fatal("expected one-off event not to recur")
+	}
+}
@@ -0,0 +1,27 @@
+package dt
+
+// ReferentKind identifies what a Referent is.
+type ReferentKind string
+
+// ReferentKinds tracked by Abot.
+const (
+	ReferentObject    ReferentKind = "object"
+	ReferentPlace     ReferentKind = "place"
+	ReferentSelection ReferentKind = "selection"
+)
+
+// Referent is something mentioned in an earlier message to which the user may
+// refer again, e.g. as "it", "that one" or "the same place". Referents are
+// objects mentioned by the user, places, and items selected in Abot's replies
+// (see Response.Select).
+type Referent struct {
+	Kind ReferentKind
+	Name string
+
+	// Place is set for referents of the ReferentPlace kind.
+	Place *Place `json:",omitempty"`
+
+	// Phrase is the words which referred to the referent, e.g. "that one".
+	// It's set only once the referent is resolved from context.
+	Phrase string `json:",omitempty"`
+}
@@ -0,0 +1,121 @@
+package dt
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Response is Abot's reply to a user's message. In addition to the
+// user-presentable Sentence, a Response may carry quick-reply options, cards
+// and a machine-readable payload. Channels which can't display rich content,
+// like SMS, degrade the Response to plain text (see Text).
+type Response struct {
+	Sentence string
+
+	// Options are quick replies offered to the user, e.g. "Yes" and "No".
+	Options []string `json:",omitempty"`
+
+	// Cards are displayed beneath the Sentence, such as a list of
+	// restaurants with their images and websites.
+	Cards []ResponseCard `json:",omitempty"`
+
+	// Payload holds machine-readable data for clients, which isn't shown
+	// to the user.
+	Payload map[string]interface{} `json:",omitempty"`
+
+	// Selected holds the items chosen for the user in this response, e.g.
+	// the restaurant recommended, to which they may refer in later
+	// messages as "it" or "that one".
+	Selected []string `json:",omitempty"`
+
+	// SessionToken proves the client owns the web session to which Abot
+	// replied. It's required to open the session's websocket, through
+	// which pushed messages are delivered (see Plugin.Push), and is set
+	// only for messages from web sessions.
+	SessionToken string `json:",omitempty"`
+}
+
+// ResponseCard is a rich item displayed as part of a Response.
+type ResponseCard struct {
+	Title string
+	Image string `json:",omitempty"`
+	Link  string `json:",omitempty"`
+}
+
+// AddOptions offers quick replies to the user.
+func (r *Response) AddOptions(opts ...string) {
+	r.Options = append(r.Options, opts...)
+}
+
+// AddCard displays a card to the user beneath the Sentence.
+func (r *Response) AddCard(title, image, link string) {
+	r.Cards = append(r.Cards, ResponseCard{
+		Title: title,
+		Image: image,
+		Link:  link,
+	})
+}
+
+// SetPayload adds machine-readable data to the response.
+func (r *Response) SetPayload(key string, val interface{}) {
+	if r.Payload == nil {
+		r.Payload = map[string]interface{}{}
+	}
+	r.Payload[key] = val
+}
+
+// Select records items chosen for the user, such as a recommended restaurant,
+// so the user may refer to them in later messages, e.g. "Book that one".
+func (r *Response) Select(items ...string) {
+	r.Selected = append(r.Selected, items...)
+}
+
+// Images returns the image URLs of each card, used by channels supporting
+// media attachments, like MMS.
+func (r *Response) Images() []string {
+	var imgs []string
+	for _, c := range r.Cards {
+		if len(c.Image) > 0 {
+			imgs = append(imgs, c.Image)
+		}
+	}
+	return imgs
+}
+
+// Text degrades the response to plain text, listing cards and numbered options
+// after the Sentence. The Payload is omitted.
+func (r *Response) Text() string {
+	lines := []string{r.Sentence}
+	for _, c := range r.Cards {
+		if len(c.Link) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", c.Title, c.Link))
+			continue
+		}
+		lines = append(lines, c.Title)
+	}
+	for i, opt := range r.Options {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, opt))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// tmplResponse renders a Response for HTML channels, like email.
+var tmplResponse = template.Must(template.New("response").Parse(
+	`<p>{{.Sentence}}</p>` +
+		`{{range .Cards}}<div>` +
+		`{{if .Image}}<img src="{{.Image}}" alt="{{.Title}}"><br>{{end}}` +
+		`{{if .Link}}<a href="{{.Link}}">{{.Title}}</a>` +
+		`{{else}}{{.Title}}{{end}}</div>{{end}}` +
+		`{{if .Options}}<ol>{{range .Options}}<li>{{.}}</li>{{end}}</ol>{{end}}`))
+
+// HTML renders the response as escaped HTML, suitable for email. The Payload is
+// omitted.
+func (r *Response) HTML() (string, error) {
+	buf := &bytes.Buffer{}
+	if err := tmplResponse.Execute(buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
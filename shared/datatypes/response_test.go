@@ -0,0 +1,35 @@
+package dt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResponseRender(t *testing.T) {
+	r := &Response{Sentence: "I found 2 restaurants."}
+	r.AddCard("Pizza <Place>", "http://example.com/p.png",
+		"http://example.com/p")
+	r.AddCard("Tacos", "", "")
+	r.AddOptions("Book a table", "Show more")
+	exp := "I found 2 restaurants.\n" +
+		"Pizza <Place>: http://example.com/p\n" +
+		"Tacos\n" +
+		"1. Book a table\n" +
+		"2. Show more"
+	if txt := r.Text(); txt != exp {
+		t.Fatalf("expected %q, got %q", exp, txt)
+	}
+	html, err := r.HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(html, "<Place>") {
+		t.Fatal("expected HTML to be escaped, got", html)
+	}
+	if !strings.Contains(html, "<li>Show more</li>") {
+		t.Fatal("expected options in HTML, got", html)
+	}
+	if imgs := r.Images(); len(imgs) != 1 {
+		t.Fatal("expected 1 image, got", len(imgs))
+	}
+}
@@ -2,18 +2,43 @@ package dt
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/itsabot/abot/shared/interface/sms"
 )
 
-// ScheduledEvent for Abot to send a message at some point in the future. No
-// time.Time is necessary in this struct because it's only created when it's
-// known to be time to send. See core/boot:NewServer().
+// ScheduledEvent for Abot to send a message at some point in the future. It's
+// only created when it's known to be time to send. See core/boot:NewServer().
 type ScheduledEvent struct {
 	ID         uint64
 	Content    string
 	FlexID     string
 	FlexIDType FlexIDType
+
+	// SendAt is when the event was due to be sent.
+	SendAt time.Time
+
+	// Recurrence describes when a recurring event is sent again. It's nil
+	// for events sent only once. See Plugin.ScheduleRecurring.
+	Recurrence *Recurrence
+}
+
+// Next returns when a recurring event should next be sent after it's sent at
+// now, reporting false if it's sent only once or its recurrence has ended.
+// Occurrences missed while Abot wasn't running are skipped.
+func (s *ScheduledEvent) Next(now time.Time) (time.Time, bool) {
+	if s.Recurrence == nil {
+		return time.Time{}, false
+	}
+	next := s.SendAt
+	for !next.After(now) {
+		var ok bool
+		next, ok = s.Recurrence.Next(next)
+		if !ok {
+			return time.Time{}, false
+		}
+	}
+	return next, true
 }
 
 // Send a scheduled event. Currently only phones are supported.
@@ -1,6 +1,9 @@
 package dt
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+)
 
 // StateKey is a reserved key in the state of a plugin that tracks which state
 // the plugin is currently in for each user.
@@ -32,7 +35,8 @@ type State struct {
 	// OnEntry preprocesses and asks the user for information. If you need
 	// to do something when the state begins, like run a search or hit an
 	// endpoint, do that within the OnEntry function, since it's only called
-	// once.
+	// once. Rich content, like quick-reply options and cards, may be added
+	// to the message's Response.
 	OnEntry func(*Msg) string
 
 	// OnInput sets the category in the cache/DB. Note that if invalid, this
@@ -52,6 +56,15 @@ type State struct {
 	// hit this state's OnInput function again.
 	Complete func(*Msg) (bool, string)
 
+	// OnEntryCtx, OnInputCtx and CompleteCtx are context-aware variants of
+	// OnEntry, OnInput and Complete, which are used instead when set. The
+	// context is canceled when the user's request ends or the plugin's
+	// deadline passes (see PluginConfig.Timeout), so calls to slow APIs
+	// should be made with it.
+	OnEntryCtx  func(context.Context, *Msg) string
+	OnInputCtx  func(context.Context, *Msg)
+	CompleteCtx func(context.Context, *Msg) (bool, string)
+
 	// SkipIfComplete will run Complete() on entry. If Complete() == true,
 	// then it'll skip to the next state.
 	SkipIfComplete bool
@@ -75,6 +88,32 @@ type State struct {
 // state is valid.
 type EventRequest int
 
+// onEntry runs the state's OnEntryCtx function if set, or otherwise OnEntry.
+func (s State) onEntry(in *Msg) string {
+	if s.OnEntryCtx != nil {
+		return s.OnEntryCtx(in.Context(), in)
+	}
+	return s.OnEntry(in)
+}
+
+// onInput runs the state's OnInputCtx function if set, or otherwise OnInput.
+func (s State) onInput(in *Msg) {
+	if s.OnInputCtx != nil {
+		s.OnInputCtx(in.Context(), in)
+		return
+	}
+	s.OnInput(in)
+}
+
+// complete runs the state's CompleteCtx function if set, or otherwise
+// Complete.
+func (s State) complete(in *Msg) (bool, string) {
+	if s.CompleteCtx != nil {
+		return s.CompleteCtx(in.Context(), in)
+	}
+	return s.Complete(in)
+}
+
 // NewStateMachine initializes a stateMachine to its starting state.
 func NewStateMachine(p *Plugin) *StateMachine {
 	sm := StateMachine{
@@ -113,42 +152,42 @@ func (sm *StateMachine) LoadState(in *Msg) {
 		return
 	}
 
-	// Using upsert to either insert and return a value or on conflict to
-	// update and return a value doesn't work, leading to this longer form.
-	// Could it be a Postgres bug? This can and should be optimized.
+	// Insert a starting state if none exists, then load the current
+	// state. Conflicts are ignored rather than caught as errors, since an
+	// error would abort the transaction of the user's turn.
+	db := sm.plugin.Conn(in)
 	if in.User.ID > 0 {
 		q := `INSERT INTO states
-		      (key, userid, value, pluginname) VALUES ($1, $2, $3, $4)`
-		_, err = sm.plugin.DB.Exec(q, StateKey, in.User.ID, tmp,
+		      (key, userid, value, pluginname) VALUES ($1, $2, $3, $4)
+		      ON CONFLICT (userid, pluginname, key) DO NOTHING`
+		_, err = db.Exec(q, StateKey, in.User.ID, tmp,
 			sm.plugin.Config.Name)
 	} else {
 		q := `INSERT INTO states
-		      (key, flexid, flexidtype, value, pluginname) VALUES ($1, $2, $3, $4, $5)`
-		_, err = sm.plugin.DB.Exec(q, StateKey, in.User.FlexID,
+		      (key, flexid, flexidtype, value, pluginname) VALUES ($1, $2, $3, $4, $5)
+		      ON CONFLICT (flexid, flexidtype, pluginname, key) DO NOTHING`
+		_, err = db.Exec(q, StateKey, in.User.FlexID,
 			in.User.FlexIDType, tmp, sm.plugin.Config.Name)
 	}
 	if err != nil {
-		if err.Error() != `pq: duplicate key value violates unique constraint "states_userid_pkgname_key_key"` &&
-			err.Error() != `pq: duplicate key value violates unique constraint "states_flexid_flexidtype_pluginname_key_key"` {
-			sm.plugin.Log.Info("could not insert value into states.", err)
-			sm.state = 0
-			return
-		}
-		if in.User.ID > 0 {
-			q := `SELECT value FROM states
-			      WHERE userid=$1 AND key=$2 AND pluginname=$3`
-			err = sm.plugin.DB.Get(&tmp, q, in.User.ID, StateKey,
-				sm.plugin.Config.Name)
-		} else {
-			q := `SELECT value FROM states
-			      WHERE flexid=$1 AND flexidtype=$2 AND key=$3 AND pluginname=$4`
-			err = sm.plugin.DB.Get(&tmp, q, in.User.FlexID,
-				in.User.FlexIDType, StateKey, sm.plugin.Config.Name)
-		}
-		if err != nil {
-			sm.plugin.Log.Info("failed to get value from state.", err)
-			return
-		}
+		sm.plugin.Log.Info("could not insert value into states.", err)
+		sm.state = 0
+		return
+	}
+	if in.User.ID > 0 {
+		q := `SELECT value FROM states
+		      WHERE userid=$1 AND key=$2 AND pluginname=$3`
+		err = db.Get(&tmp, q, in.User.ID, StateKey,
+			sm.plugin.Config.Name)
+	} else {
+		q := `SELECT value FROM states
+		      WHERE flexid=$1 AND flexidtype=$2 AND key=$3 AND pluginname=$4`
+		err = db.Get(&tmp, q, in.User.FlexID,
+			in.User.FlexIDType, StateKey, sm.plugin.Config.Name)
+	}
+	if err != nil {
+		sm.plugin.Log.Info("failed to get value from state.", err)
+		return
 	}
 	var val int
 	if err = json.Unmarshal(tmp, &val); err != nil {
@@ -195,7 +234,7 @@ func (sm *StateMachine) Next(in *Msg) (response string) {
 	h := sm.Handlers[sm.state]
 	if !sm.stateEntered {
 		sm.plugin.Log.Debug("state was not entered")
-		done, _ := h.Complete(in)
+		done, _ := h.complete(in)
 		if h.SkipIfComplete {
 			if done {
 				sm.plugin.Log.Debug("state was complete. moving on")
@@ -210,7 +249,7 @@ func (sm *StateMachine) Next(in *Msg) (response string) {
 		// If this is the final state and complete on entry, we'll
 		// reset the state machine. This fixes the "forever trapped"
 		// loop of being in a plugin's finished state machine.
-		resp := h.OnEntry(in)
+		resp := h.onEntry(in)
 		if sm.state+1 >= len(sm.Handlers) && done {
 			sm.Reset(in)
 		}
@@ -220,8 +259,8 @@ func (sm *StateMachine) Next(in *Msg) (response string) {
 	// State was already entered, so process the input and check for
 	// completion
 	sm.plugin.Log.Debug("state was already entered")
-	h.OnInput(in)
-	done, str := h.Complete(in)
+	h.onInput(in)
+	done, str := h.complete(in)
 	if done {
 		sm.plugin.Log.Debug("state is done. going to next")
 		sm.state++
@@ -232,7 +271,7 @@ func (sm *StateMachine) Next(in *Msg) (response string) {
 			return sm.Next(in)
 		}
 		sm.setEntered(in)
-		str = sm.Handlers[sm.state].OnEntry(in)
+		str = sm.Handlers[sm.state].onEntry(in)
 		sm.plugin.Log.Debug("going to next state", sm.state)
 		return str
 	}
@@ -284,19 +323,19 @@ func (sm *StateMachine) SetState(in *Msg, label string) string {
 		sm.stateEntered = false
 		sm.plugin.SetMemory(in, StateKey, desiredState)
 		sm.plugin.SetMemory(in, stateEnteredKey, false)
-		return sm.Handlers[desiredState].OnEntry(in)
+		return sm.Handlers[desiredState].onEntry(in)
 	}
 
 	// If we're in a state before the desired state, go forward only as far
 	// as we're allowed by the Complete guards.
 	for s := sm.state; s < desiredState; s++ {
-		ok, _ := sm.Handlers[s].Complete(in)
+		ok, _ := sm.Handlers[s].complete(in)
 		if !ok {
 			sm.state = s
 			sm.stateEntered = false
 			sm.plugin.SetMemory(in, StateKey, s)
 			sm.plugin.SetMemory(in, stateEnteredKey, false)
-			return sm.Handlers[s].OnEntry(in)
+			return sm.Handlers[s].onEntry(in)
 		}
 	}
 
@@ -307,7 +346,7 @@ func (sm *StateMachine) SetState(in *Msg, label string) string {
 	sm.stateEntered = false
 	sm.plugin.SetMemory(in, StateKey, desiredState)
 	sm.plugin.SetMemory(in, stateEnteredKey, false)
-	return sm.Handlers[desiredState].OnEntry(in)
+	return sm.Handlers[desiredState].onEntry(in)
 }
 
 // ReplayState returns you to the current state's OnEntry function. This is
@@ -329,5 +368,5 @@ func (sm *StateMachine) SetState(in *Msg, label string) string {
 func (sm *StateMachine) ReplayState(in *Msg) string {
 	sm.LoadState(in)
 	sm.plugin.Log.Debug("replaying state", sm.state)
-	return sm.Handlers[sm.state].OnEntry(in)
+	return sm.Handlers[sm.state].onEntry(in)
 }
@@ -1,6 +1,9 @@
 package dt
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // StructuredInput is generated by Abot and sent to plugins as a helper tool.
 // Additional fields should be added, covering Times, Places, etc. to make
@@ -12,10 +15,141 @@ type StructuredInput struct {
 	People   []Person
 	Times    []time.Time
 
-	// TODO
-	// Places []string
+	// IntentScores maps each of the Intents to the probability assigned by
+	// the Bayesian classifier which recognized it. Abot uses these scores
+	// to rank plugins when routing a message.
+	IntentScores map[string]float64
+
+	// IntentCandidates holds every intent considered by the plugins'
+	// classifiers, most probable first, including those whose probability
+	// fell below the plugin's threshold (see PluginConfig.IntentThreshold)
+	// and were left out of Intents.
+	IntentCandidates []IntentCandidate
+
+	// Resolved maps each word marked as both a Command and an Object to
+	// the part it plays within the sentence, as determined by Abot's
+	// part-of-speech tagger. Such words remain in both Commands and
+	// Objects, but routes which use a word as the part it doesn't play,
+	// e.g. "book" as a Command in "Put the book on the table", are
+	// skipped. See ActsAs.
+	Resolved map[string]SIT
+
+	// Negated marks each Command and Object within the scope of a
+	// negation, e.g. "order" and "pizza" in "Don't order pizza". Words
+	// used both within and outside the scope of a negation aren't marked.
+	Negated map[string]bool
+
+	// Places holds the cities, street addresses and saved addresses, such
+	// as "home", mentioned in the message.
+	Places []Place
+
+	// Entities holds the typed values found in the message, such as
+	// quantities, amounts of money, email addresses, phone numbers and
+	// URLs, ordered by their position.
+	Entities []Entity
+
+	// Referents holds the objects, places and selected items to which the
+	// message refers by words such as "it" or "that one", as resolved from
+	// earlier messages. Resolved objects and places are also added to
+	// Objects and Places, and marked in FromContext.
+	Referents []Referent
+
+	// FromContext marks each Object, Place and Person, by name, which
+	// wasn't mentioned in the message but was resolved from an earlier one,
+	// e.g. from "it" or "her".
+	FromContext map[string]bool
+
+	// TimeAnchor is the time discussed earlier from which the Times of a
+	// follow-up, such as "an hour later" or "same time next week", were
+	// resolved. It's nil unless the Times were resolved that way.
+	TimeAnchor *time.Time
+
+	// TimeRanges holds the ranges of time described by the message, such
+	// as "from 3 to 5pm", "this weekend" or "for two hours". The End of
+	// each range is exclusive, so "Monday" ends at midnight on Tuesday.
+	TimeRanges []TimeRange
+}
+
+// IntentCancel is added to a message's Intents when the user calls off their
+// request, e.g. "Never mind" or "Forget it". It's routed to the plugin with
+// which the user was conversing, if that plugin handles the intent.
+const IntentCancel = "cancel"
+
+// IsNegated reports whether a Command or Object lies within the scope of a
+// negation.
+func (s *StructuredInput) IsNegated(word string) bool {
+	return s.Negated[strings.ToLower(word)]
+}
+
+// ActsAs reports whether a Command or Object may play the given part within
+// the sentence. Words which the part-of-speech tagger resolved to the other
+// part don't.
+func (s *StructuredInput) ActsAs(word string, sit SIT) bool {
+	r, ok := s.Resolved[strings.ToLower(word)]
+	return !ok || r == sit
+}
+
+// IsFromContext reports whether an Object, Place or Person was resolved from
+// an earlier message, rather than mentioned in this one.
+func (s *StructuredInput) IsFromContext(name string) bool {
+	return s.FromContext[strings.ToLower(name)]
+}
+
+// Negative reports whether every Command in the message is negated, as in
+// "Don't order pizza". Abot won't trigger a plugin by its intents or
+// keywords in response to a negative message.
+func (s *StructuredInput) Negative() bool {
+	if len(s.Commands) == 0 {
+		return false
+	}
+	for _, c := range s.Commands {
+		if !s.IsNegated(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Canceled reports whether the user called off their request (see
+// IntentCancel).
+func (s *StructuredInput) Canceled() bool {
+	for _, intent := range s.Intents {
+		if intent == IntentCancel {
+			return true
+		}
+	}
+	return false
+}
+
+// IntentCandidate is an intent which a plugin's classifier considered for a
+// message, with the probability it assigned.
+type IntentCandidate struct {
+	Intent      string
+	Plugin      string
+	Probability float64
+}
+
+// Confidence returns the highest probability assigned to an intent by any
+// plugin's classifier, or 0 if no classifier considered it. Since candidates
+// below the threshold are included, plugins may use this to ask a user to
+// confirm an intent of which Abot is unsure.
+func (s *StructuredInput) Confidence(intent string) float64 {
+	var p float64
+	for _, c := range s.IntentCandidates {
+		if c.Intent == intent && c.Probability > p {
+			p = c.Probability
+		}
+	}
+	return p
 }
 
 // SIT is a Structured Input Type. It corresponds to either a Command or an
 // Object with additional Structured Input Types to be added later.
 type SIT int
+
+// SITs recognized by Abot.
+const (
+	SITInvalid SIT = iota
+	SITCommand
+	SITObject
+)
@@ -0,0 +1,27 @@
+// Package snowball provides Snowball stemmers for each of the languages Abot
+// understands, identified by their ISO 639-1 codes, e.g. "es".
+package snowball
+
+import "github.com/dchest/stemmer/porter2"
+
+// Stemmer reduces a word to its stem, so different forms of the same word,
+// e.g. "book" and "booking", are recognized as one.
+type Stemmer interface {
+	Stem(word string) string
+}
+
+// English is the Porter2 stemmer for English.
+var English Stemmer = porter2.Stemmer
+
+// Spanish is the Snowball stemmer for Spanish.
+var Spanish Stemmer = spanishStemmer{}
+
+// For returns the stemmer for a language by its ISO 639-1 code. Languages
+// without a stemmer use English.
+func For(lang string) Stemmer {
+	switch lang {
+	case "es":
+		return Spanish
+	}
+	return English
+}
@@ -0,0 +1,318 @@
+package snowball
+
+import "strings"
+
+// spanishStemmer implements the Snowball stemming algorithm for Spanish. See
+// http://snowball.tartarus.org/algorithms/spanish/stemmer.html
+type spanishStemmer struct{}
+
+var spanishStep0Suffixes = []string{
+	"selas", "selos", "sela", "selo", "las", "les", "los", "nos", "me",
+	"se", "la", "le", "lo",
+}
+
+// spanishStep0Endings are the verb endings which may precede an attached
+// pronoun, mapped to their unaccented forms.
+var spanishStep0Endings = []struct {
+	ending, replace string
+}{
+	{"iéndo", "iendo"}, {"iendo", "iendo"}, {"yendo", "yendo"},
+	{"ándo", "ando"}, {"ando", "ando"}, {"ár", "ar"}, {"ér", "er"},
+	{"ír", "ir"}, {"ar", "ar"}, {"er", "er"}, {"ir", "ir"},
+}
+
+// Step 1 suffixes are grouped by the action taken when they're found.
+const (
+	spanishDelete = iota
+	spanishDeleteIC
+	spanishLog
+	spanishU
+	spanishEnte
+	spanishAmente
+	spanishMente
+	spanishIdad
+	spanishIv
+)
+
+var spanishStep1Suffixes = map[string]int{
+	"anza": spanishDelete, "anzas": spanishDelete, "ico": spanishDelete,
+	"ica": spanishDelete, "icos": spanishDelete, "icas": spanishDelete,
+	"ismo": spanishDelete, "ismos": spanishDelete, "able": spanishDelete,
+	"ables": spanishDelete, "ible": spanishDelete, "ibles": spanishDelete,
+	"ista": spanishDelete, "istas": spanishDelete, "oso": spanishDelete,
+	"osa": spanishDelete, "osos": spanishDelete, "osas": spanishDelete,
+	"amiento": spanishDelete, "amientos": spanishDelete,
+	"imiento": spanishDelete, "imientos": spanishDelete,
+
+	"adora": spanishDeleteIC, "ador": spanishDeleteIC,
+	"ación": spanishDeleteIC, "adoras": spanishDeleteIC,
+	"adores": spanishDeleteIC, "aciones": spanishDeleteIC,
+	"ante": spanishDeleteIC, "antes": spanishDeleteIC,
+	"ancia": spanishDeleteIC, "ancias": spanishDeleteIC,
+
+	"logía": spanishLog, "logías": spanishLog,
+	"ución": spanishU, "uciones": spanishU,
+	"encia": spanishEnte, "encias": spanishEnte,
+	"amente": spanishAmente,
+	"mente":  spanishMente,
+	"idad":   spanishIdad, "idades": spanishIdad,
+	"iva": spanishIv, "ivo": spanishIv, "ivas": spanishIv, "ivos": spanishIv,
+}
+
+var spanishStep2aSuffixes = []string{
+	"ya", "ye", "yan", "yen", "yeron", "yendo", "yo", "yó", "yas", "yes",
+	"yais", "yamos",
+}
+
+var spanishStep2bGU = []string{"en", "es", "éis", "emos"}
+
+var spanishStep2bSuffixes = []string{
+	"arían", "arías", "arán", "arás", "aríais", "aría", "aréis",
+	"aríamos", "aremos", "ará", "aré", "erían", "erías", "erán", "erás",
+	"eríais", "ería", "eréis", "eríamos", "eremos", "erá", "eré", "irían",
+	"irías", "irán", "irás", "iríais", "iría", "iréis", "iríamos",
+	"iremos", "irá", "iré", "aba", "ada", "ida", "ía", "ara", "iera", "ad",
+	"ed", "id", "ase", "iese", "aste", "iste", "an", "aban", "ían", "aran",
+	"ieran", "asen", "iesen", "aron", "ieron", "ado", "ido", "ando",
+	"iendo", "ió", "ar", "er", "ir", "as", "abas", "adas", "idas", "ías",
+	"aras", "ieras", "ases", "ieses", "ís", "áis", "abais", "íais",
+	"arais", "ierais", "aseis", "ieseis", "asteis", "isteis", "ados",
+	"idos", "amos", "ábamos", "íamos", "imos", "áramos", "iéramos",
+	"iésemos", "ásemos",
+}
+
+var spanishStep3Suffixes = []string{"os", "a", "o", "á", "í", "ó"}
+
+// Stem returns the stem of a Spanish word.
+func (spanishStemmer) Stem(word string) string {
+	w := []rune(strings.ToLower(word))
+	rv, r1, r2 := spanishRegions(w)
+	w = spanishStep0(w, rv)
+	var removed bool
+	w, removed = spanishStep1(w, r1, r2)
+	if !removed {
+		w, removed = spanishStep2a(w, rv)
+		if !removed {
+			w = spanishStep2b(w, rv)
+		}
+	}
+	w = spanishStep3(w, rv)
+	return removeAccents(string(w))
+}
+
+func isSpanishVowel(r rune) bool {
+	switch r {
+	case 'a', 'e', 'i', 'o', 'u', 'á', 'é', 'í', 'ó', 'ú', 'ü':
+		return true
+	}
+	return false
+}
+
+// spanishRegions returns the start of the RV, R1 and R2 regions of a word, as
+// defined by the Snowball algorithm. A region starting at len(w) is empty.
+func spanishRegions(w []rune) (rv, r1, r2 int) {
+	rv = len(w)
+	if len(w) >= 2 {
+		switch {
+		case !isSpanishVowel(w[1]):
+			// RV follows the next vowel after the second letter.
+			for i := 2; i < len(w); i++ {
+				if isSpanishVowel(w[i]) {
+					rv = i + 1
+					break
+				}
+			}
+		case isSpanishVowel(w[0]):
+			// RV follows the next consonant after the second
+			// letter.
+			for i := 2; i < len(w); i++ {
+				if !isSpanishVowel(w[i]) {
+					rv = i + 1
+					break
+				}
+			}
+		default:
+			rv = 3
+			if rv > len(w) {
+				rv = len(w)
+			}
+		}
+	}
+	r1 = regionAfter(w, 0)
+	r2 = regionAfter(w, r1)
+	return rv, r1, r2
+}
+
+// regionAfter returns the position following the first non-vowel which follows
+// a vowel at or after start.
+func regionAfter(w []rune, start int) int {
+	for i := start + 1; i < len(w); i++ {
+		if !isSpanishVowel(w[i]) && isSpanishVowel(w[i-1]) {
+			return i + 1
+		}
+	}
+	return len(w)
+}
+
+// longestSuffix returns the longest of the suffixes which ends the word.
+func longestSuffix(w []rune, suffixes []string) string {
+	var longest string
+	for _, s := range suffixes {
+		if len(s) > len(longest) && hasSuffix(w, s) {
+			longest = s
+		}
+	}
+	return longest
+}
+
+func hasSuffix(w []rune, s string) bool {
+	return strings.HasSuffix(string(w), s)
+}
+
+// in reports whether the suffix of the word lies within the region starting at
+// pos.
+func in(w []rune, suffix string, pos int) bool {
+	return len(w)-len([]rune(suffix)) >= pos
+}
+
+func trim(w []rune, suffix string) []rune {
+	return w[:len(w)-len([]rune(suffix))]
+}
+
+// spanishStep0 removes pronouns attached to verbs, e.g. "dámelo".
+func spanishStep0(w []rune, rv int) []rune {
+	suffix := longestSuffix(w, spanishStep0Suffixes)
+	if len(suffix) == 0 || !in(w, suffix, rv) {
+		return w
+	}
+	stem := trim(w, suffix)
+	for _, e := range spanishStep0Endings {
+		if !hasSuffix(stem, e.ending) || !in(stem, e.ending, rv) {
+			continue
+		}
+		if e.ending == "yendo" {
+			if !hasSuffix(trim(stem, e.ending), "u") {
+				return w
+			}
+			return stem
+		}
+		return append(trim(stem, e.ending), []rune(e.replace)...)
+	}
+	return w
+}
+
+// spanishStep1 removes standard suffixes, returning whether one was removed.
+func spanishStep1(w []rune, r1, r2 int) ([]rune, bool) {
+	var suffix string
+	for s := range spanishStep1Suffixes {
+		if len(s) > len(suffix) && hasSuffix(w, s) {
+			suffix = s
+		}
+	}
+	if len(suffix) == 0 {
+		return w, false
+	}
+	action := spanishStep1Suffixes[suffix]
+	if action == spanishAmente {
+		if !in(w, suffix, r1) {
+			return w, false
+		}
+	} else if !in(w, suffix, r2) {
+		return w, false
+	}
+	w = trim(w, suffix)
+	// trimIfR2 removes the first of the preceding suffixes found in R2.
+	trimIfR2 := func(suffixes ...string) bool {
+		for _, s := range suffixes {
+			if hasSuffix(w, s) && in(w, s, r2) {
+				w = trim(w, s)
+				return true
+			}
+		}
+		return false
+	}
+	switch action {
+	case spanishDeleteIC:
+		trimIfR2("ic")
+	case spanishLog:
+		w = append(w, []rune("log")...)
+	case spanishU:
+		w = append(w, 'u')
+	case spanishEnte:
+		w = append(w, []rune("ente")...)
+	case spanishAmente:
+		if trimIfR2("iv") {
+			trimIfR2("at")
+		} else {
+			trimIfR2("os", "ic", "ad")
+		}
+	case spanishMente:
+		trimIfR2("ante", "able", "ible")
+	case spanishIdad:
+		trimIfR2("abil", "ic", "iv")
+	case spanishIv:
+		trimIfR2("at")
+	}
+	return w, true
+}
+
+// spanishStep2a removes verb suffixes beginning with "y", returning whether
+// one was removed.
+func spanishStep2a(w []rune, rv int) ([]rune, bool) {
+	suffix := longestSuffix(w, spanishStep2aSuffixes)
+	if len(suffix) == 0 || !in(w, suffix, rv) {
+		return w, false
+	}
+	if !hasSuffix(trim(w, suffix), "u") {
+		return w, false
+	}
+	return trim(w, suffix), true
+}
+
+// spanishStep2b removes other verb suffixes.
+func spanishStep2b(w []rune, rv int) []rune {
+	gu := longestSuffix(w, spanishStep2bGU)
+	suffix := longestSuffix(w, spanishStep2bSuffixes)
+	if len(gu) > len(suffix) {
+		if !in(w, gu, rv) {
+			return w
+		}
+		w = trim(w, gu)
+		if hasSuffix(w, "gu") {
+			w = trim(w, "u")
+		}
+		return w
+	}
+	if len(suffix) == 0 || !in(w, suffix, rv) {
+		return w
+	}
+	return trim(w, suffix)
+}
+
+// spanishStep3 removes residual suffixes.
+func spanishStep3(w []rune, rv int) []rune {
+	if suffix := longestSuffix(w, spanishStep3Suffixes); len(suffix) > 0 {
+		if in(w, suffix, rv) {
+			w = trim(w, suffix)
+		}
+		return w
+	}
+	for _, s := range []string{"e", "é"} {
+		if !hasSuffix(w, s) || !in(w, s, rv) {
+			continue
+		}
+		w = trim(w, s)
+		if hasSuffix(w, "gu") && in(w, "u", rv) {
+			w = trim(w, "u")
+		}
+		break
+	}
+	return w
+}
+
+var accents = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o",
+	"ú", "u")
+
+func removeAccents(s string) string {
+	return accents.Replace(s)
+}
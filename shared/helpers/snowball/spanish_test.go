@@ -0,0 +1,32 @@
+package snowball
+
+import "testing"
+
+func TestSpanishStem(t *testing.T) {
+	tests := map[string]string{
+		"abandonada":    "abandon",
+		"abandonar":     "abandon",
+		"abarcaba":      "abarc",
+		"acabados":      "acab",
+		"actividades":   "activ",
+		"alegría":       "alegr",
+		"cantidad":      "cantid",
+		"comiendo":      "com",
+		"corriendo":     "corr",
+		"torrente":      "torrent",
+		"restaurante":   "restaur",
+		"restaurantes":  "restaur",
+		"reservar":      "reserv",
+		"reserva":       "reserv",
+		"buscando":      "busc",
+		"comiéndolo":    "com",
+		"rápidamente":   "rapid",
+		"tecnológicas":  "tecnolog",
+		"independencia": "independent",
+	}
+	for word, exp := range tests {
+		if got := Spanish.Stem(word); got != exp {
+			t.Errorf("expected %q to stem to %q, got %q", word, exp, got)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package timeparse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// regexRangeFrom matches explicit ranges such as "from 3 to 5pm" or "from
+// Monday until Wednesday".
+var regexRangeFrom = regexp.MustCompile(
+	`\bfrom\s+(.+?)\s+(?:to|until|till|through|thru)\s+(.+)$`)
+
+// regexRangeBetween matches explicit ranges such as "between Monday and
+// Wednesday".
+var regexRangeBetween = regexp.MustCompile(
+	`\bbetween\s+(.+?)\s+and\s+(.+)$`)
+
+// durationPattern matches durations such as "two hours", "30 mins" or "a
+// day", capturing the quantity and unit.
+const durationPattern = `(\d+|a|an|one|two|three|four|five|six|seven|eight|` +
+	`nine|ten|eleven|twelve)\s+(min|mins|minute|minutes|hr|hrs|hour|hours|` +
+	`day|days|week|weeks)\b`
+
+var regexDuration = regexp.MustCompile(`\b` + durationPattern)
+
+// regexRangeFor matches durations introduced by "for", e.g. "for two hours",
+// which describe a range beginning at the time given, or else now.
+var regexRangeFor = regexp.MustCompile(
+	`\bfor\s+(?:half\s+an\s+hour|` + durationPattern + `)`)
+
+// regexClock matches strings which give a time of day.
+var regexClock = regexp.MustCompile(
+	`\d|\b(?:noon|midnight|morning|afternoon|evening|dinner|supper|now)\b`)
+
+// regexHour matches an hour given alone, e.g. "3" in "from 3 to 5pm".
+var regexHour = regexp.MustCompile(`^\d{1,2}(:\d\d)?$`)
+
+// punctuation is removed from ranges and recurrences before they're parsed.
+var punctuation = strings.NewReplacer(".", "", ",", "", "?", "", "!", "", "(",
+	"", ")", "", "'", "")
+
+// durationWords are the quantities of durations written as words.
+var durationWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11,
+	"twelve": 12,
+}
+
+// durationUnits are the lengths of the units of durations.
+var durationUnits = map[string]time.Duration{
+	"min":     time.Minute,
+	"mins":    time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"hr":      time.Hour,
+	"hrs":     time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"weeks":   7 * 24 * time.Hour,
+}
+
+// ParseRange parses a natural language string to determine the most likely
+// ranges of time based on the current system time. See ParseRangeFromTime.
+func ParseRange(nlTime string) []dt.TimeRange {
+	return ParseRangeFromTime(time.Now(), nlTime)
+}
+
+// ParseRangeFromTime parses a natural language string to determine the most
+// likely ranges of time based on a set time "context." It understands
+// explicit ranges, e.g. "from 3 to 5pm" or "between Monday and Wednesday",
+// durations, e.g. "for two hours", which begin at the time given or else at
+// t, and named periods, e.g. "today", "next week" or "this weekend". The End
+// of each range is exclusive, so the range of "Monday" ends at midnight on
+// Tuesday. Weeks begin on Monday.
+func ParseRangeFromTime(t time.Time, nlTime string) []dt.TimeRange {
+	nlTime = strings.ToLower(punctuation.Replace(nlTime))
+	if m := regexRangeFrom.FindStringSubmatch(nlTime); m != nil {
+		if tr, ok := parseBounds(t, m[1], m[2]); ok {
+			return []dt.TimeRange{tr}
+		}
+	}
+	if m := regexRangeBetween.FindStringSubmatch(nlTime); m != nil {
+		if tr, ok := parseBounds(t, m[1], m[2]); ok {
+			return []dt.TimeRange{tr}
+		}
+	}
+	if loc := regexRangeFor.FindStringIndex(nlTime); loc != nil {
+		d, ok := ParseDuration(nlTime[loc[0]:loc[1]])
+		if ok {
+			start := t
+			rest := nlTime[:loc[0]] + nlTime[loc[1]:]
+			if strings.TrimSpace(rest) != "" {
+				if ts := ParseFromTime(t, rest); len(ts) > 0 {
+					start = ts[0]
+				}
+			}
+			return []dt.TimeRange{newRange(start, start.Add(d))}
+		}
+	}
+	if tr, ok := parsePeriod(t, nlTime); ok {
+		return []dt.TimeRange{tr}
+	}
+	return []dt.TimeRange{}
+}
+
+// ParseDuration parses a natural language duration, such as "for two hours",
+// "30 minutes" or "half an hour", reporting false if none is found.
+func ParseDuration(nlTime string) (time.Duration, bool) {
+	nlTime = strings.ToLower(nlTime)
+	if strings.Contains(nlTime, "half an hour") {
+		return 30 * time.Minute, true
+	}
+	m := regexDuration.FindStringSubmatch(nlTime)
+	if m == nil {
+		return 0, false
+	}
+	n, ok := durationWords[m[1]]
+	if !ok {
+		var err error
+		if n, err = strconv.Atoi(m[1]); err != nil {
+			return 0, false
+		}
+	}
+	return time.Duration(n) * durationUnits[m[2]], true
+}
+
+// parseBounds parses the start and end of an explicit range. Where a bound is
+// ambiguous, e.g. "3" in "from 3 to 5pm", the candidates giving the shortest
+// range are chosen. An end given without a date falls on the start's day,
+// e.g. "from Monday at 3 to 5pm", or the next day if it's earlier than the
+// start, e.g. "from 10pm to 2am". If neither bound gives a time of day, the
+// range spans whole days, e.g. "between Monday and Wednesday".
+func parseBounds(t time.Time, from, to string) (dt.TimeRange, bool) {
+	starts := parseBound(t, from)
+	ends := parseBound(t, to)
+	if len(starts) == 0 || len(ends) == 0 {
+		return dt.TimeRange{}, false
+	}
+	if !regexClock.MatchString(from) && !regexClock.MatchString(to) {
+		start := startOfDay(starts[0])
+		end := startOfDay(ends[0]).AddDate(0, 0, 1)
+		if !end.After(start) {
+			// e.g. "between Friday and Monday", where Monday is
+			// sooner than Friday.
+			end = end.AddDate(0, 0, 7)
+		}
+		if !end.After(start) {
+			return dt.TimeRange{}, false
+		}
+		return newRange(start, end), true
+	}
+	var best dt.TimeRange
+	var bestLen time.Duration
+	var found bool
+	for _, s := range starts {
+		for _, e := range ends {
+			// Either bound may be given without a date, so each is
+			// also tried on the other's day. An end without a date
+			// may fall on the next day, e.g. "from 11pm to 1am".
+			pairs := [][2]time.Time{
+				{s, e},
+				{onDay(s, e), e},
+				{s, onDay(e, s)},
+				{s, onDay(e, s).AddDate(0, 0, 1)},
+			}
+			for _, p := range pairs {
+				if !p[1].After(p[0]) {
+					continue
+				}
+				l := p[1].Sub(p[0])
+				if !found || l < bestLen {
+					best = newRange(p[0], p[1])
+					bestLen, found = l, true
+				}
+			}
+		}
+	}
+	return best, found
+}
+
+// parseBound parses one bound of an explicit range. An hour given alone, e.g.
+// "3", may be in the morning or the afternoon.
+func parseBound(t time.Time, bound string) []time.Time {
+	bound = strings.TrimSpace(bound)
+	if regexHour.MatchString(bound) {
+		return append(ParseFromTime(t, bound+"am"),
+			ParseFromTime(t, bound+"pm")...)
+	}
+	return ParseFromTime(t, bound)
+}
+
+// onDay returns the time of day of t on the day of d.
+func onDay(t, d time.Time) time.Time {
+	return time.Date(d.Year(), d.Month(), d.Day(), t.Hour(), t.Minute(),
+		t.Second(), t.Nanosecond(), t.Location())
+}
+
+// parsePeriod parses named periods, such as "today", "next week" or "this
+// weekend".
+func parsePeriod(t time.Time, nlTime string) (dt.TimeRange, bool) {
+	words := " " + strings.Join(strings.Fields(nlTime), " ") + " "
+	has := func(phrase string) bool {
+		return strings.Contains(words, " "+phrase+" ")
+	}
+	today := startOfDay(t)
+	// Days until the next Monday, i.e. the start of next week.
+	toMonday := (8 - int(t.Weekday())) % 7
+	if toMonday == 0 {
+		toMonday = 7
+	}
+	nextWeek := today.AddDate(0, 0, toMonday)
+	switch {
+	case has("next weekend"):
+		start := nextWeek.AddDate(0, 0, 5)
+		return newRange(start, start.AddDate(0, 0, 2)), true
+	case has("weekend"):
+		start := nextWeek.AddDate(0, 0, -2)
+		if t.After(start) {
+			start = t
+		}
+		return newRange(start, nextWeek), true
+	case has("next week"):
+		return newRange(nextWeek, nextWeek.AddDate(0, 0, 7)), true
+	case has("this week"):
+		return newRange(t, nextWeek), true
+	case has("next month"):
+		start := time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0,
+			t.Location())
+		return newRange(start, start.AddDate(0, 1, 0)), true
+	case has("this month"):
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0,
+			t.Location())
+		return newRange(t, start.AddDate(0, 1, 0)), true
+	case has("tonight"):
+		start := today.Add(18 * time.Hour)
+		if t.After(start) {
+			start = t
+		}
+		return newRange(start, today.AddDate(0, 0, 1)), true
+	case has("tomorrow"):
+		start := today.AddDate(0, 0, 1)
+		return newRange(start, start.AddDate(0, 0, 1)), true
+	case has("today"):
+		return newRange(t, today.AddDate(0, 0, 1)), true
+	}
+	return dt.TimeRange{}, false
+}
+
+func newRange(start, end time.Time) dt.TimeRange {
+	return dt.TimeRange{Start: &start, End: &end}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
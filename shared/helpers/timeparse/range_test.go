@@ -0,0 +1,66 @@
+package timeparse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRangeFromTime(t *testing.T) {
+	// A Wednesday morning.
+	a := time.Date(2016, 3, 9, 10, 0, 0, 0, time.UTC)
+	day := func(d, h int) time.Time {
+		return time.Date(2016, 3, d, h, 0, 0, 0, time.UTC)
+	}
+	tests := map[string][2]time.Time{
+		"from 3 to 5pm":                {day(9, 15), day(9, 17)},
+		"from 3pm until 5pm tomorrow":  {day(10, 15), day(10, 17)},
+		"between Monday and Wednesday": {day(14, 0), day(17, 0)},
+		"between Friday and Monday":    {day(11, 0), day(15, 0)},
+		"next week":                    {day(14, 0), day(21, 0)},
+		"this week":                    {a, day(14, 0)},
+		"this weekend":                 {day(12, 0), day(14, 0)},
+		"next weekend":                 {day(19, 0), day(21, 0)},
+		"tonight":                      {day(9, 18), day(10, 0)},
+		"tomorrow":                     {day(10, 0), day(11, 0)},
+		"for two hours":                {a, a.Add(2 * time.Hour)},
+		"at 3pm for 30 minutes":        {day(9, 15), day(9, 15).Add(30 * time.Minute)},
+		"from 10pm to 2am":             {day(9, 22), day(10, 2)},
+		"from 11pm to 1am":             {day(9, 23), day(10, 1)},
+	}
+	for test, exp := range tests {
+		res := ParseRangeFromTime(a, test)
+		if len(res) == 0 {
+			t.Fatalf("%q: expected %s to %s, got none", test, exp[0],
+				exp[1])
+		}
+		if !res[0].Start.Equal(exp[0]) || !res[0].End.Equal(exp[1]) {
+			t.Fatalf("%q: expected %s to %s, got %s to %s", test,
+				exp[0], exp[1], res[0].Start, res[0].End)
+		}
+	}
+	for _, test := range []string{"", "pizza", "from Jim to Sarah"} {
+		if res := ParseRangeFromTime(a, test); len(res) > 0 {
+			t.Fatalf("%q: expected none, got %s to %s", test,
+				res[0].Start, res[0].End)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"for two hours": 2 * time.Hour,
+		"30 mins":       30 * time.Minute,
+		"a day":         24 * time.Hour,
+		"half an hour":  30 * time.Minute,
+		"3 weeks":       3 * 7 * 24 * time.Hour,
+	}
+	for test, exp := range tests {
+		d, ok := ParseDuration(test)
+		if !ok || d != exp {
+			t.Fatalf("%q: expected %s, got %s", test, exp, d)
+		}
+	}
+	if _, ok := ParseDuration("tomorrow"); ok {
+		t.Fatal(`"tomorrow": expected no duration`)
+	}
+}
@@ -0,0 +1,270 @@
+package timeparse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// regexClockAMPM matches a time of day given with AM or PM, e.g. "5:30pm".
+var regexClockAMPM = regexp.MustCompile(`\b(\d{1,2})(?::(\d\d))?\s*([ap])m\b`)
+
+// regexAtHour matches a time of day given without AM or PM, e.g. "at 9".
+var regexAtHour = regexp.MustCompile(`\bat\s+(\d{1,2})(?::(\d\d))?\b`)
+
+// regexMonthDay matches a day of the month, e.g. "15th".
+var regexMonthDay = regexp.MustCompile(`^(\d{1,2})(?:st|nd|rd|th)$`)
+
+// recurrenceDays maps the names of weekdays, singular and plural, to weekdays.
+var recurrenceDays = map[string]time.Weekday{
+	"monday":     time.Monday,
+	"mondays":    time.Monday,
+	"tuesday":    time.Tuesday,
+	"tuesdays":   time.Tuesday,
+	"wednesday":  time.Wednesday,
+	"wednesdays": time.Wednesday,
+	"thursday":   time.Thursday,
+	"thursdays":  time.Thursday,
+	"friday":     time.Friday,
+	"fridays":    time.Friday,
+	"saturday":   time.Saturday,
+	"saturdays":  time.Saturday,
+	"sunday":     time.Sunday,
+	"sundays":    time.Sunday,
+}
+
+// recurrencePositions maps ordinals preceding a weekday to the position of the
+// weekday within a month, e.g. "first" in "first Monday of each month".
+var recurrencePositions = map[string]int{
+	"first":  1,
+	"1st":    1,
+	"second": 2,
+	"2nd":    2,
+	"third":  3,
+	"3rd":    3,
+	"fourth": 4,
+	"4th":    4,
+	"last":   -1,
+}
+
+// recurrenceHours maps words for parts of the day to the hour at which an
+// event recurs, e.g. "morning" in "every morning".
+var recurrenceHours = map[string]int{
+	"midnight":  0,
+	"morning":   9,
+	"noon":      12,
+	"afternoon": 14,
+	"evening":   18,
+	"night":     20,
+}
+
+// ParseRecurrence parses a natural language string describing a recurring
+// time, returning nil if there's none. See ParseRecurrenceFromTime.
+func ParseRecurrence(nlTime string) *dt.Recurrence {
+	return ParseRecurrenceFromTime(time.Now(), nlTime)
+}
+
+// ParseRecurrenceFromTime parses a natural language string describing a
+// recurring time, such as "every Tuesday at 9", "daily at noon", "weekdays at
+// 8am", "every other week" or "the first Monday of each month", returning nil
+// if there's none. An end to the recurrence, e.g. "until May 1st", is parsed
+// relative to t. An hour given without AM or PM falls during the working day,
+// so "at 9" is 9AM and "at 3" is 3PM, and events recur at 9AM when no time of
+// day is given. Yearly events recur on the date they're first scheduled.
+func ParseRecurrenceFromTime(t time.Time, nlTime string) *dt.Recurrence {
+	nlTime = strings.ToLower(punctuation.Replace(nlTime))
+	words := strings.Fields(nlTime)
+	r := &dt.Recurrence{}
+	var recurs bool
+	addDays := func(days ...time.Weekday) {
+		for _, wd := range days {
+			if !hasWeekday(r.ByDay, wd) {
+				r.ByDay = append(r.ByDay, wd)
+			}
+		}
+	}
+	end := len(words)
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		var next, prev string
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+		if i > 0 {
+			prev = words[i-1]
+		}
+		switch w {
+		case "every", "each":
+			recurs = true
+			if next == "other" {
+				r.Interval = 2
+				i++
+			} else if n := recurrenceInterval(next); n > 1 {
+				r.Interval = n
+				i++
+			}
+			continue
+		case "once":
+			// e.g. "once a week"
+			recurs = recurs || next == "a"
+			continue
+		case "daily", "nightly":
+			recurs, r.Freq = true, dt.FreqDaily
+			continue
+		case "weekly":
+			recurs, r.Freq = true, dt.FreqWeekly
+			continue
+		case "monthly":
+			recurs, r.Freq = true, dt.FreqMonthly
+			continue
+		case "yearly", "annually":
+			recurs, r.Freq = true, dt.FreqYearly
+			continue
+		case "day", "days":
+			r.Freq = dt.FreqDaily
+			continue
+		case "week", "weeks":
+			r.Freq = dt.FreqWeekly
+			continue
+		case "month", "months":
+			r.Freq = dt.FreqMonthly
+			continue
+		case "year", "years":
+			r.Freq = dt.FreqYearly
+			continue
+		case "weekday", "weekdays":
+			recurs = recurs || w == "weekdays"
+			r.Freq = dt.FreqDaily
+			addDays(time.Monday, time.Tuesday, time.Wednesday,
+				time.Thursday, time.Friday)
+			continue
+		case "weekend", "weekends":
+			recurs = recurs || w == "weekends"
+			addDays(time.Saturday, time.Sunday)
+			continue
+		case "until", "till":
+			end = i
+			i = len(words)
+			continue
+		}
+		_, isHour := recurrenceHours[w]
+		if isHour && (prev == "every" || prev == "each") {
+			// e.g. "every morning"
+			r.Freq = dt.FreqDaily
+			continue
+		}
+		if wd, ok := recurrenceDays[w]; ok {
+			// Plural weekdays recur, e.g. "on Tuesdays".
+			recurs = recurs || strings.HasSuffix(w, "s")
+			addDays(wd)
+			continue
+		}
+		if pos, ok := recurrencePositions[w]; ok {
+			if _, ok = recurrenceDays[next]; ok {
+				r.BySetPos = pos
+				continue
+			}
+		}
+		if m := regexMonthDay.FindStringSubmatch(w); m != nil {
+			r.ByMonthDay, _ = strconv.Atoi(m[1])
+		}
+	}
+	if !recurs {
+		return nil
+	}
+	if r.Freq == dt.FreqOnce {
+		switch {
+		case r.BySetPos != 0 || r.ByMonthDay != 0:
+			r.Freq = dt.FreqMonthly
+		case len(r.ByDay) > 0:
+			r.Freq = dt.FreqWeekly
+		default:
+			return nil
+		}
+	}
+	r.ByHour, r.ByMinute = recurrenceTime(strings.Join(words[:end], " "))
+	if end < len(words) {
+		until := strings.Join(words[end+1:], " ")
+		if ts := ParseFromTime(t, until); len(ts) > 0 {
+			u := ts[0]
+			if !hasTimeOfDay(until) {
+				// Include the whole of the last day.
+				u = startOfDay(u).AddDate(0, 0, 1).Add(-time.Second)
+			}
+			r.Until = &u
+		}
+	}
+	return r
+}
+
+// recurrenceInterval returns the number of periods between occurrences given
+// after "every", e.g. 2 for "two" in "every two weeks", or 0 if the word isn't
+// a number.
+func recurrenceInterval(word string) int {
+	if word == "a" || word == "an" {
+		return 0
+	}
+	if n, ok := durationWords[word]; ok {
+		return n
+	}
+	n, err := strconv.Atoi(word)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// recurrenceTime returns the time of day at which an event recurs, defaulting
+// to 9AM.
+func recurrenceTime(nlTime string) (hour, minute int) {
+	if m := regexClockAMPM.FindStringSubmatch(nlTime); m != nil {
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		hour %= 12
+		if m[3] == "p" {
+			hour += 12
+		}
+		return hour, minute
+	}
+	if m := regexAtHour.FindStringSubmatch(nlTime); m != nil {
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		if hour >= 1 && hour <= 7 {
+			hour += 12
+		}
+		return hour, minute
+	}
+	for _, w := range strings.Fields(nlTime) {
+		if h, ok := recurrenceHours[w]; ok {
+			return h, 0
+		}
+	}
+	return 9, 0
+}
+
+// hasTimeOfDay reports whether a string gives a time of day, e.g. "5pm" or
+// "noon", rather than only a date.
+func hasTimeOfDay(nlTime string) bool {
+	if regexClockAMPM.MatchString(nlTime) || regexAtHour.MatchString(nlTime) {
+		return true
+	}
+	for _, w := range strings.Fields(nlTime) {
+		if _, ok := recurrenceHours[w]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasWeekday reports whether a weekday is among days.
+func hasWeekday(days []time.Weekday, wd time.Weekday) bool {
+	for _, d := range days {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
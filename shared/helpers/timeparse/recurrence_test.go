@@ -0,0 +1,39 @@
+package timeparse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceFromTime(t *testing.T) {
+	a := time.Date(2016, 3, 9, 10, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"every Tuesday at 9":          "FREQ=WEEKLY;BYDAY=TU;BYHOUR=9;BYMINUTE=0",
+		"daily at noon":               "FREQ=DAILY;BYHOUR=12;BYMINUTE=0",
+		"first Monday of each month":  "FREQ=MONTHLY;BYDAY=MO;BYSETPOS=1;BYHOUR=9;BYMINUTE=0",
+		"last Friday of every month":  "FREQ=MONTHLY;BYDAY=FR;BYSETPOS=-1;BYHOUR=9;BYMINUTE=0",
+		"on the 15th of every month":  "FREQ=MONTHLY;BYMONTHDAY=15;BYHOUR=9;BYMINUTE=0",
+		"every other week":            "FREQ=WEEKLY;INTERVAL=2;BYHOUR=9;BYMINUTE=0",
+		"every 3 days at 5:30pm":      "FREQ=DAILY;INTERVAL=3;BYHOUR=17;BYMINUTE=30",
+		"Tuesdays and Thursdays at 3": "FREQ=WEEKLY;BYDAY=TU,TH;BYHOUR=15;BYMINUTE=0",
+		"weekdays at 8am":             "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;BYHOUR=8;BYMINUTE=0",
+		"every morning":               "FREQ=DAILY;BYHOUR=9;BYMINUTE=0",
+		"once a week":                 "FREQ=WEEKLY;BYHOUR=9;BYMINUTE=0",
+		"every day until March 31st":  "FREQ=DAILY;BYHOUR=9;BYMINUTE=0;UNTIL=20160331T235959Z",
+	}
+	for test, exp := range tests {
+		r := ParseRecurrenceFromTime(a, test)
+		if r == nil {
+			t.Fatalf("%q: expected %s, got none", test, exp)
+		}
+		if got := r.String(); got != exp {
+			t.Fatalf("%q: expected %s, got %s", test, exp, got)
+		}
+	}
+	for _, test := range []string{"tomorrow at 9", "in a week",
+		"next Tuesday", "this weekend"} {
+		if r := ParseRecurrenceFromTime(a, test); r != nil {
+			t.Fatalf("%q: expected none, got %s", test, r)
+		}
+	}
+}
@@ -4,6 +4,7 @@ package timeparse
 
 import (
 	"errors"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -54,6 +55,12 @@ type TimeContext struct {
 // shared/helpers/timeparse.
 var ErrInvalidTimeFormat = errors.New("invalid time format")
 
+// regexAMPM matches "am" and "pm" following a number, e.g. "2 pm".
+var regexAMPM = regexp.MustCompile(`(\d)\s?([ap]m)\b`)
+
+// regexOrdinal matches the suffixes of ordinal numbers, e.g. "th" in "26th".
+var regexOrdinal = regexp.MustCompile(`(\d)(st|nd|rd|th)\b`)
+
 const (
 	noDay  = false
 	hasDay = true
@@ -112,7 +119,8 @@ func Parse(nlTime string) []time.Time {
 
 // ParseFromTime parses a natural language string to determine most likely times
 // based on a set time "context." The time context changes the meaning of words
-// like "this Tuesday," "next Tuesday," etc.
+// like "this Tuesday," "next Tuesday," etc. Relative times, such as "an hour
+// later," "the day after" or "same time next week," are relative to it.
 func ParseFromTime(t time.Time, nlTime string) []time.Time {
 	r := strings.NewReplacer(
 		".", "",
@@ -123,29 +131,17 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 	)
 	nlTime = r.Replace(nlTime)
 	nlTime = strings.ToLower(nlTime)
-	r = strings.NewReplacer(
-		"at ", "",
-		"time", "",
-		"oclock", "",
-		"am", "AM",
-		"pm", "PM",
-		" am", "AM",
-		" pm", "PM",
-
-		// 1st, 2nd, 3rd, 4th, 5th, etc.
-		"1st", "1",
-		"2nd", "2",
-		"3rd", "3",
-		"th", "",
-		"21st", "21",
-		"22nd", "22",
-		"23rd", "23",
-		"31st", "31",
-	)
-	nlTime = r.Replace(nlTime)
+
+	// AM and PM, and the suffixes of ordinals (1st, 2nd, 3rd, 4th, etc.),
+	// are only replaced following a number, so words like "same" and
+	// "that" are left intact.
+	nlTime = regexAMPM.ReplaceAllStringFunc(nlTime, func(m string) string {
+		return strings.ToUpper(strings.Replace(m, " ", "", 1))
+	})
+	nlTime = regexOrdinal.ReplaceAllString(nlTime, "$1")
 	nlTime = strings.Title(nlTime)
 	if nlTime == "Now" {
-		return []time.Time{time.Now()}
+		return []time.Time{t}
 	}
 	st := strings.Fields(nlTime)
 	transform := struct {
@@ -158,6 +154,8 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 	stFull := ""
 	var closeTime bool
 	var idxRel int
+	idxArticle := -1
+	weekday := time.Weekday(-1)
 	var loc *time.Location
 	for i := range st {
 		// Normalize days
@@ -165,24 +163,31 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 		case "Monday":
 			st[i] = "Mon"
 			transform.Type = transformDay
+			weekday = time.Monday
 		case "Tuesday", "Tues":
 			st[i] = "Tue"
 			transform.Type = transformDay
+			weekday = time.Tuesday
 		case "Wednesday":
 			st[i] = "Wed"
 			transform.Type = transformDay
+			weekday = time.Wednesday
 		case "Thursday", "Thur", "Thurs":
 			st[i] = "Thu"
 			transform.Type = transformDay
+			weekday = time.Thursday
 		case "Friday":
 			st[i] = "Fri"
 			transform.Type = transformDay
+			weekday = time.Friday
 		case "Saturday":
 			st[i] = "Sat"
 			transform.Type = transformDay
+			weekday = time.Saturday
 		case "Sunday":
 			st[i] = "Sun"
 			transform.Type = transformDay
+			weekday = time.Sunday
 
 		// Normalize months
 		case "January":
@@ -258,6 +263,23 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 			st[i] = ""
 			transform.Transform = 1
 			transform.Multiplier *= -1
+		// e.g. "An hour earlier" or "the day before"
+		case "Earlier", "Before":
+			st[i] = ""
+			transform.Transform = 1
+			transform.Multiplier *= -1
+		// e.g. "The day after"
+		case "After":
+			st[i] = ""
+			transform.Transform = 1
+		// e.g. "An hour later", where "An" counts one hour.
+		case "A", "An":
+			st[i] = ""
+			idxArticle = i
+		// e.g. "Same time next week" keeps the time of day.
+		case "Same":
+			st[i] = ""
+			closeTime = true
 		// e.g. "In an hour"
 		case "Next", "From", "Now", "In":
 			st[i] = ""
@@ -298,11 +320,15 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 
 		// Remove unnecessary but common expressions like "at", "time",
 		// "oclock".
-		case "At", "Time", "Oclock", "This", "The":
+		case "At", "Time", "Oclock", "This", "That", "The":
 			st[i] = ""
 		case "Noon":
 			st[i] = "12PM"
-		case "Supper", "Dinner":
+		case "Morning":
+			st[i] = "9AM"
+		case "Afternoon":
+			st[i] = "2PM"
+		case "Supper", "Dinner", "Evening":
 			st[i] = "6PM"
 		}
 
@@ -318,12 +344,12 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 	if err != nil {
 		// Set the hour to 9am
 		timeEmpty = true
-		tme = time.Now().Round(time.Hour)
+		tme = t.Round(time.Hour)
 		val := 9 - tme.Hour()
 		tme = tme.Add(time.Duration(val) * time.Hour)
 	}
 	if closeTime {
-		tme = time.Now().Round(time.Minute)
+		tme = t.Round(time.Minute)
 	}
 	ts = append(ts, tme)
 
@@ -383,6 +409,23 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 		}
 	}
 
+	// Move each time to the day of the week named, e.g. "Monday", which is
+	// the next such day on or after t. "Next Monday" is never the same
+	// day as t, and "last Monday" is the last such day before t.
+	if weekday >= 0 && transform.Type == transformDay && idxRel == 0 {
+		days := (int(weekday) - int(t.Weekday()) + 7) % 7
+		switch {
+		case transform.Multiplier < 0:
+			days -= 7
+		case transform.Transform > 0 && days == 0:
+			days = 7
+		}
+		for i := range ts {
+			ts[i] = ts[i].AddDate(0, 0, days)
+		}
+		return ts
+	}
+
 	// If there's no relative transform, we're done.
 	if transform.Type == transformInvalid {
 		if timeEmpty {
@@ -400,6 +443,8 @@ func ParseFromTime(t time.Time, nlTime string) []time.Time {
 		val, err := strconv.Atoi(st[idxRel-1])
 		if err == nil {
 			transform.Transform = val
+		} else if idxRel-1 == idxArticle {
+			transform.Transform = 1
 		}
 	}
 
@@ -76,3 +76,24 @@ func BenchmarkParse(b *testing.B) {
 		_ = Parse("2 p.m. tomorrow")
 	}
 }
+
+func TestParseFromTime(t *testing.T) {
+	a := time.Date(2016, 3, 11, 19, 30, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"an hour later":        a.Add(time.Hour),
+		"2 hours later":        a.Add(2 * time.Hour),
+		"an hour earlier":      a.Add(-time.Hour),
+		"same time next week":  a.AddDate(0, 0, 7),
+		"the day after":        time.Date(2016, 3, 12, 9, 0, 0, 0, time.UTC),
+		"earlier that morning": time.Date(2016, 3, 11, 9, 0, 0, 0, time.UTC),
+	}
+	for test, exp := range tests {
+		res := ParseFromTime(a, test)
+		if len(res) == 0 {
+			t.Fatalf("%q: expected %s, got none", test, exp)
+		}
+		if !res[0].Equal(exp) {
+			t.Fatalf("%q: expected %s, got %s", test, exp, res[0])
+		}
+	}
+}
@@ -85,3 +85,13 @@ const (
 	RecurringFreqMonthly
 	RecurringFreqYearly
 )
+
+// RecurringFreqOf returns how often events with a given recurrence occur, such
+// as one parsed by timeparse.ParseRecurrence. Events without a recurrence occur
+// once.
+func RecurringFreqOf(r *dt.Recurrence) RecurringFreq {
+	if r == nil {
+		return RecurringFreqOnce
+	}
+	return RecurringFreq(r.Freq)
+}
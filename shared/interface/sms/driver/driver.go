@@ -22,6 +22,14 @@ type Conn interface {
 	Close() error
 }
 
+// MediaConn is an optional interface implemented by SMS drivers which support
+// MMS. Drivers which don't implement it have media links appended to the body
+// of the message instead.
+type MediaConn interface {
+	// SendMedia sends an MMS with the given media URLs attached.
+	SendMedia(to, msg string, mediaURLs []string) error
+}
+
 // SMS defines an interface with basic getters to interact with an SMS message.
 type SMS interface {
 	// From is the sending phone number.
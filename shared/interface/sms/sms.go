@@ -6,6 +6,7 @@ package sms
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/itsabot/abot/shared/interface/sms/driver"
@@ -77,3 +78,16 @@ func (c *Conn) Driver() driver.Driver {
 func (c *Conn) Send(to, msg string) error {
 	return c.conn.Send(to, msg)
 }
+
+// SendMedia sends an MMS message with the given media URLs attached through an
+// opened driver connection. If the driver doesn't support MMS, the media URLs
+// are appended to the message and sent as an SMS.
+func (c *Conn) SendMedia(to, msg string, mediaURLs []string) error {
+	if mc, ok := c.conn.(driver.MediaConn); ok {
+		return mc.SendMedia(to, msg, mediaURLs)
+	}
+	if len(mediaURLs) > 0 {
+		msg += "\n" + strings.Join(mediaURLs, "\n")
+	}
+	return c.conn.Send(to, msg)
+}
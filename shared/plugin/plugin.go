@@ -10,10 +10,10 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/dchest/stemmer/porter2"
 	"github.com/itsabot/abot/core"
 	"github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/snowball"
 	"github.com/itsabot/abot/shared/language"
 	_ "github.com/lib/pq" // Import the pq PostgreSQL driver
 )
@@ -89,11 +89,16 @@ makePlugin:
 	plg := &dt.Plugin{
 		Trigger:     &dt.StructuredInput{},
 		SetBranches: func(in *dt.Msg) [][]dt.State { return nil },
+		Decline:     func(in *dt.Msg) bool { return false },
+		Triggers:    map[string]*dt.StructuredInput{},
 		Events: &dt.PluginEvents{
 			PostReceive:    func(cmd *string) {},
 			PreProcessing:  func(cmd *string, u *dt.User) {},
 			PostProcessing: func(in *dt.Msg) {},
 			PreResponse:    func(in *dt.Msg, resp *string) {},
+			SessionStart:   func(in *dt.Msg) {},
+			SessionEnd:     func(in *dt.Msg) {},
+			OnError:        func(in *dt.Msg, err error) {},
 		},
 		Config: c,
 		DB:     db,
@@ -106,32 +111,19 @@ makePlugin:
 // Register enables Abot to notify plugins when specific StructuredInput is
 // encountered matching triggers set in the plugins themselves. Note that
 // plugins will only listen when (Command and Object) or (Intent) criteria are
-// met. There's no support currently for duplicate routes, e.g.
-// "find_restaurant" leading to either one of two plugins.
+// met. Multiple plugins may share a route, e.g. "find_restaurant" leading to
+// either one of two plugins. In that case the plugin with the highest Priority
+// in its plugin.json is tried first, and the next is tried if it declines the
+// message.
 func Register(p *dt.Plugin) error {
 	p.Log.Debug("registering", p.Config.Name)
 	for _, i := range p.Trigger.Intents {
 		s := "I_" + strings.ToLower(i)
-		oldPlg := core.RegPlugins.Get(s)
-		if oldPlg != nil && oldPlg.Config.Name != p.Config.Name {
-			p.Log.Infof("found duplicate plugin or trigger %s on %s",
-				p.Config.Name, s)
-		}
 		core.RegPlugins.Set(s, p)
 	}
-	eng := porter2.Stemmer
-	for _, c := range p.Trigger.Commands {
-		c = strings.ToLower(eng.Stem(c))
-		for _, o := range p.Trigger.Objects {
-			o = strings.ToLower(eng.Stem(o))
-			s := "CO_" + c + "_" + o
-			oldPlg := core.RegPlugins.Get(s)
-			if oldPlg != nil && oldPlg.Config.Name != p.Config.Name {
-				p.Log.Info("found duplicate plugin or trigger",
-					p.Config.Name, "on", s)
-			}
-			core.RegPlugins.Set(s, p)
-		}
+	registerCO(p, p.Trigger, snowball.English)
+	for lang, t := range p.Triggers {
+		registerCO(p, t, snowball.For(lang))
 	}
 
 	// registerPlugin is called whenever Keywords or Triggers are changed,
@@ -147,10 +139,31 @@ func Register(p *dt.Plugin) error {
 	return nil
 }
 
+// registerCO registers a plugin for each pair of Command and Object in a
+// trigger, stemming them with the trigger language's stemmer.
+func registerCO(p *dt.Plugin, t *dt.StructuredInput, stemmer snowball.Stemmer) {
+	for _, c := range t.Commands {
+		c = strings.ToLower(stemmer.Stem(c))
+		for _, o := range t.Objects {
+			o = strings.ToLower(stemmer.Stem(o))
+			s := "CO_" + c + "_" + o
+			core.RegPlugins.Set(s, p)
+		}
+	}
+}
+
 // SetKeywords processes and registers keywords with Abot's core for routing.
 func SetKeywords(p *dt.Plugin, khs ...dt.KeywordHandler) {
 	p.Keywords = &dt.Keywords{
-		Dict: map[string]dt.KeywordFn{},
+		Dict:    map[string]dt.KeywordFn{},
+		DictCtx: map[string]dt.KeywordFnCtx{},
+	}
+	set := func(key string, kh dt.KeywordHandler) {
+		if kh.FnCtx != nil {
+			p.Keywords.DictCtx[key] = kh.FnCtx
+			return
+		}
+		p.Keywords.Dict[key] = kh.Fn
 	}
 	for _, kh := range khs {
 		for _, intent := range kh.Trigger.Intents {
@@ -160,12 +173,17 @@ func SetKeywords(p *dt.Plugin, khs ...dt.KeywordHandler) {
 			}
 			key := "I_" + intent
 			_, exists := p.Keywords.Dict[key]
-			if exists {
+			_, existsCtx := p.Keywords.DictCtx[key]
+			if exists || existsCtx {
 				continue
 			}
-			p.Keywords.Dict[key] = kh.Fn
+			set(key, kh)
+		}
+		if len(kh.Language) > 0 && kh.Language != dt.LangEnglish {
+			setLanguageKeywords(p, kh, set)
+			continue
 		}
-		eng := porter2.Stemmer
+		eng := snowball.English
 		for _, cmd := range kh.Trigger.Commands {
 			cmd = strings.ToLower(eng.Stem(cmd))
 			if !language.Contains(p.Trigger.Commands, cmd) {
@@ -177,8 +195,40 @@ func SetKeywords(p *dt.Plugin, khs ...dt.KeywordHandler) {
 					p.Trigger.Objects = append(p.Trigger.Objects, obj)
 				}
 				key := "CO_" + cmd + "_" + obj
-				p.Keywords.Dict[key] = kh.Fn
+				delete(p.Keywords.Dict, key)
+				delete(p.Keywords.DictCtx, key)
+				set(key, kh)
+			}
+		}
+	}
+}
+
+// setLanguageKeywords processes the Commands and Objects of a keyword handler
+// in a language other than English. They're added to the plugin's trigger for
+// that language and stemmed when the plugin registers.
+func setLanguageKeywords(p *dt.Plugin, kh dt.KeywordHandler,
+	set func(key string, kh dt.KeywordHandler)) {
+
+	t, ok := p.Triggers[kh.Language]
+	if !ok {
+		t = &dt.StructuredInput{}
+		p.Triggers[kh.Language] = t
+	}
+	stemmer := snowball.For(kh.Language)
+	for _, cmd := range kh.Trigger.Commands {
+		cmd = strings.ToLower(cmd)
+		if !language.Contains(t.Commands, cmd) {
+			t.Commands = append(t.Commands, cmd)
+		}
+		for _, obj := range kh.Trigger.Objects {
+			obj = strings.ToLower(obj)
+			if !language.Contains(t.Objects, obj) {
+				t.Objects = append(t.Objects, obj)
 			}
+			key := "CO_" + stemmer.Stem(cmd) + "_" + stemmer.Stem(obj)
+			delete(p.Keywords.Dict, key)
+			delete(p.Keywords.DictCtx, key)
+			set(key, kh)
 		}
 	}
 }
@@ -195,7 +245,7 @@ func SetStates(p *dt.Plugin, states [][]dt.State) {
 // AppendTrigger appends the StructuredInput's modified contents to a plugin.
 // All Commands and Objects stemmed using the Porter2 Snowball algorithm.
 func AppendTrigger(p *dt.Plugin, t *dt.StructuredInput) {
-	eng := porter2.Stemmer
+	eng := snowball.English
 	for _, cmd := range t.Commands {
 		cmd = eng.Stem(cmd)
 		if !language.Contains(p.Trigger.Commands, cmd) {